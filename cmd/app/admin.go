@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"app/internal/audit"
+	"app/internal/core"
+	"app/internal/db"
+	"app/internal/user"
+	"app/pkg/session"
+)
+
+// The admin commands act on users, roles and sessions through the same
+// services as the web UI, so rules and audit events are the same. Flags come
+// before the arguments, for example: app user list -format json -status inactive
+
+const userUsage = `usage:
+  app user create [-name name] [-email email] [-password password] [-role role]...
+  app user list [-search text] [-status status] [-role role] [-include-deleted] [-sort column] [-page-size n] [-cursor cursor]
+  app user set-status <user> active|inactive
+  app user set-password [-password password] <user>
+
+Users are given by id or email. Passwords are read from stdin when not given;
+setting one signs the user out everywhere.
+Every command accepts -format table|json.`
+
+const roleUsage = `usage:
+  app role grant <user> <role>
+  app role revoke <user> <role>
+
+Roles are given by id or name. Every command accepts -format table|json.`
+
+const sessionUsage = `usage:
+  app session revoke -user <user>
+
+Ends every session of the user and forgets its remember-me tokens.`
+
+// admin holds the services the admin commands need.
+type admin struct {
+	db       *db.DB
+	users    *user.UserService
+	sessions *session.Manager
+	ctx      context.Context
+	format   string
+	out      io.Writer
+}
+
+func newAdmin(format string) (*admin, error) {
+	if format != "table" && format != "json" {
+		return nil, fmt.Errorf("unknown format %q, use table or json", format)
+	}
+	database := setup()
+	if err := applyMigrations(database); err != nil {
+		database.Close()
+		return nil, err
+	}
+	repos := newRepositories(database)
+	auditService := audit.New(repos.audit)
+	sessions := session.New(&session.Options{
+		Repository: repos.sessions,
+		Remember: &session.RememberOptions{
+			Repository: repos.remember,
+		},
+		Hooks: sessionAuditHooks(auditService),
+	})
+	return &admin{
+		db:       database,
+		users:    cliUserService(database, auditService, sessions),
+		sessions: sessions,
+		ctx:      cliContext(),
+		format:   format,
+		out:      os.Stdout,
+	}, nil
+}
+
+func (a *admin) Close() error {
+	return a.db.Close()
+}
+
+// findUser looks a user up by email when arg contains an @, by id otherwise.
+func (a *admin) findUser(arg string) (*user.User, error) {
+	if strings.Contains(arg, "@") {
+		return a.users.FindByEmail(a.ctx, arg)
+	}
+	return a.users.Find(a.ctx, arg)
+}
+
+// findRole looks a role up by name, then by id.
+func (a *admin) findRole(arg string) (*user.Role, error) {
+	role, err := a.users.FindRoleByName(a.ctx, arg)
+	if errors.Is(err, user.ErrRoleNotFound) {
+		return a.users.FindRole(a.ctx, arg)
+	}
+	return role, err
+}
+
+// print writes v as indented JSON, or as a table of the rows.
+func (a *admin) print(v any, header []string, rows [][]string) error {
+	if a.format == "json" {
+		enc := json.NewEncoder(a.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func (a *admin) printUsers(v any, users ...user.User) error {
+	rows := make([][]string, len(users))
+	for i, u := range users {
+		rows[i] = []string{u.Id, u.Email, u.Name, string(u.Status), strings.Join(roleNames(u.Roles), ","), u.CreatedAt.Local().Format(time.DateTime)}
+	}
+	return a.print(v, []string{"ID", "EMAIL", "NAME", "STATUS", "ROLES", "CREATED"}, rows)
+}
+
+func roleNames(roles []user.Role) []string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = role.Name
+	}
+	return names
+}
+
+// readPassword returns flagValue, or the first line of stdin.
+func readPassword(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("reading the password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func userCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return fmt.Errorf("missing user command")
+	}
+	fs := flag.NewFlagSet("user "+args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), userUsage)
+	}
+	format := fs.String("format", "table", "output format, table or json")
+	switch args[0] {
+	case "create":
+		name := fs.String("name", "", "name of the user")
+		email := fs.String("email", "", "email of the user")
+		password := fs.String("password", "", "password of the user, read from stdin when empty")
+		var roles stringList
+		fs.Var(&roles, "role", "role to grant, can be repeated")
+		fs.Parse(args[1:])
+		return withAdmin(*format, func(a *admin) error {
+			return a.createUser(*name, *email, *password, roles)
+		})
+	case "list":
+		search := fs.String("search", "", "words matching the name or email")
+		status := fs.String("status", "", "active, inactive or deleted")
+		role := fs.String("role", "", "only users having this role")
+		includeDeleted := fs.Bool("include-deleted", false, "list soft-deleted users too")
+		sort := fs.String("sort", "", "id, email or created_at, prefixed with - for descending")
+		pageSize := fs.Int("page-size", 50, "users per page")
+		cursor := fs.String("cursor", "", "cursor of the next page, as printed by the previous one")
+		fs.Parse(args[1:])
+		return withAdmin(*format, func(a *admin) error {
+			req := user.ListRequest{
+				PageSize:       *pageSize,
+				Search:         *search,
+				IncludeDeleted: *includeDeleted || *status == string(user.UserStatusDeleted),
+				Status:         user.UserStatus(*status),
+				Sort:           *sort,
+				Cursor:         *cursor,
+			}
+			if *role != "" {
+				r, err := a.findRole(*role)
+				if err != nil {
+					return err
+				}
+				req.RoleId = r.Id
+			}
+			return a.listUsers(req)
+		})
+	case "set-status":
+		fs.Parse(args[1:])
+		if fs.NArg() != 2 {
+			fs.Usage()
+			return fmt.Errorf("set-status needs a user and a status")
+		}
+		return withAdmin(*format, func(a *admin) error {
+			return a.setStatus(fs.Arg(0), user.UserStatus(fs.Arg(1)))
+		})
+	case "set-password":
+		password := fs.String("password", "", "new password, read from stdin when empty")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return fmt.Errorf("set-password needs a user")
+		}
+		return withAdmin(*format, func(a *admin) error {
+			return a.setPassword(fs.Arg(0), *password)
+		})
+	default:
+		fmt.Fprintln(os.Stderr, userUsage)
+		return fmt.Errorf("unknown user command %q", args[0])
+	}
+}
+
+func roleCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, roleUsage)
+		return fmt.Errorf("missing role command")
+	}
+	fs := flag.NewFlagSet("role "+args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), roleUsage)
+	}
+	format := fs.String("format", "table", "output format, table or json")
+	switch args[0] {
+	case "grant", "revoke":
+		fs.Parse(args[1:])
+		if fs.NArg() != 2 {
+			fs.Usage()
+			return fmt.Errorf("%s needs a user and a role", args[0])
+		}
+		return withAdmin(*format, func(a *admin) error {
+			return a.changeRole(fs.Arg(0), fs.Arg(1), args[0] == "grant")
+		})
+	default:
+		fmt.Fprintln(os.Stderr, roleUsage)
+		return fmt.Errorf("unknown role command %q", args[0])
+	}
+}
+
+func sessionCommand(args []string) error {
+	if len(args) == 0 || args[0] != "revoke" {
+		fmt.Fprintln(os.Stderr, sessionUsage)
+		if len(args) == 0 {
+			return fmt.Errorf("missing session command")
+		}
+		return fmt.Errorf("unknown session command %q", args[0])
+	}
+	fs := flag.NewFlagSet("session revoke", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), sessionUsage)
+	}
+	format := fs.String("format", "table", "output format, table or json")
+	userArg := fs.String("user", "", "id or email of the user")
+	fs.Parse(args[1:])
+	if *userArg == "" {
+		fs.Usage()
+		return fmt.Errorf("session revoke needs -user")
+	}
+	return withAdmin(*format, func(a *admin) error {
+		return a.revokeSessions(*userArg)
+	})
+}
+
+func withAdmin(format string, fn func(a *admin) error) error {
+	a, err := newAdmin(format)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return fn(a)
+}
+
+func (a *admin) createUser(name, email, password string, roleArgs []string) error {
+	roles := make([]user.Role, 0, len(roleArgs))
+	for _, arg := range roleArgs {
+		role, err := a.findRole(arg)
+		if err != nil {
+			return fmt.Errorf("role %s: %w", arg, err)
+		}
+		roles = append(roles, *role)
+	}
+	password, err := readPassword(password)
+	if err != nil {
+		return err
+	}
+	u, errs, err := a.users.StoreUser(a.ctx, &user.CreateUserRequest{
+		Name:          name,
+		Email:         email,
+		Password:      password,
+		PasswordCheck: password,
+		Roles:         roles,
+	})
+	if err != nil {
+		return fmt.Errorf("user: %w", core.WithFields(err, errs))
+	}
+	return a.printUsers(u, *u)
+}
+
+func (a *admin) listUsers(req user.ListRequest) error {
+	res, errs, err := a.users.ListUsers(a.ctx, req)
+	if err != nil {
+		return fmt.Errorf("list: %w", core.WithFields(err, errs))
+	}
+	if err := a.printUsers(res, res.Users...); err != nil {
+		return err
+	}
+	if a.format == "table" && res.NextCursor != "" {
+		fmt.Fprintf(os.Stderr, "%d users, next page: -cursor %s\n", res.Total, res.NextCursor)
+	}
+	return nil
+}
+
+func (a *admin) setStatus(userArg string, status user.UserStatus) error {
+	if status != user.UserStatusActive && status != user.UserStatusInactive {
+		return fmt.Errorf("status must be active or inactive")
+	}
+	u, err := a.findUser(userArg)
+	if err != nil {
+		return err
+	}
+	if err := a.users.ChangeStatus(a.ctx, u, status); err != nil {
+		return err
+	}
+	return a.printUsers(u, *u)
+}
+
+func (a *admin) setPassword(userArg, password string) error {
+	u, err := a.findUser(userArg)
+	if err != nil {
+		return err
+	}
+	password, err = readPassword(password)
+	if err != nil {
+		return err
+	}
+	if errs, err := a.users.SetPassword(a.ctx, u, password); err != nil {
+		return fmt.Errorf("password: %w", core.WithFields(err, errs))
+	}
+	// Sessions opened with the old password must not outlive it.
+	if err := a.sessions.DestroyOtherSessions(a.ctx, u.Id, ""); err != nil {
+		return err
+	}
+	return a.printUsers(u, *u)
+}
+
+func (a *admin) changeRole(userArg, roleArg string, grant bool) error {
+	u, err := a.findUser(userArg)
+	if err != nil {
+		return err
+	}
+	role, err := a.findRole(roleArg)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(u.Roles)+1)
+	has := false
+	for _, r := range u.Roles {
+		if r.Id == role.Id {
+			has = true
+			if !grant {
+				continue
+			}
+		}
+		ids = append(ids, r.Id)
+	}
+	if grant && !has {
+		ids = append(ids, role.Id)
+	}
+	if grant != has {
+		errs, err := a.users.UpdateUser(a.ctx, u, &user.UpdateUserRequest{
+			Name:    u.Name,
+			RoleIds: ids,
+		})
+		if err != nil {
+			return fmt.Errorf("user: %w", core.WithFields(err, errs))
+		}
+	}
+	return a.printUsers(u, *u)
+}
+
+func (a *admin) revokeSessions(userArg string) error {
+	u, err := a.findUser(userArg)
+	if err != nil {
+		return err
+	}
+	sessions, err := a.sessions.UserSessions(a.ctx, u.Id)
+	if err != nil {
+		return err
+	}
+	if err := a.sessions.DestroyOtherSessions(a.ctx, u.Id, ""); err != nil {
+		return err
+	}
+	result := struct {
+		UserId  string `json:"user_id"`
+		Revoked int    `json:"revoked"`
+	}{u.Id, len(sessions)}
+	return a.print(result, []string{"USER", "REVOKED"}, [][]string{{u.Email, fmt.Sprint(len(sessions))}})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"app/db/migrations"
+	"app/internal/core"
+	"app/internal/db"
+	"app/internal/user"
+)
+
+// benchUsers compares the ways of listing users with their roles on a
+// throwaway database of the given size. The per-row variant issues the
+// queries the repository used to, one for the page and one per user.
+func benchUsers(args []string) error {
+	fs := flag.NewFlagSet("bench-users", flag.ExitOnError)
+	count := fs.Int("users", 100_000, "number of users to seed")
+	pageSize := fs.Int("page-size", 50, "users per page")
+	fs.Parse(args)
+
+	dir, err := os.MkdirTemp("", "bench-users")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	database, err := sql.Open("sqlite3", filepath.Join(dir, "bench.db")+"?_foreign_keys=on")
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	fsys, err := migrations.For(db.DriverSqlite)
+	if err != nil {
+		return err
+	}
+	migrator, err := db.NewMigrator(database, db.DriverSqlite, fsys)
+	if err != nil {
+		return err
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := seedBenchUsers(database, *count); err != nil {
+		return err
+	}
+	fmt.Printf("seeded %d users in %s\n", *count, time.Since(start).Round(time.Millisecond))
+
+	ctx := context.Background()
+	repo := user.NewUserRepositorySqlite(database)
+	// The first page, read without filters, keeps the OFFSET scan and the
+	// sort out of the numbers so that only loading roles differs.
+	list := func(skipRoles bool) (*user.ListUserResponse, error) {
+		return repo.ListUsers(ctx, user.ListRequest{Page: 1, PageSize: *pageSize, IncludeDeleted: true, SkipRoles: skipRoles})
+	}
+	benchmarks := []struct {
+		name string
+		list func() error
+	}{
+		{"per-row roles", func() error {
+			res, err := list(true)
+			if err != nil {
+				return err
+			}
+			for i := range res.Users {
+				if res.Users[i].Roles, err = repo.GetUserRoles(ctx, res.Users[i].Id); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"batched roles", func() error {
+			_, err := list(false)
+			return err
+		}},
+		{"without roles", func() error {
+			_, err := list(true)
+			return err
+		}},
+	}
+	for _, bm := range benchmarks {
+		var failed error
+		res := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := bm.list(); err != nil {
+					failed = err
+					b.FailNow()
+				}
+			}
+		})
+		if failed != nil {
+			return failed
+		}
+		fmt.Printf("%-14s %s\n", bm.name, res)
+	}
+	return nil
+}
+
+// seedBenchUsers inserts n users, every user getting one or two of five
+// roles. Rows are written directly, hashing passwords would take hours.
+func seedBenchUsers(database *sql.DB, n int) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	roles := make([]string, 5)
+	for i := range roles {
+		roles[i] = core.NewID()
+		_, err := tx.Exec(
+			"INSERT INTO roles (id, name, description, permissions, created_at, updated_at) VALUES (?, ?, '', '[]', ?, ?)",
+			roles[i], fmt.Sprintf("role-%d", i), now, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	insertUser, err := tx.Prepare(`INSERT INTO users (
+		id, email, email_normalized, name, password, status, created_at, updated_at
+	) VALUES (?, ?, ?, ?, '', ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertUser.Close()
+	insertRole, err := tx.Prepare("INSERT INTO user_roles (user_id, role_id) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insertRole.Close()
+
+	for i := 0; i < n; i++ {
+		id := core.NewID()
+		email := fmt.Sprintf("user%d@example.com", i)
+		created := now.Add(time.Duration(i-n) * time.Second)
+		if _, err := insertUser.Exec(id, email, email, fmt.Sprintf("User %d", i), user.UserStatusActive, created, created); err != nil {
+			return err
+		}
+		if _, err := insertRole.Exec(id, roles[i%len(roles)]); err != nil {
+			return err
+		}
+		if i%2 == 0 {
+			if _, err := insertRole.Exec(id, roles[(i+1)%len(roles)]); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,152 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"app/internal/audit"
+	"app/internal/core"
+	"app/internal/db"
+	"app/internal/user"
+	"app/pkg/session"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const authBenchUsers = 10_000
+
+// authBenchPools are the ways of opening the database the benchmarks
+// compare: the single pool the app used to have and the writer and reader
+// pools of db.NewSqlite.
+var authBenchPools = []struct {
+	name string
+	open func(path string) (*db.DB, error)
+}{
+	{"single pool", func(path string) (*db.DB, error) {
+		conn, err := db.NewSqliteConnection(path)
+		if err != nil {
+			return nil, err
+		}
+		return &db.DB{Driver: db.DriverSqlite, Writer: conn, Reader: conn}, nil
+	}},
+	{"split pools", db.NewSqlite},
+}
+
+// authBench is a migrated database of authBenchUsers users, on which logins
+// and signups run.
+type authBench struct {
+	repos   repositories
+	users   *user.UserService
+	signups atomic.Int64
+}
+
+func newAuthBench(b *testing.B, open func(string) (*db.DB, error)) *authBench {
+	b.Helper()
+	database, err := open(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { database.Close() })
+	migrator, err := newMigrator(database)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	if err := seedBenchUsers(database.Writer, authBenchUsers); err != nil {
+		b.Fatal(err)
+	}
+	// Passwords are hashed at the lowest cost and logins do not compare
+	// them, so that the database makes the difference.
+	core.DefaultPasswordHasher = &core.PasswordHasher{Algorithm: core.AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}
+	repos := newRepositories(database)
+	return &authBench{
+		repos: repos,
+		users: user.NewUserService(repos.users, &user.Options{
+			Audit: audit.New(repos.audit),
+			Tx:    core.NewTxManager(database.Writer),
+		}),
+	}
+}
+
+// login reads the user and writes a session and an audit event, then reads
+// the session back as the next request would.
+func (a *authBench) login(ctx context.Context) error {
+	u, err := a.repos.users.FindByEmail(ctx, fmt.Sprintf("user%d@example.com", rand.IntN(authBenchUsers)))
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	s := &session.Session{
+		Id:        core.NewID(),
+		UserId:    u.Id,
+		Data:      map[string]any{"user_id": u.Id},
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	if err := a.repos.sessions.Set(ctx, s); err != nil {
+		return err
+	}
+	if err := a.repos.audit.Append(ctx, &audit.Event{Id: core.NewID(), OccurredAt: now, ActorId: u.Id, Action: audit.ActionLoginSucceeded}); err != nil {
+		return err
+	}
+	_, err = a.repos.sessions.Get(ctx, s.Id)
+	return err
+}
+
+// signup is StoreUser, a write transaction.
+func (a *authBench) signup(ctx context.Context) error {
+	n := a.signups.Add(1)
+	_, _, err := a.users.StoreUser(ctx, &user.CreateUserRequest{
+		Name:          fmt.Sprintf("Signup %d", n),
+		Email:         fmt.Sprintf("signup%d@example.com", n),
+		Password:      "Qw7!zNb4vYc1",
+		PasswordCheck: "Qw7!zNb4vYc1",
+	})
+	return err
+}
+
+// runAuthBench runs op from concurrent clients on every kind of pool. Busy
+// databases are reported as a metric rather than failing the benchmark,
+// they are what the pools are compared on.
+func runAuthBench(b *testing.B, op func(*authBench, context.Context) error) {
+	for _, pools := range authBenchPools {
+		b.Run(pools.name, func(b *testing.B) {
+			a := newAuthBench(b, pools.open)
+			ctx := context.Background()
+			var busy atomic.Int64
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					err := op(a, ctx)
+					var sqliteErr sqlite3.Error
+					if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+						busy.Add(1)
+					} else if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+			b.ReportMetric(float64(busy.Load())/float64(b.N), "busy/op")
+		})
+	}
+}
+
+func BenchmarkLogin(b *testing.B) {
+	runAuthBench(b, (*authBench).login)
+}
+
+func BenchmarkSignup(b *testing.B) {
+	runAuthBench(b, (*authBench).signup)
+}
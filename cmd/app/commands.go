@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"app/internal/audit"
+	"app/internal/core"
+	"app/internal/db"
+	"app/internal/seed"
+	"app/internal/user"
+)
+
+func runCommand(name string, args []string) error {
+	switch name {
+	case "repair-emails":
+		return repairEmails(args)
+	case "bench-users":
+		return benchUsers(args)
+	case "migrate":
+		return migrate(args)
+	case "seed":
+		return seedDatabase(args)
+	case "user":
+		return userCommand(args)
+	case "role":
+		return roleCommand(args)
+	case "session":
+		return sessionCommand(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// repairEmails recomputes the normalized email of every user and lists the
+// accounts whose addresses collide, which have to be merged by hand.
+func repairEmails(args []string) error {
+	fs := flag.NewFlagSet("repair-emails", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report without writing")
+	fs.Parse(args)
+
+	database := setup()
+	defer database.Close()
+
+	us := user.NewUserService(newRepositories(database).users, &user.Options{})
+	duplicates, updated, err := us.RepairEmails(context.Background(), *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, d := range duplicates {
+		fmt.Printf("duplicate %s: kept %s (%s), unreachable by email: %s\n",
+			d.Normalized, d.UserIds[0], d.Emails[0], strings.Join(d.UserIds[1:], ", "))
+	}
+	verb := "updated"
+	if *dryRun {
+		verb = "would update"
+	}
+	fmt.Printf("%s %d users, %d duplicate addresses\n", verb, updated, len(duplicates))
+	return nil
+}
+
+// migrate applies the pending migrations, reverts the last one or lists them
+// all. The server applies pending migrations itself when it starts.
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: app migrate up|down|status")
+	}
+	fs.Parse(args)
+
+	database := setup()
+	defer database.Close()
+	migrator, err := newMigrator(database)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch fs.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %05d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migration")
+		}
+		notice, err := checkEmailKeys(database, applied)
+		if err != nil {
+			return err
+		}
+		if notice != "" {
+			fmt.Println(notice)
+		}
+	case "down":
+		m, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %05d_%s\n", m.Version, m.Name)
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Local().Format(time.DateTime)
+			}
+			fmt.Printf("%05d_%-32s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command %q", fs.Arg(0))
+	}
+	return nil
+}
+
+// seedDatabase creates the default roles, the administrator given by flags or
+// SEED_ADMIN_* variables and, with -demo, demo users and sessions. What
+// already exists is left as it is.
+func seedDatabase(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	adminName := fs.String("admin-name", "", "name of the administrator, defaults to SEED_ADMIN_NAME or Administrator")
+	adminEmail := fs.String("admin-email", "", "email of the administrator, defaults to SEED_ADMIN_EMAIL, none is created without one")
+	adminPassword := fs.String("admin-password", "", "password of the administrator, defaults to SEED_ADMIN_PASSWORD")
+	demo := fs.Bool("demo", false, "also create demo users and sessions")
+	demoUsers := fs.Int("demo-users", 50, "number of demo users")
+	demoSessions := fs.Int("demo-sessions", 2, "sessions per new demo user")
+	demoPassword := fs.String("demo-password", "", "password of the demo users, defaults to SEED_DEMO_PASSWORD")
+	fs.Parse(args)
+
+	database := setup()
+	defer database.Close()
+	if err := applyMigrations(database); err != nil {
+		return err
+	}
+	repos := newRepositories(database)
+	us := cliUserService(database, audit.New(repos.audit), nil)
+	ctx := cliContext()
+
+	roles, err := seed.Roles(ctx, us)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d default roles\n", len(roles))
+
+	admin := seed.AdminRequest{
+		Name:     firstNonEmpty(*adminName, os.Getenv("SEED_ADMIN_NAME"), "Administrator"),
+		Email:    firstNonEmpty(*adminEmail, os.Getenv("SEED_ADMIN_EMAIL")),
+		Password: firstNonEmpty(*adminPassword, os.Getenv("SEED_ADMIN_PASSWORD")),
+	}
+	if admin.Email != "" {
+		u, created, err := seed.Admin(ctx, us, admin)
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Printf("created administrator %s\n", u.Email)
+		} else {
+			fmt.Printf("administrator %s already exists\n", u.Email)
+		}
+	}
+
+	if *demo {
+		password := firstNonEmpty(*demoPassword, os.Getenv("SEED_DEMO_PASSWORD"))
+		if password == "" {
+			return fmt.Errorf("demo users need -demo-password or SEED_DEMO_PASSWORD")
+		}
+		created, err := seed.Demo(ctx, us, seed.DemoOptions{
+			Users:           *demoUsers,
+			Password:        password,
+			SessionsPerUser: *demoSessions,
+			Sessions:        repos.sessions,
+		})
+		fmt.Printf("created %d demo users\n", len(created))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cliContext is the context of commands, their audit events are recorded
+// with "cli:<login>" as actor.
+func cliContext() context.Context {
+	actor := os.Getenv("USER")
+	if actor == "" {
+		actor = "unknown"
+	}
+	return audit.NewContext(context.Background(), audit.Request{ActorId: "cli:" + actor, RequestId: core.NewID()})
+}
+
+// cliUserService is the user service of commands, which send no mail and
+// store no avatar. sessions may be nil.
+func cliUserService(database *db.DB, auditService *audit.Service, sessions user.SessionRevoker) *user.UserService {
+	return user.NewUserService(newRepositories(database).users, &user.Options{
+		PasswordPolicy: passwordPolicy(),
+		Audit:          auditService,
+		Tx:             core.NewTxManager(database.Writer),
+		Sessions:       sessions,
+	})
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -1,14 +1,29 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"app/db/migrations"
+	"app/internal/audit"
+	"app/internal/auth/apitoken"
+	"app/internal/auth/lockout"
+	"app/internal/auth/oidc"
+	"app/internal/blob"
+	"app/internal/core"
 	"app/internal/db"
 	"app/internal/handler"
+	"app/internal/mail"
+	"app/internal/privacy"
 	"app/internal/server"
 	"app/internal/user"
 	"app/pkg/session"
@@ -20,24 +35,137 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	go run()
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
 }
 
-func run() {
+// setup loads the environment, applies the global settings and opens the
+// database of DATABASE_DRIVER, the file DATABASE_PATH for SQLite or
+// DATABASE_URL for PostgreSQL.
+func setup() *db.DB {
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
-	database, err := db.NewSqliteConnection(os.Getenv("DATABASE_PATH"))
+	dsn := os.Getenv("DATABASE_PATH")
+	if databaseDriver() == db.DriverPostgres {
+		dsn = os.Getenv("DATABASE_URL")
+	}
+	database, err := db.Open(databaseDriver(), dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
+	user.FoldEmailLocalPart = envString("EMAIL_FOLD_LOCAL_PART", "true") == "true"
+	core.DefaultPasswordHasher = &core.PasswordHasher{
+		Algorithm:  envString("PASSWORD_HASH_ALGORITHM", core.AlgorithmBcrypt),
+		BcryptCost: envInt("BCRYPT_COST", core.DefaultPasswordHasher.BcryptCost),
+		Argon2: core.Argon2Params{
+			Memory:      uint32(envRange("ARGON2_MEMORY", int(core.DefaultArgon2Params.Memory), 1, math.MaxInt32)),
+			Iterations:  uint32(envRange("ARGON2_ITERATIONS", int(core.DefaultArgon2Params.Iterations), 1, math.MaxInt32)),
+			Parallelism: uint8(envRange("ARGON2_PARALLELISM", int(core.DefaultArgon2Params.Parallelism), 1, math.MaxUint8)),
+			SaltLength:  core.DefaultArgon2Params.SaltLength,
+			KeyLength:   core.DefaultArgon2Params.KeyLength,
+		},
+	}
+	if err := core.DefaultPasswordHasher.Validate(); err != nil {
+		log.Fatalf("PASSWORD_HASH_ALGORITHM: %v", err)
+	}
+	return database
+}
+
+// databaseDriver is the value of DATABASE_DRIVER, sqlite3 by default.
+func databaseDriver() string {
+	return envString("DATABASE_DRIVER", db.DriverSqlite)
+}
+
+// newMigrator returns the migrator of the driver of database.
+func newMigrator(database *db.DB) (*db.Migrator, error) {
+	fsys, err := migrations.For(database.Driver)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewMigrator(database.Writer, database.Driver, fsys)
+}
+
+// applyMigrations applies the pending migrations, logging each of them.
+func applyMigrations(database *db.DB) error {
+	migrator, err := newMigrator(database)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(context.Background())
+	for _, m := range applied {
+		log.Printf("applied migration %05d_%s", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	notice, err := checkEmailKeys(database, applied)
+	if notice != "" {
+		log.Print(notice)
+	}
+	return err
+}
+
+// emailKeysMigration adds the normalized email column. Its SQL backfill only
+// folds ASCII and always folds the local part.
+const emailKeysMigration = 9
+
+// checkEmailKeys returns a notice when the migration adding the email lookup
+// keys was just applied and "app repair-emails" would change some of them.
+// Nothing is written: colliding addresses lose their key, which an operator
+// reviews with -dry-run first.
+func checkEmailKeys(database *db.DB, applied []db.Migration) (string, error) {
+	if !slices.ContainsFunc(applied, func(m db.Migration) bool { return m.Version == emailKeysMigration }) {
+		return "", nil
+	}
+	us := user.NewUserService(newRepositories(database).users, &user.Options{})
+	duplicates, updated, err := us.RepairEmails(context.Background(), true)
+	if err != nil || updated == 0 {
+		return "", err
+	}
+	return fmt.Sprintf(`"app repair-emails" would change %d email lookup keys, %d addresses collide: review them with "app repair-emails -dry-run"`, updated, len(duplicates)), nil
+}
+
+// passwordPolicy reads the password policy from the environment.
+func passwordPolicy() *user.PasswordPolicy {
+	policy := user.DefaultPasswordPolicy
+	policy.MinLength = envInt("PASSWORD_MIN_LENGTH", policy.MinLength)
+	for _, class := range strings.Split(os.Getenv("PASSWORD_REQUIRE_CLASSES"), ",") {
+		switch strings.TrimSpace(class) {
+		case "upper":
+			policy.RequireUpper = true
+		case "lower":
+			policy.RequireLower = true
+		case "digit":
+			policy.RequireDigit = true
+		case "symbol":
+			policy.RequireSymbol = true
+		}
+	}
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		policy.Breached = user.NewBreachedPasswordDir(dir)
+	}
+	return &policy
+}
+
+func run() {
+	database := setup()
 	defer database.Close()
+	if err := applyMigrations(database); err != nil {
+		log.Fatal(err)
+	}
 
-	sessionRepository := session.NewSqliteRepository(database)
+	repos := newRepositories(database)
+	auditService := audit.New(repos.audit)
 	sm := session.New(&session.Options{
 		Lifetime:   24 * time.Hour,
 		Cookie:     &session.CookieConfig{
@@ -48,18 +176,170 @@ func run() {
 			HttpOnly: true,
 			SameSite: http.SameSiteLaxMode,
 		},
-		Repository: sessionRepository,
+		Repository: repos.sessions,
 		GCInterval: 1 * time.Hour,
 		SecretKey: []byte(os.Getenv("SESSION_SECRET")),
+		Remember: &session.RememberOptions{
+			Lifetime:   30 * 24 * time.Hour,
+			Repository: repos.remember,
+			Allow: func(ctx context.Context, userId string) bool {
+				u, err := repos.users.Find(ctx, userId)
+				return err == nil && u.Status == user.UserStatusActive
+			},
+		},
+		Hooks: sessionAuditHooks(auditService),
+	})
+
+	var mailer mail.Mailer = mail.NewLogMailer()
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		mailer = mail.NewSMTPMailer(
+			addr,
+			os.Getenv("SMTP_FROM"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+		)
+	}
+	blobs := blob.NewLocalStore(envString("UPLOADS_DIR", "uploads"))
+	txManager := core.NewTxManager(database.Writer)
+	us := user.NewUserService(repos.users, &user.Options{
+		Mailer:         mailer,
+		BaseURL:        os.Getenv("APP_URL"),
+		PasswordPolicy: passwordPolicy(),
+		Avatars:        blobs,
+		Audit:          auditService,
+		Tx:             txManager,
+		Sessions:       sm,
 	})
+	retention := time.Duration(envInt("DELETED_USER_RETENTION_DAYS", int(user.DefaultRetention/(24*time.Hour)))) * 24 * time.Hour
+	us.RunPurge(retention, 1*time.Hour)
+
+	var oidcService *oidc.Service
+	if providers := loadOIDCProviders(); len(providers) > 0 {
+		oidcService = oidc.New(&oidc.Options{
+			Providers:  providers,
+			Repository: repos.identities,
+			Users:      us,
+			Session:    sm,
+		})
+	}
 
-	UserRepository := user.NewUserRepositorySqlite(database)
-	us := user.NewUserService(UserRepository)
+	lockoutPolicy := lockout.DefaultPolicy
+	lockoutPolicy.Threshold = envInt("LOGIN_LOCKOUT_THRESHOLD", lockoutPolicy.Threshold)
+	lockoutPolicy.IPThreshold = envInt("LOGIN_LOCKOUT_IP_THRESHOLD", lockoutPolicy.IPThreshold)
+	lockoutService := lockout.New(repos.lockout, lockoutPolicy, 1*time.Hour)
+
+	apiTokens := apitoken.NewService(repos.apiTokens, us)
+	privacyService := privacy.New(&privacy.Options{
+		Users:      us,
+		Sessions:   sm,
+		APITokens:  apiTokens,
+		Identities: repos.identities,
+		Audit:      auditService,
+		Tx:         txManager,
+	})
 
 	app := chi.NewRouter()
 	httpHandler := handler.NewHttpHandler(app, us, sm, handler.Options{
 		AllowedOrigins: []string{"*"},
+		OIDC:           oidcService,
+		Lockout:        lockoutService,
+		APITokens:      apiTokens,
+		Blobs:          blobs,
+		Audit:          auditService,
+		Privacy:        privacyService,
 	})
 	s := server.NewServer(":8080", httpHandler)
 	s.Run()
 }
+
+// sessionAuditHooks records session events in the audit log. Events target
+// the user, session ids are never recorded.
+func sessionAuditHooks(a *audit.Service) session.Hooks {
+	record := func(ctx context.Context, action, userId string) {
+		a.Record(ctx, audit.Entry{
+			Action:     action,
+			ActorId:    userId,
+			TargetType: audit.TargetUser,
+			TargetId:   userId,
+		})
+	}
+	return session.Hooks{
+		Created: func(ctx context.Context, s *session.Session) {
+			record(ctx, audit.ActionSessionCreated, s.UserId)
+		},
+		Destroyed: func(ctx context.Context, s *session.Session) {
+			record(ctx, audit.ActionSessionDestroyed, s.UserId)
+		},
+		// The actor comes from ctx, sessions may be revoked by an operator.
+		RevokedOthers: func(ctx context.Context, userId string) {
+			a.Record(ctx, audit.Entry{
+				Action:     audit.ActionSessionsRevoked,
+				TargetType: audit.TargetUser,
+				TargetId:   userId,
+			})
+		},
+		RememberTheft: func(ctx context.Context, userId string) {
+			a.Record(ctx, audit.Entry{
+				Action:     audit.ActionRememberTheft,
+				TargetType: audit.TargetUser,
+				TargetId:   userId,
+			})
+		},
+	}
+}
+
+func envString(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(name string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// envRange is envInt for a setting that must lie between min and max, the
+// process exits when it does not.
+func envRange(name string, fallback, min, max int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < min || v > max {
+		log.Fatalf("%s must be a number between %d and %d", name, min, max)
+	}
+	return v
+}
+
+// loadOIDCProviders reads the providers listed in OIDC_PROVIDERS, each one
+// configured through OIDC_<NAME>_* variables.
+func loadOIDCProviders() []oidc.Provider {
+	var providers []oidc.Provider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		var scopes []string
+		if s := os.Getenv(prefix + "SCOPES"); s != "" {
+			scopes = strings.Fields(s)
+		}
+		providers = append(providers, oidc.Provider{
+			Name:         name,
+			DisplayName:  os.Getenv(prefix + "DISPLAY_NAME"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       scopes,
+		})
+	}
+	return providers
+}
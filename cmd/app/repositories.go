@@ -0,0 +1,47 @@
+package main
+
+import (
+	"app/internal/audit"
+	"app/internal/auth/apitoken"
+	"app/internal/auth/lockout"
+	"app/internal/auth/oidc"
+	"app/internal/db"
+	"app/internal/user"
+	"app/pkg/session"
+)
+
+// repositories are the implementations of the configured database driver.
+type repositories struct {
+	users      user.UserRepository
+	sessions   session.SessionRepository
+	remember   session.RememberRepository
+	audit      audit.Repository
+	apiTokens  apitoken.Repository
+	lockout    lockout.Repository
+	identities oidc.IdentityRepository
+}
+
+// newRepositories returns the repositories of the driver of database. Those
+// of SQLite read from the reader pool.
+func newRepositories(database *db.DB) repositories {
+	if database.Driver == db.DriverPostgres {
+		return repositories{
+			users:      user.NewUserRepositoryPostgres(database.Writer),
+			sessions:   session.NewPostgresRepository(database.Writer),
+			remember:   session.NewRememberPostgresRepository(database.Writer),
+			audit:      audit.NewRepositoryPostgres(database.Writer),
+			apiTokens:  apitoken.NewRepositoryPostgres(database.Writer),
+			lockout:    lockout.NewRepositoryPostgres(database.Writer),
+			identities: oidc.NewIdentityRepositoryPostgres(database.Writer),
+		}
+	}
+	return repositories{
+		users:      user.NewUserRepositorySqlitePools(database.Writer, database.Reader),
+		sessions:   session.NewSqlitePoolsRepository(database.Writer, database.Reader),
+		remember:   session.NewRememberSqliteRepository(database.Writer),
+		audit:      audit.NewRepositorySqlite(database.Writer),
+		apiTokens:  apitoken.NewRepositorySqlite(database.Writer),
+		lockout:    lockout.NewRepositorySqlite(database.Writer),
+		identities: oidc.NewIdentityRepositorySqlite(database.Writer),
+	}
+}
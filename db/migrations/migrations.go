@@ -0,0 +1,25 @@
+// Package migrations embeds the SQL migrations of the database, one
+// directory per dialect. They are written in the goose format so
+// "make migration/create" keeps working, and a migration added to one
+// dialect must be added to the other with the same version.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var files embed.FS
+
+// For returns the migrations of the database driver, sqlite3 or postgres.
+func For(driver string) (fs.FS, error) {
+	switch driver {
+	case "sqlite3":
+		return fs.Sub(files, "sqlite")
+	case "postgres":
+		return fs.Sub(files, "postgres")
+	}
+	return nil, fmt.Errorf("no migrations for database driver %q", driver)
+}
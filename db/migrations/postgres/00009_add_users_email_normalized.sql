@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_normalized VARCHAR(255);
+
+-- Only the oldest account of addresses differing by case gets the key, the
+-- others are left for "app repair-emails" to report. LOWER only folds ASCII
+-- and always folds the local part: this backfill only lets the unique index
+-- build, "app repair-emails" recomputes the keys in Go once reviewed.
+UPDATE users SET email_normalized = LOWER(TRIM(email))
+WHERE id = (
+    SELECT MIN(u.id) FROM users u
+    WHERE LOWER(TRIM(u.email)) = LOWER(TRIM(users.email))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_normalized ON users(email_normalized)
+WHERE email_normalized IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_email_normalized;
+ALTER TABLE users DROP COLUMN IF EXISTS email_normalized;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Separators of emails are replaced by spaces, so that every part of the
+-- address is a word like with the SQLite unicode61 tokenizer. Diacritics are
+-- kept, unaccent cannot be used in a generated column.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    to_tsvector('simple', name || ' ' || translate(email, '@.+_-', '     '))
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_users_search ON users USING GIN (search);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_search;
+ALTER TABLE users DROP COLUMN IF EXISTS search;
+-- +goose StatementEnd
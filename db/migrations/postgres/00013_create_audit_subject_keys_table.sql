@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Personal data in audit diffs is sealed with a key of the user it is about,
+-- erasing the user deletes the key and the sealed diffs become unreadable.
+CREATE TABLE IF NOT EXISTS audit_subject_keys (
+    subject_id VARCHAR(255) NOT NULL PRIMARY KEY,
+    key BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+ALTER TABLE audit_events ADD COLUMN sealed_diff BYTEA;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE audit_events DROP COLUMN sealed_diff;
+DROP TABLE IF EXISTS audit_subject_keys;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Nothing to do, the search column of users lives in the row itself. The
+-- migration keeps the versions of both dialects aligned.
+SELECT 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 1;
+-- +goose StatementEnd
@@ -3,3 +3,5 @@
 PRAGMA auto_vacuum = incremental;
 PRAGMA journal_mode = WAL;
 PRAGMA page_size = 32768;
+
+-- +goose down
@@ -12,3 +12,7 @@ CREATE INDEX IF NOT EXISTS idx_expires_at ON sessions(expires_at);
 CREATE INDEX IF NOT EXISTS idx_user_id ON sessions(user_id);
 -- +goose StatementEnd
 
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS sessions;
+-- +goose StatementEnd
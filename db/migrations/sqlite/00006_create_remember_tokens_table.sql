@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS remember_tokens (
+    selector VARCHAR(255) NOT NULL PRIMARY KEY,
+    series VARCHAR(255) NOT NULL,
+    user_id CHAR(26) NOT NULL,
+    validator_hash VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_remember_tokens_series ON remember_tokens(series);
+CREATE INDEX IF NOT EXISTS idx_remember_tokens_user_id ON remember_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_remember_tokens_expires_at ON remember_tokens(expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS remember_tokens;
+-- +goose StatementEnd
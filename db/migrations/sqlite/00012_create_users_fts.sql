@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Requires SQLite built with FTS5, the sqlite_fts5 build tag of go-sqlite3.
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    name,
+    email,
+    content = 'users',
+    content_rowid = 'rowid',
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users
+BEGIN
+    INSERT INTO users_fts (rowid, name, email) VALUES (new.rowid, new.name, new.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users
+BEGIN
+    INSERT INTO users_fts (users_fts, rowid, name, email) VALUES ('delete', old.rowid, old.name, old.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF name, email ON users
+BEGIN
+    INSERT INTO users_fts (users_fts, rowid, name, email) VALUES ('delete', old.rowid, old.name, old.email);
+    INSERT INTO users_fts (rowid, name, email) VALUES (new.rowid, new.name, new.email);
+END;
+
+INSERT INTO users_fts (users_fts) VALUES ('rebuild');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS users_fts_update;
+DROP TRIGGER IF EXISTS users_fts_delete;
+DROP TRIGGER IF EXISTS users_fts_insert;
+DROP TABLE IF EXISTS users_fts;
+-- +goose StatementEnd
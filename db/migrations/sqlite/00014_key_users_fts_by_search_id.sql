@@ -0,0 +1,77 @@
+-- +goose Up
+-- +goose StatementBegin
+-- users_fts was keyed by the implicit rowid of users, which VACUUM may
+-- renumber on a table without an INTEGER PRIMARY KEY. It is now keyed by
+-- search_id, a column of our own set when a user is inserted.
+DROP TRIGGER IF EXISTS users_fts_update;
+DROP TRIGGER IF EXISTS users_fts_delete;
+DROP TRIGGER IF EXISTS users_fts_insert;
+DROP TABLE IF EXISTS users_fts;
+
+ALTER TABLE users ADD COLUMN search_id INTEGER;
+UPDATE users SET search_id = rowid;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_search_id ON users (search_id);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    name,
+    email,
+    content = 'users',
+    content_rowid = 'search_id',
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users
+BEGIN
+    UPDATE users SET search_id = (SELECT IFNULL(MAX(search_id), 0) + 1 FROM users) WHERE id = new.id;
+    INSERT INTO users_fts (rowid, name, email) SELECT search_id, name, email FROM users WHERE id = new.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users
+BEGIN
+    INSERT INTO users_fts (users_fts, rowid, name, email) VALUES ('delete', old.search_id, old.name, old.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF name, email ON users
+BEGIN
+    INSERT INTO users_fts (users_fts, rowid, name, email) VALUES ('delete', old.search_id, old.name, old.email);
+    INSERT INTO users_fts (rowid, name, email) VALUES (new.search_id, new.name, new.email);
+END;
+
+INSERT INTO users_fts (users_fts) VALUES ('rebuild');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS users_fts_update;
+DROP TRIGGER IF EXISTS users_fts_delete;
+DROP TRIGGER IF EXISTS users_fts_insert;
+DROP TABLE IF EXISTS users_fts;
+DROP INDEX IF EXISTS idx_users_search_id;
+ALTER TABLE users DROP COLUMN search_id;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    name,
+    email,
+    content = 'users',
+    content_rowid = 'rowid',
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users
+BEGIN
+    INSERT INTO users_fts (rowid, name, email) VALUES (new.rowid, new.name, new.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users
+BEGIN
+    INSERT INTO users_fts (users_fts, rowid, name, email) VALUES ('delete', old.rowid, old.name, old.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF name, email ON users
+BEGIN
+    INSERT INTO users_fts (users_fts, rowid, name, email) VALUES ('delete', old.rowid, old.name, old.email);
+    INSERT INTO users_fts (rowid, name, email) VALUES (new.rowid, new.name, new.email);
+END;
+
+INSERT INTO users_fts (users_fts) VALUES ('rebuild');
+-- +goose StatementEnd
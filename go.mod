@@ -7,11 +7,11 @@ require (
 	github.com/go-chi/chi v1.5.5
 	github.com/go-chi/chi/v5 v5.2.0
 	github.com/go-chi/cors v1.2.1
+	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/oklog/ulid/v2 v2.1.0
+	golang.org/x/crypto v0.32.0
 )
 
-require (
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/mattn/go-sqlite3 v1.14.24 // indirect
-	github.com/oklog/ulid/v2 v2.1.0 // indirect
-	golang.org/x/crypto v0.32.0 // indirect
-)
+require golang.org/x/sys v0.29.0 // indirect
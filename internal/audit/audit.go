@@ -0,0 +1,207 @@
+package audit
+
+import (
+	"app/internal/core"
+	"context"
+	"log"
+	"reflect"
+	"time"
+)
+
+// Actions recorded by the application.
+const (
+	ActionLoginSucceeded     = "auth.login_succeeded"
+	ActionLoginFailed        = "auth.login_failed"
+	ActionAccountUnlocked    = "auth.account_unlocked"
+	ActionSessionCreated     = "session.created"
+	ActionSessionDestroyed   = "session.destroyed"
+	ActionSessionsRevoked    = "session.revoked_others"
+	ActionRememberTheft      = "session.remember_token_theft"
+	ActionUserCreated        = "user.created"
+	ActionUserUpdated        = "user.updated"
+	ActionUserDeleted        = "user.deleted"
+	ActionUserErased         = "user.erased"
+	ActionUserRestored       = "user.restored"
+	ActionDataExported       = "user.data_exported"
+	ActionPasswordChanged    = "user.password_changed"
+	ActionEmailChangeRequest = "user.email_change_requested"
+	ActionEmailChanged       = "user.email_changed"
+	ActionRoleCreated        = "role.created"
+	ActionRoleUpdated        = "role.updated"
+	ActionRoleDeleted        = "role.deleted"
+	ActionTokenCreated       = "api_token.created"
+	ActionTokenRevoked       = "api_token.revoked"
+)
+
+const (
+	TargetUser     = "user"
+	TargetRole     = "role"
+	TargetSession  = "session"
+	TargetAPIToken = "api_token"
+)
+
+// Change is the value of a field before and after an action.
+type Change struct {
+	From any `json:"from,omitempty"`
+	To   any `json:"to,omitempty"`
+}
+
+// Event is an entry of the audit log. Events are never updated or deleted.
+type Event struct {
+	Id         string            `json:"id"`
+	OccurredAt time.Time         `json:"occurred_at"`
+	ActorId    string            `json:"actor_id"`
+	Action     string            `json:"action"`
+	TargetType string            `json:"target_type"`
+	TargetId   string            `json:"target_id"`
+	IP         string            `json:"ip"`
+	RequestId  string            `json:"request_id"`
+	Diff       map[string]Change `json:"diff"`
+	// SealedDiff is the diff of a Personal entry as stored, encrypted with
+	// the key of the target.
+	SealedDiff []byte `json:"-"`
+	// Redacted marks events whose diff was sealed with the key of an erased
+	// user and can no longer be read.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// Entry describes an action to record. The actor defaults to the user making
+// the request.
+type Entry struct {
+	Action     string
+	ActorId    string
+	TargetType string
+	TargetId   string
+	Diff       map[string]Change
+	// Personal marks diffs holding personal data of the target, such as its
+	// name or email. They are sealed with a key of the target that erasing
+	// it deletes, events themselves being append-only.
+	Personal bool
+}
+
+type Filter struct {
+	ActorId    string
+	Action     string
+	TargetType string
+	TargetId   string
+	From       time.Time
+	To         time.Time
+	// Limit caps the number of events, newest first. Zero means no limit.
+	Limit int
+}
+
+type Repository interface {
+	// Append takes part in the transaction of ctx, if any, so that events
+	// are only kept when the change they describe is.
+	Append(ctx context.Context, event *Event) error
+	List(ctx context.Context, filter Filter) ([]Event, error)
+	// SubjectKey returns the key sealing the personal data of subject,
+	// creating it when create is set. It returns nil when there is none.
+	SubjectKey(ctx context.Context, subject string, create bool) ([]byte, error)
+	// DeleteSubjectKey deletes the key of subject, the diffs it sealed can
+	// no longer be read.
+	DeleteSubjectKey(ctx context.Context, subject string) error
+}
+
+type Service struct {
+	repo Repository
+}
+
+func New(repo Repository) *Service {
+	return &Service{repo}
+}
+
+// Record appends an event, filling in the request details from ctx. Outside
+// a transaction failures are logged rather than returned so that auditing
+// never blocks the action itself. In the transaction of ctx they are
+// returned: the change must not be kept without its event, and a failed
+// statement aborts a PostgreSQL transaction anyway. A nil service records
+// nothing.
+func (s *Service) Record(ctx context.Context, entry Entry) error {
+	if s == nil {
+		return nil
+	}
+	req, _ := FromContext(ctx)
+	event := &Event{
+		Id:         core.NewID(),
+		OccurredAt: time.Now().UTC(),
+		ActorId:    entry.ActorId,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetId:   entry.TargetId,
+		IP:         req.IP,
+		RequestId:  req.RequestId,
+		Diff:       entry.Diff,
+	}
+	if event.ActorId == "" {
+		event.ActorId = req.ActorId
+	}
+	err := s.append(ctx, entry, event)
+	if err != nil && !core.InTransaction(ctx) {
+		log.Println(err)
+		return nil
+	}
+	return err
+}
+
+func (s *Service) append(ctx context.Context, entry Entry, event *Event) error {
+	if entry.Personal && len(event.Diff) > 0 && event.TargetId != "" {
+		if err := s.sealDiff(ctx, event); err != nil {
+			return err
+		}
+	}
+	return s.repo.Append(ctx, event)
+}
+
+func (s *Service) List(ctx context.Context, filter Filter) ([]Event, error) {
+	events, err := s.repo.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return events, s.openDiffs(ctx, events)
+}
+
+// Forget deletes the key sealing the personal data recorded about subject,
+// in the transaction of ctx if any. Events recorded before keys existed are
+// not sealed and stay readable.
+func (s *Service) Forget(ctx context.Context, subject string) error {
+	if s == nil {
+		return nil
+	}
+	return s.repo.DeleteSubjectKey(ctx, subject)
+}
+
+// Diff returns the fields whose values differ, keyed by name. Fields present
+// in only one of the maps are reported too.
+func Diff(before, after map[string]any) map[string]Change {
+	changes := make(map[string]Change)
+	for k, from := range before {
+		if to, ok := after[k]; !ok || !reflect.DeepEqual(from, to) {
+			changes[k] = Change{From: from, To: after[k]}
+		}
+	}
+	for k, to := range after {
+		if _, ok := before[k]; !ok {
+			changes[k] = Change{To: to}
+		}
+	}
+	return changes
+}
+
+// Request holds the details of the request an action is made in.
+type Request struct {
+	ActorId   string
+	IP        string
+	RequestId string
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, req Request) context.Context {
+	return context.WithValue(ctx, contextKey{}, req)
+}
+
+func FromContext(ctx context.Context) (Request, bool) {
+	req, ok := ctx.Value(contextKey{}).(Request)
+	return req, ok
+}
@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"app/internal/core"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+type RepositorySqlite struct {
+	db *sql.DB
+}
+
+func NewRepositorySqlite(db *sql.DB) *RepositorySqlite {
+	return &RepositorySqlite{db}
+}
+
+func (r *RepositorySqlite) Append(ctx context.Context, event *Event) error {
+	query := `INSERT INTO audit_events (
+		id, occurred_at, actor_id, action, target_type, target_id, ip, request_id, diff, sealed_diff
+	) VALUES (
+		?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+	)`
+	var diff []byte
+	if len(event.Diff) > 0 {
+		var err error
+		if diff, err = json.Marshal(event.Diff); err != nil {
+			return err
+		}
+	}
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx,
+		query,
+		event.Id,
+		event.OccurredAt,
+		event.ActorId,
+		event.Action,
+		event.TargetType,
+		event.TargetId,
+		event.IP,
+		event.RequestId,
+		diff,
+		event.SealedDiff,
+	)
+	return err
+}
+
+func (r *RepositorySqlite) List(ctx context.Context, filter Filter) ([]Event, error) {
+	var where []string
+	var args []any
+	for column, value := range map[string]string{
+		"actor_id":    filter.ActorId,
+		"action":      filter.Action,
+		"target_type": filter.TargetType,
+		"target_id":   filter.TargetId,
+	} {
+		if value != "" {
+			where = append(where, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "occurred_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "occurred_at < ?")
+		args = append(args, filter.To.UTC())
+	}
+
+	query := "SELECT id, occurred_at, actor_id, action, target_type, target_id, ip, request_id, diff, sealed_diff FROM audit_events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY occurred_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := core.Conn(ctx, r.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var diff []byte
+		err := rows.Scan(
+			&e.Id,
+			&e.OccurredAt,
+			&e.ActorId,
+			&e.Action,
+			&e.TargetType,
+			&e.TargetId,
+			&e.IP,
+			&e.RequestId,
+			&diff,
+			&e.SealedDiff,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(diff) > 0 {
+			if err := json.Unmarshal(diff, &e.Diff); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (r *RepositorySqlite) SubjectKey(ctx context.Context, subject string, create bool) ([]byte, error) {
+	conn := core.Conn(ctx, r.db)
+	if create {
+		key, err := newSubjectKey()
+		if err != nil {
+			return nil, err
+		}
+		_, err = conn.ExecContext(ctx,
+			"INSERT INTO audit_subject_keys (subject_id, key, created_at) VALUES (?, ?, ?) ON CONFLICT (subject_id) DO NOTHING",
+			subject, key, time.Now().UTC(),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var key []byte
+	err := conn.QueryRowContext(ctx, "SELECT key FROM audit_subject_keys WHERE subject_id = ?", subject).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return key, err
+}
+
+func (r *RepositorySqlite) DeleteSubjectKey(ctx context.Context, subject string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "DELETE FROM audit_subject_keys WHERE subject_id = ?", subject)
+	return err
+}
@@ -0,0 +1,44 @@
+//go:build sqlite_fts5
+
+package audit_test
+
+import (
+	"context"
+	"testing"
+
+	"app/internal/audit"
+	"app/internal/db/dbtest"
+)
+
+func TestForgetRedactsPersonalDiffs(t *testing.T) {
+	ctx := context.Background()
+	s := audit.New(audit.NewRepositorySqlite(dbtest.Sqlite(t)))
+	diff := map[string]audit.Change{"email": {From: "old@example.com", To: "new@example.com"}}
+	s.Record(ctx, audit.Entry{Action: audit.ActionEmailChanged, TargetType: audit.TargetUser, TargetId: "erased", Diff: diff, Personal: true})
+	s.Record(ctx, audit.Entry{Action: audit.ActionEmailChanged, TargetType: audit.TargetUser, TargetId: "kept", Diff: diff, Personal: true})
+	s.Record(ctx, audit.Entry{Action: audit.ActionRoleUpdated, TargetType: audit.TargetRole, TargetId: "role", Diff: diff})
+
+	events, err := s.List(ctx, audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if e.Redacted || e.Diff["email"].To != "new@example.com" {
+			t.Errorf("event of %s before Forget: %+v", e.TargetId, e)
+		}
+	}
+
+	if err := s.Forget(ctx, "erased"); err != nil {
+		t.Fatal(err)
+	}
+	events, err = s.List(ctx, audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		erased := e.TargetId == "erased"
+		if e.Redacted != erased || (e.Diff == nil) != erased {
+			t.Errorf("event of %s after Forget: redacted %v, diff %v", e.TargetId, e.Redacted, e.Diff)
+		}
+	}
+}
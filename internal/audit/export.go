@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+func WriteCSV(w io.Writer, events []Event) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"id", "occurred_at", "actor_id", "action", "target_type", "target_id", "ip", "request_id", "diff"})
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		diff := ""
+		if e.Redacted {
+			diff = "redacted"
+		} else if len(e.Diff) > 0 {
+			b, err := json.Marshal(e.Diff)
+			if err != nil {
+				return err
+			}
+			diff = string(b)
+		}
+		err := cw.Write([]string{
+			e.Id,
+			e.OccurredAt.Format(time.RFC3339),
+			e.ActorId,
+			e.Action,
+			e.TargetType,
+			e.TargetId,
+			e.IP,
+			e.RequestId,
+			diff,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func WriteJSON(w io.Writer, events []Event) error {
+	if events == nil {
+		events = []Event{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(events)
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+)
+
+const subjectKeySize = 32
+
+func newSubjectKey() ([]byte, error) {
+	key := make([]byte, subjectKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealDiff encrypts the diff of event with the key of its target, which is
+// created on first use, and moves it to SealedDiff.
+func (s *Service) sealDiff(ctx context.Context, event *Event) error {
+	key, err := s.repo.SubjectKey(ctx, event.TargetId, true)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(event.Diff)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// The event id is authenticated, a sealed diff cannot be moved to
+	// another event.
+	event.SealedDiff = gcm.Seal(nonce, nonce, plain, []byte(event.Id))
+	event.Diff = nil
+	return nil
+}
+
+// openDiffs decrypts the sealed diffs of events. Those whose key was deleted
+// are marked Redacted.
+func (s *Service) openDiffs(ctx context.Context, events []Event) error {
+	keys := make(map[string][]byte)
+	for i := range events {
+		e := &events[i]
+		if len(e.SealedDiff) == 0 {
+			continue
+		}
+		key, ok := keys[e.TargetId]
+		if !ok {
+			var err error
+			if key, err = s.repo.SubjectKey(ctx, e.TargetId, false); err != nil {
+				return err
+			}
+			keys[e.TargetId] = key
+		}
+		if key == nil {
+			e.Redacted = true
+			continue
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return err
+		}
+		if len(e.SealedDiff) < gcm.NonceSize() {
+			return errors.New("audit: sealed diff too short")
+		}
+		nonce, sealed := e.SealedDiff[:gcm.NonceSize()], e.SealedDiff[gcm.NonceSize():]
+		plain, err := gcm.Open(nil, nonce, sealed, []byte(e.Id))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(plain, &e.Diff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package apitoken
+
+import (
+	"app/internal/user"
+	"context"
+	"slices"
+	"time"
+)
+
+// tokenPrefix marks personal API tokens so they are easy to recognise, for
+// example by secret scanners.
+const tokenPrefix = "pat_"
+
+// Token is a personal API token. The secret part is only shown once at
+// creation, only its hash is stored.
+type Token struct {
+	Id         string    `json:"id"`
+	UserId     string    `json:"user_id"`
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes"`
+	Hash       string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+// Repository stores tokens, in the transaction of ctx if any.
+type Repository interface {
+	Find(ctx context.Context, id string) (*Token, error)
+	ListByUser(ctx context.Context, userId string) ([]Token, error)
+	Store(ctx context.Context, token *Token) error
+	Touch(ctx context.Context, id string, at time.Time) error
+	Revoke(ctx context.Context, id string, at time.Time) error
+	// RevokeByUser revokes every token of the user.
+	RevokeByUser(ctx context.Context, userId string, at time.Time) error
+}
+
+type CreateTokenRequest struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (r *CreateTokenRequest) Validate() map[string]string {
+	errs := make(map[string]string)
+	if r.Name == "" {
+		errs["name"] = "Name is required"
+	}
+	if !r.ExpiresAt.IsZero() && r.ExpiresAt.Before(time.Now()) {
+		errs["expires_at"] = "Expiration must be in the future"
+	}
+	return errs
+}
+
+func (t *Token) Expired() bool {
+	return !t.ExpiresAt.IsZero() && time.Now().UTC().After(t.ExpiresAt)
+}
+
+func (t *Token) Revoked() bool {
+	return !t.RevokedAt.IsZero()
+}
+
+func (t *Token) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, user.PermissionAll)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the token the request was
+// authenticated with.
+func NewContext(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the token the request was authenticated with, if any.
+func FromContext(ctx context.Context) (*Token, bool) {
+	t, ok := ctx.Value(contextKey{}).(*Token)
+	return t, ok && t != nil
+}
@@ -0,0 +1,83 @@
+package apitoken
+
+import (
+	"app/internal/core"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type RepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewRepositoryPostgres(db *sql.DB) *RepositoryPostgres {
+	return &RepositoryPostgres{db}
+}
+
+func (r *RepositoryPostgres) Find(ctx context.Context, id string) (*Token, error) {
+	query := `SELECT id, user_id, name, scopes, hash, created_at, expires_at, last_used_at, revoked_at
+		FROM api_tokens WHERE id = $1`
+	return scanTokenRow(core.Conn(ctx, r.db).QueryRowContext(ctx, query, id))
+}
+
+func (r *RepositoryPostgres) ListByUser(ctx context.Context, userId string) ([]Token, error) {
+	query := `SELECT id, user_id, name, scopes, hash, created_at, expires_at, last_used_at, revoked_at
+		FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := core.Conn(ctx, r.db).QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []Token
+	for rows.Next() {
+		t, err := scanTokenRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r *RepositoryPostgres) Store(ctx context.Context, token *Token) error {
+	query := `INSERT INTO api_tokens (
+		id, user_id, name, scopes, hash, created_at, expires_at
+	) VALUES (
+		$1, $2, $3, $4, $5, $6, $7
+	)`
+	scopes, err := json.Marshal(token.Scopes)
+	if err != nil {
+		return err
+	}
+	_, err = core.Conn(ctx, r.db).ExecContext(ctx,
+		query,
+		token.Id,
+		token.UserId,
+		token.Name,
+		string(scopes),
+		token.Hash,
+		token.CreatedAt,
+		nullTime(token.ExpiresAt),
+	)
+	return err
+}
+
+func (r *RepositoryPostgres) Touch(ctx context.Context, id string, at time.Time) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "UPDATE api_tokens SET last_used_at = $1 WHERE id = $2", at, id)
+	return err
+}
+
+func (r *RepositoryPostgres) Revoke(ctx context.Context, id string, at time.Time) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "UPDATE api_tokens SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", at, id)
+	return err
+}
+
+func (r *RepositoryPostgres) RevokeByUser(ctx context.Context, userId string, at time.Time) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "UPDATE api_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", at, userId)
+	return err
+}
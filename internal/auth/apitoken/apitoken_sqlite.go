@@ -0,0 +1,120 @@
+package apitoken
+
+import (
+	"app/internal/core"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type RepositorySqlite struct {
+	db *sql.DB
+}
+
+func NewRepositorySqlite(db *sql.DB) *RepositorySqlite {
+	return &RepositorySqlite{db}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func scanTokenRow(row core.Rowscan) (*Token, error) {
+	var t Token
+	var scopes sql.NullString
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&t.Id,
+		&t.UserId,
+		&t.Name,
+		&scopes,
+		&t.Hash,
+		&t.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	t.Scopes = []string{}
+	if scopes.Valid {
+		if err := json.Unmarshal([]byte(scopes.String), &t.Scopes); err != nil {
+			return nil, err
+		}
+	}
+	t.ExpiresAt = expiresAt.Time
+	t.LastUsedAt = lastUsedAt.Time
+	t.RevokedAt = revokedAt.Time
+	return &t, nil
+}
+
+func (r *RepositorySqlite) Find(ctx context.Context, id string) (*Token, error) {
+	query := `SELECT id, user_id, name, scopes, hash, created_at, expires_at, last_used_at, revoked_at
+		FROM api_tokens WHERE id = ?`
+	return scanTokenRow(core.Conn(ctx, r.db).QueryRowContext(ctx, query, id))
+}
+
+func (r *RepositorySqlite) ListByUser(ctx context.Context, userId string) ([]Token, error) {
+	query := `SELECT id, user_id, name, scopes, hash, created_at, expires_at, last_used_at, revoked_at
+		FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC`
+	rows, err := core.Conn(ctx, r.db).QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []Token
+	for rows.Next() {
+		t, err := scanTokenRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r *RepositorySqlite) Store(ctx context.Context, token *Token) error {
+	query := `INSERT INTO api_tokens (
+		id, user_id, name, scopes, hash, created_at, expires_at
+	) VALUES (
+		?, ?, ?, ?, ?, ?, ?
+	)`
+	scopes, err := json.Marshal(token.Scopes)
+	if err != nil {
+		return err
+	}
+	_, err = core.Conn(ctx, r.db).ExecContext(ctx,
+		query,
+		token.Id,
+		token.UserId,
+		token.Name,
+		scopes,
+		token.Hash,
+		token.CreatedAt,
+		nullTime(token.ExpiresAt),
+	)
+	return err
+}
+
+func (r *RepositorySqlite) Touch(ctx context.Context, id string, at time.Time) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", at, id)
+	return err
+}
+
+func (r *RepositorySqlite) Revoke(ctx context.Context, id string, at time.Time) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", at, id)
+	return err
+}
+
+func (r *RepositorySqlite) RevokeByUser(ctx context.Context, userId string, at time.Time) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "UPDATE api_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", at, userId)
+	return err
+}
@@ -0,0 +1,305 @@
+//go:build sqlite_fts5
+
+package apitoken_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"app/internal/auth/apitoken"
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/internal/user"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type tokenFixture struct {
+	db      *sql.DB
+	repo    *apitoken.RepositorySqlite
+	service *apitoken.Service
+	// reader holds the users.read permission only.
+	reader *user.User
+}
+
+func newTokenFixture(t *testing.T) *tokenFixture {
+	hasher := core.DefaultPasswordHasher
+	core.DefaultPasswordHasher = &core.PasswordHasher{Algorithm: core.AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}
+	t.Cleanup(func() { core.DefaultPasswordHasher = hasher })
+
+	ctx := context.Background()
+	database := dbtest.Sqlite(t)
+	users := user.NewUserService(user.NewUserRepositorySqlite(database), &user.Options{})
+	role, _, err := users.StoreRole(ctx, &user.CreateRoleRequest{
+		Name:        "reader",
+		Permissions: []string{user.PermissionUsersRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, _, err := users.StoreUser(ctx, &user.CreateUserRequest{
+		Name:          "Reader",
+		Email:         "reader@example.com",
+		Password:      "Xk9#pLm2qRt7",
+		PasswordCheck: "Xk9#pLm2qRt7",
+		Roles:         []user.Role{*role},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := apitoken.NewRepositorySqlite(database)
+	return &tokenFixture{
+		db:      database,
+		repo:    repo,
+		service: apitoken.NewService(repo, users),
+		reader:  reader,
+	}
+}
+
+func (f *tokenFixture) create(t *testing.T, req *apitoken.CreateTokenRequest) (string, *apitoken.Token) {
+	t.Helper()
+	plain, token, errs, err := f.service.Create(context.Background(), f.reader, req)
+	if err != nil {
+		t.Fatalf("Create = %v, %v", errs, err)
+	}
+	return plain, token
+}
+
+// serve sends a request with the Authorization header through the middleware
+// and returns the response, the token and the user the handler was given.
+func (f *tokenFixture) serve(authorization string) (*httptest.ResponseRecorder, *apitoken.Token, *user.User) {
+	var token *apitoken.Token
+	var u *user.User
+	h := f.service.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ = apitoken.FromContext(r.Context())
+		u, _ = user.FromContext(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w, token, u
+}
+
+func TestCreateStoresOnlyTheHash(t *testing.T) {
+	f := newTokenFixture(t)
+	plain, token := f.create(t, &apitoken.CreateTokenRequest{Name: "ci"})
+	if want := "pat_" + token.Id + "_"; !strings.HasPrefix(plain, want) || len(plain) == len(want) {
+		t.Fatalf("plain text %q, want %q followed by the secret", plain, want)
+	}
+	secret := strings.TrimPrefix(plain, "pat_"+token.Id+"_")
+
+	var stored string
+	if err := f.db.QueryRow("SELECT hash FROM api_tokens WHERE id = ?", token.Id).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == "" || strings.Contains(stored, secret) {
+		t.Errorf("stored hash %q, want the hash of the secret only", stored)
+	}
+	tokens, err := f.service.List(context.Background(), f.reader.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Id != token.Id {
+		t.Fatalf("List = %+v, want the created token", tokens)
+	}
+}
+
+func TestCreateValidates(t *testing.T) {
+	f := newTokenFixture(t)
+	_, _, errs, err := f.service.Create(context.Background(), f.reader, &apitoken.CreateTokenRequest{
+		ExpiresAt: time.Now().Add(-time.Hour),
+	})
+	if !errors.Is(err, apitoken.ErrInvalidRequest) || errs["name"] == "" || errs["expires_at"] == "" {
+		t.Errorf("Create = %v, %v, want name and expires_at errors", errs, err)
+	}
+}
+
+func TestCreateDropsScopesTheUserLacks(t *testing.T) {
+	f := newTokenFixture(t)
+	_, token := f.create(t, &apitoken.CreateTokenRequest{
+		Name:   "ci",
+		Scopes: []string{user.PermissionUsersRead, user.PermissionUsersManage, user.PermissionAll},
+	})
+	if want := []string{user.PermissionUsersRead}; !slices.Equal(token.Scopes, want) {
+		t.Errorf("scopes %q, want %q", token.Scopes, want)
+	}
+	if !token.HasScope(user.PermissionUsersRead) || token.HasScope(user.PermissionUsersManage) {
+		t.Errorf("HasScope of %q is wrong", token.Scopes)
+	}
+	all := apitoken.Token{Scopes: []string{user.PermissionAll}}
+	if !all.HasScope(user.PermissionRolesManage) {
+		t.Error("a token with every permission lacks a scope")
+	}
+}
+
+func TestMiddlewareResolvesBearerToken(t *testing.T) {
+	f := newTokenFixture(t)
+	plain, created := f.create(t, &apitoken.CreateTokenRequest{Name: "ci"})
+
+	w, token, u := f.serve("bearer " + plain)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+	}
+	if token == nil || token.Id != created.Id {
+		t.Errorf("token in context %+v, want %s", token, created.Id)
+	}
+	if u == nil || u.Id != f.reader.Id {
+		t.Errorf("user in context %+v, want %s", u, f.reader.Id)
+	}
+
+	w, token, u = f.serve("")
+	if w.Code != http.StatusOK || token != nil || u != nil {
+		t.Errorf("request without a token: status %d, token %+v, user %+v, want it passed through untouched", w.Code, token, u)
+	}
+}
+
+func TestMiddlewareRejectsInvalidTokens(t *testing.T) {
+	f := newTokenFixture(t)
+	ctx := context.Background()
+	plain, _ := f.create(t, &apitoken.CreateTokenRequest{Name: "valid"})
+	expired, expiring := f.create(t, &apitoken.CreateTokenRequest{Name: "expired", ExpiresAt: time.Now().Add(time.Hour)})
+	if _, err := f.db.Exec("UPDATE api_tokens SET expires_at = ? WHERE id = ?", time.Now().UTC().Add(-time.Minute), expiring.Id); err != nil {
+		t.Fatal(err)
+	}
+	revoked, revoking := f.create(t, &apitoken.CreateTokenRequest{Name: "revoked"})
+	if _, err := f.service.Revoke(ctx, f.reader.Id, revoking.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"malformed", "not-a-token"},
+		{"unknown id", "pat_unknown_secret"},
+		{"wrong secret", plain[:len(plain)-1] + "!"},
+		{"expired", expired},
+		{"revoked", revoked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, token, u := f.serve("Bearer " + tt.token)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+			if token != nil || u != nil {
+				t.Error("the handler was called")
+			}
+		})
+	}
+}
+
+func TestRevokeOnlyTheUsersTokens(t *testing.T) {
+	f := newTokenFixture(t)
+	ctx := context.Background()
+	plain, token := f.create(t, &apitoken.CreateTokenRequest{Name: "ci"})
+	if _, err := f.service.Revoke(ctx, core.NewID(), token.Id); !errors.Is(err, apitoken.ErrTokenNotFound) {
+		t.Errorf("Revoke by another user = %v, want ErrTokenNotFound", err)
+	}
+	if w, _, _ := f.serve("Bearer " + plain); w.Code != http.StatusOK {
+		t.Fatalf("status %d after a refused revocation, want %d", w.Code, http.StatusOK)
+	}
+
+	revoked, err := f.service.Revoke(ctx, f.reader.Id, token.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked.Revoked() {
+		t.Error("Revoke returned a live token")
+	}
+	stored, err := f.repo.Find(ctx, token.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.RevokedAt.Equal(revoked.RevokedAt) {
+		t.Errorf("stored revocation %s, want %s", stored.RevokedAt, revoked.RevokedAt)
+	}
+}
+
+func TestAuthenticateTracksLastUse(t *testing.T) {
+	f := newTokenFixture(t)
+	ctx := context.Background()
+	plain, token := f.create(t, &apitoken.CreateTokenRequest{Name: "ci"})
+	lastUsed := func() time.Time {
+		t.Helper()
+		stored, err := f.repo.Find(ctx, token.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored.LastUsedAt
+	}
+	if !lastUsed().IsZero() {
+		t.Fatal("a new token was used")
+	}
+
+	if _, _, err := f.service.Authenticate(ctx, plain); err != nil {
+		t.Fatal(err)
+	}
+	first := lastUsed()
+	if first.IsZero() {
+		t.Fatal("Authenticate did not record the use")
+	}
+	// Uses within a minute are not written again.
+	if _, _, err := f.service.Authenticate(ctx, plain); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastUsed(); !got.Equal(first) {
+		t.Errorf("last used %s right after %s, want it unchanged", got, first)
+	}
+
+	earlier := time.Now().UTC().Add(-time.Hour)
+	if err := f.repo.Touch(ctx, token.Id, earlier); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.service.Authenticate(ctx, plain); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastUsed(); !got.After(earlier.Add(time.Minute)) {
+		t.Errorf("last used %s, want it moved on from %s", got, earlier)
+	}
+}
+
+func TestRepositorySqliteJoinsTransaction(t *testing.T) {
+	f := newTokenFixture(t)
+	ctx := context.Background()
+	rollback := errors.New("rollback")
+	token := &apitoken.Token{
+		Id:        core.NewID(),
+		UserId:    f.reader.Id,
+		Name:      "ci",
+		Scopes:    []string{},
+		Hash:      "hash",
+		CreatedAt: time.Now().UTC(),
+	}
+	err := core.NewTxManager(f.db).WithinTx(ctx, func(ctx context.Context) error {
+		if err := f.repo.Store(ctx, token); err != nil {
+			return err
+		}
+		if _, err := f.repo.Find(ctx, token.Id); err != nil {
+			t.Errorf("Find in the transaction: %v", err)
+		}
+		if tokens, err := f.repo.ListByUser(ctx, f.reader.Id); err != nil || len(tokens) != 1 {
+			t.Errorf("ListByUser in the transaction = %v, %v, want the token", tokens, err)
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatal(err)
+	}
+	if _, err := f.repo.Find(ctx, token.Id); !errors.Is(err, apitoken.ErrTokenNotFound) {
+		t.Errorf("Find after rollback = %v, want ErrTokenNotFound", err)
+	}
+}
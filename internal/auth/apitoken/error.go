@@ -0,0 +1,13 @@
+package apitoken
+
+import "errors"
+
+var ErrTokenNotFound = errors.New("api token not found")
+
+var ErrInvalidToken = errors.New("invalid api token")
+
+var ErrTokenExpired = errors.New("api token expired")
+
+var ErrTokenRevoked = errors.New("api token revoked")
+
+var ErrInvalidRequest = errors.New("invalid request")
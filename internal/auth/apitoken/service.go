@@ -0,0 +1,157 @@
+package apitoken
+
+import (
+	"app/internal/core"
+	"app/internal/user"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// touchInterval limits how often last-used tracking writes to the database.
+const touchInterval = time.Minute
+
+type Service struct {
+	repo  Repository
+	users *user.UserService
+}
+
+func NewService(repo Repository, users *user.UserService) *Service {
+	return &Service{
+		repo:  repo,
+		users: users,
+	}
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a token for the user and returns its plain text value, which
+// cannot be recovered afterwards. Scopes the user does not hold are dropped.
+func (s *Service) Create(ctx context.Context, u *user.User, req *CreateTokenRequest) (string, *Token, map[string]string, error) {
+	errs := req.Validate()
+	if len(errs) > 0 {
+		return "", nil, errs, ErrInvalidRequest
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, nil, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if u.HasPermission(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	t := &Token{
+		Id:        core.NewID(),
+		UserId:    u.Id,
+		Name:      req.Name,
+		Scopes:    scopes,
+		Hash:      hashSecret(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	if !req.ExpiresAt.IsZero() {
+		t.ExpiresAt = req.ExpiresAt.UTC()
+	}
+	if err := s.repo.Store(ctx, t); err != nil {
+		return "", nil, nil, err
+	}
+	return tokenPrefix + t.Id + "_" + secret, t, nil, nil
+}
+
+func (s *Service) List(ctx context.Context, userId string) ([]Token, error) {
+	return s.repo.ListByUser(ctx, userId)
+}
+
+// Revoke revokes one of the user's tokens.
+func (s *Service) Revoke(ctx context.Context, userId, id string) (*Token, error) {
+	t, err := s.repo.Find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.UserId != userId {
+		return nil, ErrTokenNotFound
+	}
+	if t.Revoked() {
+		return t, nil
+	}
+	t.RevokedAt = time.Now().UTC()
+	return t, s.repo.Revoke(ctx, id, t.RevokedAt)
+}
+
+// RevokeAll revokes every token of the user, in the transaction of ctx if
+// any.
+func (s *Service) RevokeAll(ctx context.Context, userId string) error {
+	return s.repo.RevokeByUser(ctx, userId, time.Now().UTC())
+}
+
+// Authenticate resolves a plain text token to the token and its owner.
+func (s *Service) Authenticate(ctx context.Context, plain string) (*Token, *user.User, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(plain, tokenPrefix), "_")
+	if !ok || !strings.HasPrefix(plain, tokenPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+	t, err := s.repo.Find(ctx, id)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(t.Hash)) != 1 {
+		return nil, nil, ErrInvalidToken
+	}
+	if t.Revoked() {
+		return nil, nil, ErrTokenRevoked
+	}
+	if t.Expired() {
+		return nil, nil, ErrTokenExpired
+	}
+	u, err := s.users.Find(ctx, t.UserId)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	if u.Status != user.UserStatusActive {
+		return nil, nil, ErrInvalidToken
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(t.LastUsedAt) > touchInterval {
+		if err := s.repo.Touch(ctx, t.Id, now); err != nil {
+			log.Println(err)
+		}
+		t.LastUsedAt = now
+	}
+	return t, u, nil
+}
+
+// Middleware authenticates requests carrying an "Authorization: Bearer"
+// header and stores the token and its owner in the request context. Requests
+// without the header are passed through untouched.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, plain, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t, u, err := s.Authenticate(r.Context(), strings.TrimSpace(plain))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		ctx := NewContext(r.Context(), t)
+		ctx = user.NewContext(ctx, u)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
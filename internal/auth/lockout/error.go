@@ -0,0 +1,7 @@
+package lockout
+
+import "errors"
+
+var ErrAttemptNotFound = errors.New("login attempt not found")
+
+var ErrTooManyAttempts = errors.New("too many login attempts")
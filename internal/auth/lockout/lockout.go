@@ -0,0 +1,180 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// Attempt tracks consecutive login failures for a single key, either an
+// account email or a client IP.
+type Attempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// Repository stores attempts, in the transaction of ctx if any.
+type Repository interface {
+	Get(ctx context.Context, key string) (*Attempt, error)
+	// Increment atomically counts a failure at now, starting over when the
+	// last one is older than windowStart, and returns the new count.
+	Increment(ctx context.Context, key string, now, windowStart time.Time) (int, error)
+	// Lock locks the key until the given time, unless it already is for
+	// longer.
+	Lock(ctx context.Context, key string, until time.Time) error
+	Delete(ctx context.Context, key string) error
+	GC(ctx context.Context, before time.Time) error
+}
+
+type Policy struct {
+	// Threshold is the number of failures after which an account is locked.
+	Threshold int
+	// IPThreshold is the number of failures after which a client IP is locked.
+	IPThreshold int
+	// FreeAttempts is the number of failures allowed before backoff kicks in.
+	FreeAttempts int
+	// BaseDelay is doubled on every failure past FreeAttempts up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutDuration is how long a key stays locked once over its threshold.
+	LockoutDuration time.Duration
+	// Window is the period after which failures are forgotten.
+	Window time.Duration
+}
+
+var DefaultPolicy = Policy{
+	Threshold:       10,
+	IPThreshold:     100,
+	FreeAttempts:    3,
+	BaseDelay:       1 * time.Second,
+	MaxDelay:        1 * time.Minute,
+	LockoutDuration: 15 * time.Minute,
+	Window:          1 * time.Hour,
+}
+
+type Service struct {
+	repo       Repository
+	policy     Policy
+	gcInterval time.Duration
+}
+
+func New(repo Repository, policy Policy, gcInterval time.Duration) *Service {
+	s := &Service{
+		repo:       repo,
+		policy:     policy,
+		gcInterval: gcInterval,
+	}
+	s.RunGC()
+	return s
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check reports whether a login for the given email and IP may be attempted.
+// When it may not, it returns ErrTooManyAttempts along with how long the
+// caller has to wait. The answer is the same whether the account exists or not.
+func (s *Service) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := time.Now().UTC()
+	var wait time.Duration
+	for _, key := range []string{emailKey(email), ipKey(ip)} {
+		a, err := s.repo.Get(ctx, key)
+		if errors.Is(err, ErrAttemptNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if d := a.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return wait, ErrTooManyAttempts
+	}
+	return 0, nil
+}
+
+// Fail records a failed login for the given email and IP. Accounts back off
+// exponentially, IPs are only locked once over their threshold so that users
+// sharing an address are not slowed down by each other.
+func (s *Service) Fail(ctx context.Context, email, ip string) error {
+	if err := s.fail(ctx, emailKey(email), s.policy.Threshold, true); err != nil {
+		return err
+	}
+	return s.fail(ctx, ipKey(ip), s.policy.IPThreshold, false)
+}
+
+func (s *Service) fail(ctx context.Context, key string, threshold int, backoff bool) error {
+	now := time.Now().UTC()
+	// The count comes from the database rather than from a read before the
+	// write, concurrent failures cannot overwrite each other.
+	failures, err := s.repo.Increment(ctx, key, now, now.Add(-s.policy.Window))
+	if err != nil {
+		return err
+	}
+	d := s.delay(failures, threshold, backoff)
+	if d == 0 {
+		return nil
+	}
+	return s.repo.Lock(ctx, key, now.Add(d))
+}
+
+// delay returns the exponential backoff for the given number of failures, or
+// the lockout duration once the threshold is reached.
+func (s *Service) delay(failures, threshold int, backoff bool) time.Duration {
+	if threshold > 0 && failures >= threshold {
+		return s.policy.LockoutDuration
+	}
+	n := failures - s.policy.FreeAttempts
+	if !backoff {
+		n = 0
+	}
+	if n <= 0 {
+		return 0
+	}
+	d := s.policy.BaseDelay
+	for i := 1; i < n && d < s.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, s.policy.MaxDelay)
+}
+
+// Succeed clears the failures of the account after a successful login. The IP
+// counter is left alone so a valid account cannot be used to reset it.
+func (s *Service) Succeed(ctx context.Context, email string) error {
+	return s.repo.Delete(ctx, emailKey(email))
+}
+
+// Unlock lifts a lockout on an account.
+func (s *Service) Unlock(ctx context.Context, email string) error {
+	return s.repo.Delete(ctx, emailKey(email))
+}
+
+func (s *Service) GC() {
+	if err := s.repo.GC(context.Background(), time.Now().UTC().Add(-s.policy.Window)); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Service) RunGC() {
+	go func() {
+		i := s.gcInterval
+		if i == 0 {
+			i = 1 * time.Hour
+		}
+		ticker := time.NewTicker(i)
+		for range ticker.C {
+			s.GC()
+		}
+	}()
+}
@@ -0,0 +1,52 @@
+package lockout
+
+import (
+	"app/internal/core"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewRepositoryPostgres(db *sql.DB) *RepositoryPostgres {
+	return &RepositoryPostgres{db}
+}
+
+func (r *RepositoryPostgres) Get(ctx context.Context, key string) (*Attempt, error) {
+	query := "SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE key = $1"
+	return scanAttemptRow(core.Conn(ctx, r.db).QueryRowContext(ctx, query, key))
+}
+
+func (r *RepositoryPostgres) Increment(ctx context.Context, key string, now, windowStart time.Time) (int, error) {
+	query := `INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures`
+	var failures int
+	err := core.Conn(ctx, r.db).QueryRowContext(ctx, query, key, now, windowStart).Scan(&failures)
+	return failures, err
+}
+
+func (r *RepositoryPostgres) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `UPDATE login_attempts SET locked_until = $1
+		WHERE key = $2 AND (locked_until IS NULL OR locked_until < $1)`
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, query, until, key)
+	return err
+}
+
+func (r *RepositoryPostgres) Delete(ctx context.Context, key string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
+
+func (r *RepositoryPostgres) GC(ctx context.Context, before time.Time) error {
+	query := `DELETE FROM login_attempts
+		WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $2)`
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, query, before, time.Now().UTC())
+	return err
+}
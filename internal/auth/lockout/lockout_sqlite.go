@@ -0,0 +1,71 @@
+package lockout
+
+import (
+	"app/internal/core"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RepositorySqlite struct {
+	db *sql.DB
+}
+
+func NewRepositorySqlite(db *sql.DB) *RepositorySqlite {
+	return &RepositorySqlite{db}
+}
+
+func scanAttemptRow(row core.Rowscan) (*Attempt, error) {
+	var a Attempt
+	var lockedUntil sql.NullTime
+	err := row.Scan(
+		&a.Key,
+		&a.Failures,
+		&a.LastFailureAt,
+		&lockedUntil,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAttemptNotFound
+		}
+		return nil, err
+	}
+	a.LockedUntil = lockedUntil.Time
+	return &a, nil
+}
+
+func (r *RepositorySqlite) Get(ctx context.Context, key string) (*Attempt, error) {
+	query := "SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE key = ?"
+	return scanAttemptRow(core.Conn(ctx, r.db).QueryRowContext(ctx, query, key))
+}
+
+func (r *RepositorySqlite) Increment(ctx context.Context, key string, now, windowStart time.Time) (int, error) {
+	query := `INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures`
+	var failures int
+	err := core.Conn(ctx, r.db).QueryRowContext(ctx, query, key, now, windowStart).Scan(&failures)
+	return failures, err
+}
+
+func (r *RepositorySqlite) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `UPDATE login_attempts SET locked_until = ?
+		WHERE key = ? AND (locked_until IS NULL OR locked_until < ?)`
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, query, until, key, until)
+	return err
+}
+
+func (r *RepositorySqlite) Delete(ctx context.Context, key string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "DELETE FROM login_attempts WHERE key = ?", key)
+	return err
+}
+
+func (r *RepositorySqlite) GC(ctx context.Context, before time.Time) error {
+	query := `DELETE FROM login_attempts
+		WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)`
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, query, before, time.Now().UTC())
+	return err
+}
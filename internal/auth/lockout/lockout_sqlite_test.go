@@ -0,0 +1,87 @@
+//go:build sqlite_fts5
+
+package lockout_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"app/internal/auth/lockout"
+	"app/internal/core"
+	"app/internal/db/dbtest"
+)
+
+func TestConcurrentFailuresAreAllCounted(t *testing.T) {
+	repo := lockout.NewRepositorySqlite(dbtest.Sqlite(t))
+	policy := lockout.DefaultPolicy
+	policy.Threshold = 10
+	s := lockout.New(repo, policy, time.Hour)
+	ctx := context.Background()
+
+	const attempts = 25
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Fail(ctx, "user@example.com", "192.0.2.1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	a, err := repo.Get(ctx, "email:user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Failures != attempts {
+		t.Errorf("Failures = %d, want %d", a.Failures, attempts)
+	}
+	if wait, err := s.Check(ctx, "user@example.com", "192.0.2.2"); !errors.Is(err, lockout.ErrTooManyAttempts) || wait < policy.LockoutDuration-time.Minute {
+		t.Errorf("Check = %s, %v, want a lockout of %s", wait, err, policy.LockoutDuration)
+	}
+}
+
+func TestFailuresOutsideTheWindowStartOver(t *testing.T) {
+	repo := lockout.NewRepositorySqlite(dbtest.Sqlite(t))
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for range 5 {
+		if _, err := repo.Increment(ctx, "ip:192.0.2.1", now.Add(-2*time.Hour), now.Add(-3*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failures, err := repo.Increment(ctx, "ip:192.0.2.1", now, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 1 {
+		t.Errorf("Increment after the window = %d, want 1", failures)
+	}
+}
+
+func TestRepositorySqliteJoinsTransaction(t *testing.T) {
+	database := dbtest.Sqlite(t)
+	repo := lockout.NewRepositorySqlite(database)
+	ctx := context.Background()
+	rollback := errors.New("rollback")
+	err := core.NewTxManager(database).WithinTx(ctx, func(ctx context.Context) error {
+		if _, err := repo.Increment(ctx, "ip:192.0.2.1", time.Now().UTC(), time.Now().UTC().Add(-time.Hour)); err != nil {
+			return err
+		}
+		if _, err := repo.Get(ctx, "ip:192.0.2.1"); err != nil {
+			t.Errorf("Get in the transaction: %v", err)
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, "ip:192.0.2.1"); !errors.Is(err, lockout.ErrAttemptNotFound) {
+		t.Errorf("Get after rollback = %v, want ErrAttemptNotFound", err)
+	}
+}
@@ -0,0 +1,15 @@
+package oidc
+
+import "errors"
+
+var ErrProviderNotFound = errors.New("identity provider not found")
+
+var ErrInvalidState = errors.New("invalid or expired login request")
+
+var ErrInvalidToken = errors.New("invalid id token")
+
+var ErrEmailNotVerified = errors.New("the identity provider did not return a verified email")
+
+var ErrIdentityNotFound = errors.New("identity not found")
+
+var ErrAccountDisabled = errors.New("account is disabled")
@@ -0,0 +1,27 @@
+package oidc
+
+import (
+	"context"
+	"time"
+)
+
+// Identity links an account at an external provider to a local user.
+type Identity struct {
+	Provider    string
+	Subject     string
+	UserId      string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+// IdentityRepository stores identities, in the transaction of ctx if any.
+type IdentityRepository interface {
+	Find(ctx context.Context, provider, subject string) (*Identity, error)
+	FindByUser(ctx context.Context, userId string) ([]Identity, error)
+	Store(ctx context.Context, identity *Identity) error
+	Touch(ctx context.Context, provider, subject string, at time.Time) error
+	Delete(ctx context.Context, provider, subject string) error
+	// DeleteByUser unlinks every identity of the user.
+	DeleteByUser(ctx context.Context, userId string) error
+}
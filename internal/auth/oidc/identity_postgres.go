@@ -0,0 +1,79 @@
+package oidc
+
+import (
+	"app/internal/core"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type IdentityRepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewIdentityRepositoryPostgres(db *sql.DB) *IdentityRepositoryPostgres {
+	return &IdentityRepositoryPostgres{db}
+}
+
+func (r *IdentityRepositoryPostgres) Find(ctx context.Context, provider, subject string) (*Identity, error) {
+	query := `SELECT provider, subject, user_id, email, created_at, last_login_at
+		FROM user_identities WHERE provider = $1 AND subject = $2`
+	return scanIdentityRow(core.Conn(ctx, r.db).QueryRowContext(ctx, query, provider, subject))
+}
+
+func (r *IdentityRepositoryPostgres) FindByUser(ctx context.Context, userId string) ([]Identity, error) {
+	query := `SELECT provider, subject, user_id, email, created_at, last_login_at
+		FROM user_identities WHERE user_id = $1 ORDER BY provider`
+	rows, err := core.Conn(ctx, r.db).QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var identities []Identity
+	for rows.Next() {
+		i, err := scanIdentityRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *IdentityRepositoryPostgres) Store(ctx context.Context, identity *Identity) error {
+	query := `INSERT INTO user_identities (
+		provider, subject, user_id, email, created_at, last_login_at
+	) VALUES (
+		$1, $2, $3, $4, $5, $6
+	)`
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx,
+		query,
+		identity.Provider,
+		identity.Subject,
+		identity.UserId,
+		identity.Email,
+		identity.CreatedAt,
+		identity.LastLoginAt,
+	)
+	return err
+}
+
+func (r *IdentityRepositoryPostgres) Touch(ctx context.Context, provider, subject string, at time.Time) error {
+	query := "UPDATE user_identities SET last_login_at = $1 WHERE provider = $2 AND subject = $3"
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, query, at, provider, subject)
+	return err
+}
+
+func (r *IdentityRepositoryPostgres) Delete(ctx context.Context, provider, subject string) error {
+	query := "DELETE FROM user_identities WHERE provider = $1 AND subject = $2"
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, query, provider, subject)
+	return err
+}
+
+func (r *IdentityRepositoryPostgres) DeleteByUser(ctx context.Context, userId string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "DELETE FROM user_identities WHERE user_id = $1", userId)
+	return err
+}
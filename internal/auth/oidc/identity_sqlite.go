@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"app/internal/core"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type IdentityRepositorySqlite struct {
+	db *sql.DB
+}
+
+func NewIdentityRepositorySqlite(db *sql.DB) *IdentityRepositorySqlite {
+	return &IdentityRepositorySqlite{db}
+}
+
+func scanIdentityRow(row core.Rowscan) (*Identity, error) {
+	var i Identity
+	var email sql.NullString
+	var lastLoginAt sql.NullTime
+	err := row.Scan(
+		&i.Provider,
+		&i.Subject,
+		&i.UserId,
+		&email,
+		&i.CreatedAt,
+		&lastLoginAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, err
+	}
+	i.Email = email.String
+	i.LastLoginAt = lastLoginAt.Time
+	return &i, nil
+}
+
+func (r *IdentityRepositorySqlite) Find(ctx context.Context, provider, subject string) (*Identity, error) {
+	query := `SELECT provider, subject, user_id, email, created_at, last_login_at
+		FROM user_identities WHERE provider = ? AND subject = ?`
+	return scanIdentityRow(core.Conn(ctx, r.db).QueryRowContext(ctx, query, provider, subject))
+}
+
+func (r *IdentityRepositorySqlite) FindByUser(ctx context.Context, userId string) ([]Identity, error) {
+	query := `SELECT provider, subject, user_id, email, created_at, last_login_at
+		FROM user_identities WHERE user_id = ? ORDER BY provider`
+	rows, err := core.Conn(ctx, r.db).QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var identities []Identity
+	for rows.Next() {
+		i, err := scanIdentityRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *IdentityRepositorySqlite) Store(ctx context.Context, identity *Identity) error {
+	query := `INSERT INTO user_identities (
+		provider, subject, user_id, email, created_at, last_login_at
+	) VALUES (
+		?, ?, ?, ?, ?, ?
+	)`
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx,
+		query,
+		identity.Provider,
+		identity.Subject,
+		identity.UserId,
+		identity.Email,
+		identity.CreatedAt,
+		identity.LastLoginAt,
+	)
+	return err
+}
+
+func (r *IdentityRepositorySqlite) Touch(ctx context.Context, provider, subject string, at time.Time) error {
+	query := "UPDATE user_identities SET last_login_at = ? WHERE provider = ? AND subject = ?"
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, query, at, provider, subject)
+	return err
+}
+
+func (r *IdentityRepositorySqlite) Delete(ctx context.Context, provider, subject string) error {
+	query := "DELETE FROM user_identities WHERE provider = ? AND subject = ?"
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, query, provider, subject)
+	return err
+}
+
+func (r *IdentityRepositorySqlite) DeleteByUser(ctx context.Context, userId string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, "DELETE FROM user_identities WHERE user_id = ?", userId)
+	return err
+}
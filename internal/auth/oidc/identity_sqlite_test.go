@@ -0,0 +1,50 @@
+//go:build sqlite_fts5
+
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"app/internal/auth/oidc"
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/internal/user"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestIdentityRepositorySqliteJoinsTransaction(t *testing.T) {
+	core.DefaultPasswordHasher = &core.PasswordHasher{Algorithm: core.AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}
+	database := dbtest.Sqlite(t)
+	ctx := context.Background()
+	u, _, err := user.NewUserService(user.NewUserRepositorySqlite(database), &user.Options{}).StoreUser(ctx, &user.CreateUserRequest{
+		Name:          "Jane",
+		Email:         "jane@example.com",
+		Password:      "Qw7!zNb4vYc1",
+		PasswordCheck: "Qw7!zNb4vYc1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := oidc.NewIdentityRepositorySqlite(database)
+	rollback := errors.New("rollback")
+	err = core.NewTxManager(database).WithinTx(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		if err := r.Store(ctx, &oidc.Identity{Provider: "test", Subject: "subject", UserId: u.Id, CreatedAt: now, LastLoginAt: now}); err != nil {
+			return err
+		}
+		if _, err := r.Find(ctx, "test", "subject"); err != nil {
+			t.Errorf("Find in the transaction: %v", err)
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatal(err)
+	}
+	if _, err := r.Find(ctx, "test", "subject"); !errors.Is(err, oidc.ErrIdentityNotFound) {
+		t.Errorf("Find after rollback = %v, want ErrIdentityNotFound", err)
+	}
+}
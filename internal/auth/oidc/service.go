@@ -0,0 +1,220 @@
+package oidc
+
+import (
+	"app/internal/user"
+	"app/pkg/session"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const requestLifetime = 10 * time.Minute
+
+// authRequest is an authorization request waiting for the provider callback.
+type authRequest struct {
+	provider  string
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+type Options struct {
+	Providers  []Provider
+	Repository IdentityRepository
+	Users      *user.UserService
+	Session    *session.Manager
+	HTTPClient *http.Client
+}
+
+type Service struct {
+	providers  map[string]*provider
+	order      []string
+	identities IdentityRepository
+	users      *user.UserService
+	session    *session.Manager
+	mu         sync.Mutex
+	pending    map[string]*authRequest
+}
+
+func New(opts *Options) *Service {
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	s := &Service{
+		providers:  make(map[string]*provider),
+		identities: opts.Repository,
+		users:      opts.Users,
+		session:    opts.Session,
+		pending:    make(map[string]*authRequest),
+	}
+	for _, p := range opts.Providers {
+		s.providers[p.Name] = newProvider(p, client)
+		s.order = append(s.order, p.Name)
+	}
+	return s
+}
+
+// Providers returns the configured providers in registration order.
+func (s *Service) Providers() []Provider {
+	providers := make([]Provider, 0, len(s.order))
+	for _, name := range s.order {
+		providers = append(providers, s.providers[name].Provider)
+	}
+	return providers
+}
+
+// AuthCodeURL starts an authorization code flow with PKCE and returns the
+// opaque state, which the caller must bind to the browser, and the URL the
+// user has to be redirected to.
+func (s *Service) AuthCodeURL(ctx context.Context, providerName string) (string, string, error) {
+	p, ok := s.providers[providerName]
+	if !ok {
+		return "", "", ErrProviderNotFound
+	}
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString(48)
+	if err != nil {
+		return "", "", err
+	}
+	url, err := p.authCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for k, req := range s.pending {
+		if now.After(req.expiresAt) {
+			delete(s.pending, k)
+		}
+	}
+	s.pending[state] = &authRequest{
+		provider:  providerName,
+		nonce:     nonce,
+		verifier:  verifier,
+		expiresAt: now.Add(requestLifetime),
+	}
+	s.mu.Unlock()
+	return state, url, nil
+}
+
+// Linked reports whether the user has signed in through a provider.
+func (s *Service) Linked(ctx context.Context, userId string) (bool, error) {
+	identities, err := s.identities.FindByUser(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	return len(identities) > 0, nil
+}
+
+// Complete finishes the flow started by AuthCodeURL, links the external
+// identity to a local user and creates a session for it.
+func (s *Service) Complete(ctx context.Context, providerName, state, code string) (*session.Session, error) {
+	s.mu.Lock()
+	req, ok := s.pending[state]
+	delete(s.pending, state)
+	s.mu.Unlock()
+	if !ok || req.provider != providerName || time.Now().After(req.expiresAt) {
+		return nil, ErrInvalidState
+	}
+	p, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+
+	claims, err := p.exchange(ctx, code, req.verifier, req.nonce)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.resolveUser(ctx, providerName, claims)
+	if err != nil {
+		return nil, err
+	}
+	if u.Status != user.UserStatusActive {
+		return nil, ErrAccountDisabled
+	}
+	return s.session.Create(ctx, u.Id)
+}
+
+// resolveUser returns the user linked to the external identity. Unknown
+// identities are linked by verified email, creating the user if needed.
+func (s *Service) resolveUser(ctx context.Context, providerName string, claims *Claims) (*user.User, error) {
+	now := time.Now()
+	identity, err := s.identities.Find(ctx, providerName, claims.Subject)
+	if err == nil {
+		if err := s.identities.Touch(ctx, providerName, claims.Subject, now); err != nil {
+			return nil, err
+		}
+		return s.users.Find(ctx, identity.UserId)
+	}
+	if !errors.Is(err, ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	u, err := s.users.FindByEmail(ctx, claims.Email)
+	if errors.Is(err, user.ErrUserNotFound) {
+		u, err = s.createUser(ctx, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.identities.Store(ctx, &Identity{
+		Provider:    providerName,
+		Subject:     claims.Subject,
+		UserId:      u.Id,
+		Email:       claims.Email,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *Service) createUser(ctx context.Context, claims *Claims) (*user.User, error) {
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	// Users created from an external identity never receive this password,
+	// they keep signing in through the provider. The suffix covers any
+	// character class the password policy may require.
+	password, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	password += "!Aa1"
+	u, _, err := s.users.StoreUser(ctx, &user.CreateUserRequest{
+		Name:          name,
+		Email:         claims.Email,
+		Password:      password,
+		PasswordCheck: password,
+	})
+	return u, err
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
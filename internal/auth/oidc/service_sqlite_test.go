@@ -0,0 +1,274 @@
+//go:build sqlite_fts5
+
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"app/internal/auth/oidc"
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/internal/user"
+	"app/pkg/session"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const clientID = "client"
+
+// fakeProvider is an identity provider serving discovery, keys and a token
+// endpoint that checks the PKCE verifier of every code it issued.
+type fakeProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is what the provider remembers of an authorization request.
+type authorization struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key, codes: make(map[string]authorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "key",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	a, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != a.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(a.claims)})
+}
+
+func (p *fakeProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize plays the user signing in at the provider: it returns a code for
+// the authorization URL, whose ID token has the claims of the request and the
+// given ones.
+func (p *fakeProvider) authorize(t *testing.T, authURL string, claims map[string]any) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+	now := time.Now()
+	all := map[string]any{
+		"iss":   p.URL,
+		"aud":   clientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+	code := core.NewID()
+	p.mu.Lock()
+	p.codes[code] = authorization{challenge: q.Get("code_challenge"), claims: all}
+	p.mu.Unlock()
+	return code
+}
+
+type fixture struct {
+	provider *fakeProvider
+	service  *oidc.Service
+	users    *user.UserService
+}
+
+func newFixture(t *testing.T) *fixture {
+	core.DefaultPasswordHasher = &core.PasswordHasher{Algorithm: core.AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}
+	database := dbtest.Sqlite(t)
+	p := newFakeProvider(t)
+	users := user.NewUserService(user.NewUserRepositorySqlite(database), &user.Options{})
+	return &fixture{
+		provider: p,
+		users:    users,
+		service: oidc.New(&oidc.Options{
+			Providers: []oidc.Provider{{
+				Name:        "test",
+				Issuer:      p.URL,
+				ClientID:    clientID,
+				RedirectURL: "https://app.example.com/auth/test/callback",
+			}},
+			Repository: oidc.NewIdentityRepositorySqlite(database),
+			Users:      users,
+			Session:    session.New(&session.Options{Lifetime: time.Hour, SecretKey: []byte("test")}),
+			HTTPClient: p.Client(),
+		}),
+	}
+}
+
+// login runs the whole flow, the provider issuing the given claims.
+func (f *fixture) login(t *testing.T, claims map[string]any) (*session.Session, error) {
+	ctx := context.Background()
+	state, authURL, err := f.service.AuthCodeURL(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.service.Complete(ctx, "test", state, f.provider.authorize(t, authURL, claims))
+}
+
+func verified(subject, email string) map[string]any {
+	return map[string]any{"sub": subject, "email": email, "email_verified": true, "name": "Jane"}
+}
+
+func TestCompleteCreatesAndLinksUser(t *testing.T) {
+	f := newFixture(t)
+	s, err := f.login(t, verified("subject", "jane@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := f.users.FindByEmail(context.Background(), "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UserId != u.Id {
+		t.Errorf("session of %s, want the created user %s", s.UserId, u.Id)
+	}
+	// The identity is found by subject, whatever email the provider returns.
+	s, err = f.login(t, verified("subject", "changed@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UserId != u.Id {
+		t.Errorf("second login of %s, want the linked user %s", s.UserId, u.Id)
+	}
+}
+
+func TestCompleteLinksExistingUserByVerifiedEmail(t *testing.T) {
+	f := newFixture(t)
+	u, _, err := f.users.StoreUser(context.Background(), &user.CreateUserRequest{
+		Name:          "Jane",
+		Email:         "jane@example.com",
+		Password:      "Qw7!zNb4vYc1",
+		PasswordCheck: "Qw7!zNb4vYc1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := f.login(t, verified("subject", "jane@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UserId != u.Id {
+		t.Errorf("session of %s, want the existing user %s", s.UserId, u.Id)
+	}
+	linked, err := f.service.Linked(context.Background(), u.Id)
+	if err != nil || !linked {
+		t.Errorf("Linked = %v, %v, want true", linked, err)
+	}
+}
+
+func TestCompleteRejectsUnverifiedEmail(t *testing.T) {
+	f := newFixture(t)
+	claims := verified("subject", "jane@example.com")
+	claims["email_verified"] = false
+	if _, err := f.login(t, claims); !errors.Is(err, oidc.ErrEmailNotVerified) {
+		t.Errorf("Complete = %v, want ErrEmailNotVerified", err)
+	}
+}
+
+func TestCompleteRejectsNonceMismatch(t *testing.T) {
+	f := newFixture(t)
+	claims := verified("subject", "jane@example.com")
+	claims["nonce"] = "another request"
+	if _, err := f.login(t, claims); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("Complete = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestCompleteRejectsStateMismatch(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	_, authURL, err := f.service.AuthCodeURL(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := f.provider.authorize(t, authURL, verified("subject", "jane@example.com"))
+	if _, err := f.service.Complete(ctx, "test", "forged", code); !errors.Is(err, oidc.ErrInvalidState) {
+		t.Errorf("Complete = %v, want ErrInvalidState", err)
+	}
+}
+
+func TestCompleteSendsPKCEVerifier(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	state, authURL, err := f.service.AuthCodeURL(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A code issued for another challenge, as one intercepted from another
+	// browser would be, is refused by the token endpoint.
+	code := f.provider.authorize(t, authURL, verified("subject", "jane@example.com"))
+	f.provider.mu.Lock()
+	a := f.provider.codes[code]
+	a.challenge = "another challenge"
+	f.provider.codes[code] = a
+	f.provider.mu.Unlock()
+	if _, err := f.service.Complete(ctx, "test", state, code); err == nil {
+		t.Error("Complete succeeded with a code of another challenge")
+	}
+}
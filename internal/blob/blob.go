@@ -0,0 +1,24 @@
+package blob
+
+import (
+	"io"
+	"mime"
+	"path"
+)
+
+// BlobStore stores binary objects, such as uploaded images, by key. Keys are
+// slash separated paths.
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	// Get returns the object and its content type.
+	Get(key string) (io.ReadCloser, string, error)
+	Delete(key string) error
+}
+
+// ContentType guesses the content type of a key from its extension.
+func ContentType(key string) string {
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,7 @@
+package blob
+
+import "errors"
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+var ErrInvalidKey = errors.New("invalid blob key")
@@ -2,9 +2,15 @@ package core
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"math/big"
+	"strings"
 	"time"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -13,14 +19,210 @@ const (
 	maxSleepMs = 400
 )
 
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+type Argon2Params struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Validate returns an error for parameters argon2 cannot work with, such as
+// no iterations or no parallelism.
+func (p Argon2Params) Validate() error {
+	switch {
+	case p.Iterations < 1:
+		return errors.New("argon2id needs at least one iteration")
+	case p.Parallelism < 1:
+		return errors.New("argon2id needs a parallelism of at least one")
+	case p.Memory < 8*uint32(p.Parallelism):
+		return fmt.Errorf("argon2id needs at least %d KiB of memory for a parallelism of %d", 8*uint32(p.Parallelism), p.Parallelism)
+	case p.SaltLength < 8:
+		return errors.New("argon2id needs a salt of at least 8 bytes")
+	case p.KeyLength < 16:
+		return errors.New("argon2id needs a key of at least 16 bytes")
+	}
+	return nil
+}
+
+// PasswordHasher hashes new passwords with the configured algorithm and
+// verifies hashes of every supported one. Hashes are self describing: bcrypt
+// hashes use the $2a$ format and argon2id hashes use the PHC string format
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+type PasswordHasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// DefaultPasswordHasher is used by HashPassword and ComparePassword.
+var DefaultPasswordHasher = &PasswordHasher{
+	Algorithm:  AlgorithmBcrypt,
+	BcryptCost: bcrypt.DefaultCost,
+	Argon2:     DefaultArgon2Params,
+}
+
+// Validate returns an error for an unknown algorithm or unusable parameters,
+// so that a bad configuration is reported on startup rather than on the first
+// login.
+func (h *PasswordHasher) Validate() error {
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		return h.Argon2.Validate()
+	case AlgorithmBcrypt, "":
+		if h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be at most %d", bcrypt.MaxCost)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported password hash algorithm %q", h.Algorithm)
+}
+
+// Algorithms returns the algorithms stored hashes may have been made with:
+// the current one and bcrypt, the only one of earlier versions.
+func (h *PasswordHasher) Algorithms() []string {
+	if h.Algorithm == AlgorithmArgon2id {
+		return []string{AlgorithmArgon2id, AlgorithmBcrypt}
+	}
+	return []string{AlgorithmBcrypt}
+}
+
+// HashWith hashes the password with the given algorithm rather than the
+// current one, with the current parameters.
+func (h *PasswordHasher) HashWith(algorithm, password string) (string, error) {
+	other := *h
+	other.Algorithm = algorithm
+	return other.Hash(password)
+}
+
+// HashAlgorithm returns the algorithm the hash was made with.
+func HashAlgorithm(hash string) string {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return AlgorithmArgon2id
+	}
+	return AlgorithmBcrypt
+}
+
 func HashPassword(password string) (string, error) {
-	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(b), err
+	return DefaultPasswordHasher.Hash(password)
+}
+
+// ComparePassword reports whether the password matches the hash and whether
+// the hash should be replaced because it was made with another algorithm or
+// other parameters than the current ones.
+func ComparePassword(hash, password string) (bool, bool) {
+	return DefaultPasswordHasher.Compare(hash, password)
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		return h.hashArgon2id(password)
+	case AlgorithmBcrypt, "":
+		b, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost())
+		return string(b), err
+	}
+	return "", fmt.Errorf("unsupported password hash algorithm %q", h.Algorithm)
+}
+
+func (h *PasswordHasher) Compare(hash, password string) (bool, bool) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, false
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, candidate) != 1 {
+			return false, false
+		}
+		params.SaltLength = uint32(len(salt))
+		params.KeyLength = uint32(len(key))
+		return true, h.Algorithm != AlgorithmArgon2id || params != h.Argon2
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true, true
+	}
+	return true, (h.Algorithm != AlgorithmBcrypt && h.Algorithm != "") || cost != h.bcryptCost()
+}
+
+func (h *PasswordHasher) bcryptCost() int {
+	if h.BcryptCost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+	return h.BcryptCost
 }
 
-func ComparePassword(hash, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+func (h *PasswordHasher) hashArgon2id(password string) (string, error) {
+	p := h.Argon2
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		p.Memory,
+		p.Iterations,
+		p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrUnknownHashFormat
+	}
+	// argon2.IDKey panics on zero iterations or parallelism.
+	if p.Iterations < 1 || p.Parallelism < 1 {
+		return p, nil, nil, ErrUnknownHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, ErrUnknownHashFormat
+	}
+	if len(salt) == 0 || len(key) == 0 {
+		return p, nil, nil, ErrUnknownHashFormat
+	}
+	return p, salt, key, nil
 }
 
 func GetRandomSleep() time.Duration {
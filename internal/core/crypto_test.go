@@ -0,0 +1,107 @@
+package core
+
+import "testing"
+
+func TestCompareRejectsUnusableArgon2Hashes(t *testing.T) {
+	for _, hash := range []string{
+		"$argon2id$v=19$m=65536,t=3,p=0$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=65536,t=0,p=2$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHQ$",
+	} {
+		if valid, _ := DefaultPasswordHasher.Compare(hash, "password"); valid {
+			t.Errorf("Compare(%q) = valid", hash)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher PasswordHasher
+		ok     bool
+	}{
+		{"default", *DefaultPasswordHasher, true},
+		{"argon2id", PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2: DefaultArgon2Params}, true},
+		{"unknown algorithm", PasswordHasher{Algorithm: "md5"}, false},
+		{"bcrypt cost too high", PasswordHasher{Algorithm: AlgorithmBcrypt, BcryptCost: 40}, false},
+		{"no parallelism", PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2: Argon2Params{Memory: 65536, Iterations: 3, SaltLength: 16, KeyLength: 32}}, false},
+		{"no iterations", PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2: Argon2Params{Memory: 65536, Parallelism: 2, SaltLength: 16, KeyLength: 32}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.hasher.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v", tt.name, err)
+		}
+	}
+}
+
+// fastArgon2 keeps argon2id cheap in tests.
+var fastArgon2 = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashRoundTrip(t *testing.T) {
+	for _, h := range []*PasswordHasher{
+		{Algorithm: AlgorithmBcrypt, BcryptCost: 4},
+		{Algorithm: AlgorithmArgon2id, Argon2: fastArgon2},
+	} {
+		t.Run(h.Algorithm, func(t *testing.T) {
+			hash, err := h.Hash("Qw7!zNb4vYc1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := HashAlgorithm(hash); got != h.Algorithm {
+				t.Errorf("HashAlgorithm = %s, want %s", got, h.Algorithm)
+			}
+			if valid, rehash := h.Compare(hash, "Qw7!zNb4vYc1"); !valid || rehash {
+				t.Errorf("Compare of the password = %v, %v, want true, false", valid, rehash)
+			}
+			if valid, rehash := h.Compare(hash, "Qw7!zNb4vYc2"); valid || rehash {
+				t.Errorf("Compare of another password = %v, %v, want false, false", valid, rehash)
+			}
+			other, err := h.Hash("Qw7!zNb4vYc1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if other == hash {
+				t.Error("two hashes of the same password are equal, want a salt each")
+			}
+		})
+	}
+}
+
+func TestCompareNeedsRehash(t *testing.T) {
+	bcrypt4 := &PasswordHasher{Algorithm: AlgorithmBcrypt, BcryptCost: 4}
+	bcrypt5 := &PasswordHasher{Algorithm: AlgorithmBcrypt, BcryptCost: 5}
+	argon := &PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2: fastArgon2}
+	moreMemory := fastArgon2
+	moreMemory.Memory = 128
+	longerKey := fastArgon2
+	longerKey.KeyLength = 64
+
+	tests := []struct {
+		name   string
+		from   *PasswordHasher
+		to     *PasswordHasher
+		rehash bool
+	}{
+		{"same bcrypt cost", bcrypt4, bcrypt4, false},
+		{"higher bcrypt cost", bcrypt4, bcrypt5, true},
+		{"lower bcrypt cost", bcrypt5, bcrypt4, true},
+		{"bcrypt to argon2id", bcrypt4, argon, true},
+		{"argon2id to bcrypt", argon, bcrypt4, true},
+		{"same argon2id parameters", argon, argon, false},
+		{"more argon2id memory", argon, &PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2: moreMemory}, true},
+		{"longer argon2id key", argon, &PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2: longerKey}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.from.Hash("Qw7!zNb4vYc1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			valid, rehash := tt.to.Compare(hash, "Qw7!zNb4vYc1")
+			if !valid || rehash != tt.rehash {
+				t.Errorf("Compare = %v, %v, want true, %v", valid, rehash, tt.rehash)
+			}
+		})
+	}
+}
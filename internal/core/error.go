@@ -1,2 +1,54 @@
 package core
 
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+var ErrInvalidSort = errors.New("unknown sort column")
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// FieldError is a rejected request with the error of each of its fields. Its
+// message lists the fields for callers reporting it as text, such as commands;
+// the API reports them on their own.
+type FieldError struct {
+	Err    error
+	Fields map[string]string
+}
+
+// WithFields adds the field errors to err, it returns err when there are none.
+func WithFields(err error, fields map[string]string) error {
+	if len(fields) == 0 {
+		return err
+	}
+	return &FieldError{Err: err, Fields: fields}
+}
+
+func (e *FieldError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field, msg := range e.Fields {
+		fields = append(fields, field+": "+strings.ReplaceAll(msg, "\n", ", "))
+	}
+	sort.Strings(fields)
+	return e.Err.Error() + ": " + strings.Join(fields, "; ")
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// IsUniqueViolation reports whether err is a unique constraint failure, with
+// either database driver.
+func IsUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SortColumn is a column lists can be sorted by. Only indexed columns should
+// be offered, every page is read in that order.
+type SortColumn struct {
+	Column string
+	// Time marks columns holding a time, so cursor values are decoded as one.
+	Time bool
+}
+
+type Sort struct {
+	SortColumn
+	Desc bool
+}
+
+// ParseSort reads a sort like "created_at" or "-created_at", descending,
+// allowing only the given columns. An empty sort uses fallback.
+func ParseSort(s string, columns map[string]SortColumn, fallback string) (Sort, error) {
+	if s == "" {
+		s = fallback
+	}
+	name, desc := strings.CutPrefix(s, "-")
+	col, ok := columns[name]
+	if !ok {
+		return Sort{}, ErrInvalidSort
+	}
+	return Sort{SortColumn: col, Desc: desc}, nil
+}
+
+// Cursor points after the last row of a page, by its sort value and id.
+type Cursor struct {
+	Value any    `json:"v"`
+	Id    string `json:"id"`
+}
+
+func EncodeCursor(value any, id string) string {
+	b, err := json.Marshal(Cursor{Value: value, Id: id})
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string, col SortColumn) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Id == "" {
+		return nil, ErrInvalidCursor
+	}
+	if col.Time {
+		v, ok := c.Value.(string)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		c.Value = t
+	}
+	return &c, nil
+}
+
+// ListQuery builds the statements of a paginated list: the count of the
+// matching rows and one page of them, by offset or after a cursor. Rows are
+// always ordered by the sort column then by id, so pages are stable.
+type ListQuery struct {
+	table    string
+	columns  string
+	sort     Sort
+	conds    []string
+	args     []any
+	rank     string
+	rankArgs []any
+}
+
+func NewListQuery(table, columns string, sort Sort) *ListQuery {
+	return &ListQuery{table: table, columns: columns, sort: sort}
+}
+
+// Where adds a condition, conditions are joined with AND.
+func (q *ListQuery) Where(cond string, args ...any) *ListQuery {
+	q.conds = append(q.conds, cond)
+	q.args = append(q.args, args...)
+	return q
+}
+
+// Rank orders the rows by expr, which may hold placeholders for args and end
+// with a direction, before the sort column. Ranked lists can only be paged by
+// offset.
+func (q *ListQuery) Rank(expr string, args ...any) *ListQuery {
+	q.rank = expr
+	q.rankArgs = args
+	return q
+}
+
+func (q *ListQuery) Ranked() bool {
+	return q.rank != ""
+}
+
+func (q *ListQuery) where(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+func (q *ListQuery) Count() (string, []any) {
+	return "SELECT COUNT(*) FROM " + q.table + q.where(q.conds), q.args
+}
+
+func (q *ListQuery) orderBy() string {
+	dir := "ASC"
+	if q.sort.Desc {
+		dir = "DESC"
+	}
+	rank := ""
+	if q.rank != "" {
+		rank = q.rank + ", "
+	}
+	if q.sort.Column == "id" {
+		return fmt.Sprintf(" ORDER BY %sid %s", rank, dir)
+	}
+	return fmt.Sprintf(" ORDER BY %s%s %s, id %s", rank, q.sort.Column, dir, dir)
+}
+
+// Page selects limit rows starting at offset.
+func (q *ListQuery) Page(limit, offset int) (string, []any) {
+	query := "SELECT " + q.columns + " FROM " + q.table + q.where(q.conds) + q.orderBy() + " LIMIT ? OFFSET ?"
+	args := append(append([]any{}, q.args...), q.rankArgs...)
+	return query, append(args, limit, offset)
+}
+
+// After selects limit rows following the encoded cursor.
+func (q *ListQuery) After(cursor string, limit int) (string, []any, error) {
+	if q.Ranked() {
+		return "", nil, ErrInvalidCursor
+	}
+	c, err := DecodeCursor(cursor, q.sort.SortColumn)
+	if err != nil {
+		return "", nil, err
+	}
+	op := ">"
+	if q.sort.Desc {
+		op = "<"
+	}
+	conds := append([]string{}, q.conds...)
+	args := append([]any{}, q.args...)
+	if q.sort.Column == "id" {
+		conds = append(conds, "id "+op+" ?")
+		args = append(args, c.Id)
+	} else {
+		conds = append(conds, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", q.sort.Column, op, q.sort.Column, op))
+		args = append(args, c.Value, c.Value, c.Id)
+	}
+	query := "SELECT " + q.columns + " FROM " + q.table + q.where(conds) + q.orderBy() + " LIMIT ?"
+	return query, append(args, limit), nil
+}
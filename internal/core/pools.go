@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// maxCachedStmts bounds the statements prepared per pool, queries built
+// from filters beyond it run unprepared.
+const maxCachedStmts = 256
+
+// Pools routes the queries of a repository: those made in the transaction of
+// ctx to it, writes to the writer pool and other reads to the reader pool,
+// which may be the writer itself. Queries are prepared once per pool and the
+// statements reused, in transactions of the writer too.
+type Pools struct {
+	writer *sql.DB
+	writes *stmtCache
+	reads  *stmtCache
+}
+
+func NewPools(writer, reader *sql.DB) *Pools {
+	p := &Pools{writer: writer, writes: newStmtCache(writer)}
+	p.reads = p.writes
+	if reader != writer {
+		p.reads = newStmtCache(reader)
+	}
+	return p
+}
+
+// Write returns the transaction of ctx, or the writer pool.
+func (p *Pools) Write(ctx context.Context) DBTX {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return stmtConn{p.writes, tx}
+	}
+	return stmtConn{p.writes, nil}
+}
+
+// Read returns the transaction of ctx, which must see its own writes, or
+// the reader pool.
+func (p *Pools) Read(ctx context.Context) DBTX {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return stmtConn{p.writes, tx}
+	}
+	return stmtConn{p.reads, nil}
+}
+
+// InTx is InTx on the writer pool with prepared statements.
+func (p *Pools) InTx(ctx context.Context, fn func(tx DBTX) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(stmtConn{p.writes, tx})
+	}
+	tx, err := p.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(stmtConn{p.writes, tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type stmtCache struct {
+	db    *sql.DB
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{db: db, stmts: make(map[string]*sql.Stmt)}
+}
+
+func (c *stmtCache) get(query string) *sql.Stmt {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stmts[query]
+}
+
+// prepare returns the statement of query, or nil when it cannot be cached.
+// The lock is not held while preparing, which waits for a connection.
+func (c *stmtCache) prepare(ctx context.Context, query string) *sql.Stmt {
+	if stmt := c.get(query); stmt != nil {
+		return stmt
+	}
+	c.mu.RLock()
+	full := len(c.stmts) >= maxCachedStmts
+	c.mu.RUnlock()
+	if full {
+		return nil
+	}
+	// Errors are left to the unprepared query, which reports them.
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if other, ok := c.stmts[query]; ok {
+		stmt.Close()
+		return other
+	}
+	c.stmts[query] = stmt
+	return stmt
+}
+
+// stmtConn runs queries through the statements of cache, in tx when set.
+type stmtConn struct {
+	cache *stmtCache
+	tx    *sql.Tx
+}
+
+func (c stmtConn) conn() DBTX {
+	if c.tx != nil {
+		return c.tx
+	}
+	return c.cache.db
+}
+
+func (c stmtConn) stmt(ctx context.Context, query string) *sql.Stmt {
+	if c.tx == nil {
+		return c.cache.prepare(ctx, query)
+	}
+	// Preparing on the pool could wait for the connection of tx, the only
+	// one of a single writer. Queries not run outside a transaction yet run
+	// unprepared.
+	stmt := c.cache.get(query)
+	if stmt == nil {
+		return nil
+	}
+	// Closed with the transaction, the statement of the pool is reused when
+	// the transaction runs on a connection it was prepared on.
+	return c.tx.StmtContext(ctx, stmt)
+}
+
+func (c stmtConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if stmt := c.stmt(ctx, query); stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return c.conn().ExecContext(ctx, query, args...)
+}
+
+func (c stmtConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if stmt := c.stmt(ctx, query); stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return c.conn().QueryContext(ctx, query, args...)
+}
+
+func (c stmtConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if stmt := c.stmt(ctx, query); stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return c.conn().QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Rebind numbers the ? placeholders of query, $1, $2 and so on, as
+// PostgreSQL expects. Queries must not hold a ? anywhere else, such as in a
+// string literal.
+func Rebind(query string) string {
+	n := strings.Count(query, "?")
+	if n == 0 {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + n*2)
+	i := 0
+	for {
+		j := strings.IndexByte(query, '?')
+		if j < 0 {
+			break
+		}
+		i++
+		b.WriteString(query[:j])
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(i))
+		query = query[j+1:]
+	}
+	b.WriteString(query)
+	return b.String()
+}
+
+// Rebound returns db rebinding the placeholders of every query, so that
+// queries written for SQLite, such as those of ListQuery, run on PostgreSQL.
+func Rebound(db DBTX) DBTX {
+	return rebound{db}
+}
+
+type rebound struct {
+	db DBTX
+}
+
+func (r rebound) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.db.ExecContext(ctx, Rebind(query), args...)
+}
+
+func (r rebound) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return r.db.QueryContext(ctx, Rebind(query), args...)
+}
+
+func (r rebound) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return r.db.QueryRowContext(ctx, Rebind(query), args...)
+}
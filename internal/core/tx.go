@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// DBTX is what repositories query through, a *sql.DB or the *sql.Tx of the
+// unit of work in progress.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type txKey struct{}
+
+// Conn returns the transaction stored in ctx by a TxManager, or db when
+// there is none.
+func Conn(ctx context.Context, db *sql.DB) DBTX {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+// InTransaction reports whether ctx carries the transaction of a TxManager.
+func InTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*sql.Tx)
+	return ok
+}
+
+// InTx runs fn in the transaction of ctx, or in a new one committed when fn
+// succeeds. Repositories use it for statements that must apply together,
+// whether or not a caller already started a unit of work.
+func InTx(ctx context.Context, db *sql.DB, fn func(tx DBTX) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(tx)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TxManager runs units of work spanning several repositories.
+type TxManager struct {
+	db      *sql.DB
+	retries int
+	backoff time.Duration
+}
+
+func NewTxManager(db *sql.DB) *TxManager {
+	return &TxManager{db: db, retries: 5, backoff: 20 * time.Millisecond}
+}
+
+// WithinTx runs fn in a transaction stored in the context it is given, so
+// that every repository called with that context takes part in it. Calls
+// nested in fn join the outer transaction. When the database is busy the
+// whole of fn is run again, so it must not have side effects outside the
+// database, such as sending mail.
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = m.run(ctx, fn)
+		if !isBusy(err) || attempt == m.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(m.backoff << attempt):
+		}
+	}
+}
+
+func (m *TxManager) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isBusy reports whether err means the transaction lost against another one
+// and may succeed when run again: with SQLite another connection holds the
+// lock, which waiting on the busy timeout cannot solve inside a transaction,
+// with PostgreSQL a serialization failure or a deadlock.
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	return false
+}
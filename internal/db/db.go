@@ -3,30 +3,135 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"runtime"
 	"time"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Database drivers, the values of DATABASE_DRIVER.
+const (
+	DriverSqlite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+// DB is an open database. Writer runs writes and transactions, Reader the
+// reads made outside transactions. Reader is a pool of its own with SQLite
+// and Writer itself with PostgreSQL.
+type DB struct {
+	Driver string
+	Writer *sql.DB
+	Reader *sql.DB
+}
+
+func (d *DB) Close() error {
+	err := d.Writer.Close()
+	if d.Reader != d.Writer {
+		if rerr := d.Reader.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// Open connects to the database of the driver, dsn is the path of the file
+// for SQLite and a connection URL for PostgreSQL.
+func Open(driver, dsn string) (*DB, error) {
+	switch driver {
+	case DriverSqlite:
+		return NewSqlite(dsn)
+	case DriverPostgres:
+		db, err := NewPostgresConnection(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &DB{Driver: driver, Writer: db, Reader: db}, nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q", driver)
+}
+
+// sqliteParams are set on every connection.
+var sqliteParams = map[string]string{
+	"_busy_timeout": "5000",
+	"_foreign_keys": "ON",
+	"_cache_size":   "-2000",
+	"_synchronous":  "NORMAL",
+	"_mmap_size":    "2147483648",
+	"_temp_store":   "MEMORY",
+}
+
+// sqliteWriterParams are set by the writer only, they change the file.
+var sqliteWriterParams = map[string]string{
+	"_journal_mode":       "WAL",
+	"_incremental_vacuum": "1",
+	"_page_size":          "32768",
+	"_auto_vacuum":        "incremental",
+}
+
+func sqliteDSN(path string, params ...map[string]string) string {
+	dsn := path + "?"
+	for _, p := range params {
+		for k, v := range p {
+			dsn += fmt.Sprintf("%s=%s&", k, v)
+		}
+	}
+	return dsn
+}
+
+func openSqlite(dsn string) (*sql.DB, error) {
+	db, err := sql.Open(DriverSqlite, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error connecting to the database: %w", err)
+	}
+	return db, nil
+}
+
+// NewSqlite opens the database as a single writer connection and a pool of
+// query-only readers. In WAL mode readers never wait, but writers exclude
+// each other: more writer connections only fail with SQLITE_BUSY where the
+// single one makes writes queue. The writer begins transactions with BEGIN
+// IMMEDIATE, so that they take the lock up front instead of failing when a
+// read turns into a write. Connections are kept open, they hold the prepared
+// statements of core.Pools.
+func NewSqlite(path string) (*DB, error) {
+	writer, err := openSqlite(sqliteDSN(path, sqliteParams, sqliteWriterParams, map[string]string{"_txlock": "immediate"}))
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+
+	reader, err := openSqlite(sqliteDSN(path, sqliteParams, map[string]string{"_query_only": "true"}))
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	readers := max(4, runtime.NumCPU())
+	reader.SetMaxOpenConns(readers)
+	reader.SetMaxIdleConns(readers)
+	return &DB{Driver: DriverSqlite, Writer: writer, Reader: reader}, nil
+}
+
+// NewSqliteConnection opens the database as a single pool for reads and
+// writes, as the app did before NewSqlite. bench-auth compares them.
 func NewSqliteConnection(path string) (*sql.DB, error) {
-	config := map[string]string{
-		"_busy_timeout": "5000",
-		"_foreign_keys": "ON",
-		"cache_size": "-2000",
-		"_synchronous": "NORMAL",
-		 "_journal_mode": "WAL",
-		"_incremental_vacuum": "1",
-		"_mmap_size": "2147483648",
-		"_temp_store": "MEMORY",
-		"_page_size": "32768",
-		"_auto_vacuum": "incremental",
-	}
-	params := ""
-	for k, v := range config {
-		params += fmt.Sprintf("%s=%s&", k, v)
-	}
-	dsn := fmt.Sprintf("%s?%s", path, params)
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := openSqlite(sqliteDSN(path, sqliteParams, sqliteWriterParams))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(25)
+	db.SetConnMaxLifetime(5 * time.Minute)
+	return db, nil
+}
+
+func NewPostgresConnection(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
 	}
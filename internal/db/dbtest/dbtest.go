@@ -0,0 +1,89 @@
+// Package dbtest opens migrated databases for tests, such as the repository
+// conformance suites. SQLite needs the sqlite_fts5 build tag, like the app:
+//
+//	go test -tags sqlite_fts5 ./...
+package dbtest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"testing"
+
+	"app/db/migrations"
+	"app/internal/db"
+)
+
+// Sqlite returns an in-memory database, closed when the test ends. It has a
+// single connection, every connection to :memory: opening its own database,
+// so a test holding rows open while querying again blocks.
+func Sqlite(t testing.TB) *sql.DB {
+	t.Helper()
+	database, err := sql.Open(db.DriverSqlite, ":memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.SetMaxOpenConns(1)
+	t.Cleanup(func() { database.Close() })
+	migrate(t, database, db.DriverSqlite)
+	return database
+}
+
+// Postgres returns a database in a schema of its own on the server of
+// TEST_DATABASE_URL, dropped when the test ends. The test is skipped when
+// the variable is not set.
+func Postgres(t testing.TB) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	admin, err := db.NewPostgresConnection(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	schema := "test_" + hex.EncodeToString(b)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pq sends unknown parameters as settings of the session.
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	database, err := db.NewPostgresConnection(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.Close()
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+	migrate(t, database, db.DriverPostgres)
+	return database
+}
+
+func migrate(t testing.TB, database *sql.DB, driver string) {
+	t.Helper()
+	fsys, err := migrations.For(driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := db.NewMigrator(database, driver, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
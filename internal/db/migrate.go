@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"app/internal/core"
+)
+
+var ErrNoMigrationToRevert = errors.New("no migration to revert")
+
+// Migration is one file of the migrations directory, named
+// <version>_<name>.sql and written in the goose format.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// NoTx marks migrations that cannot run in a transaction, such as
+	// PRAGMAs. They are not run under the lock and must be idempotent.
+	NoTx bool
+}
+
+type MigrationStatus struct {
+	Migration
+	// AppliedAt is nil for pending migrations.
+	AppliedAt *time.Time
+}
+
+// Migrator applies the migrations of a directory and records the applied
+// versions in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// migrationLockId is the PostgreSQL advisory lock taken while migrating.
+const migrationLockId = 7_350_123_001
+
+func NewMigrator(db *sql.DB, driver string, fsys fs.FS) (*Migrator, error) {
+	if driver != DriverSqlite && driver != DriverPostgres {
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	m := &Migrator{db: db, driver: driver}
+	seen := make(map[int64]string)
+	for _, f := range files {
+		b, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+		migration, err := parseMigration(f, string(b))
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[migration.Version]; ok {
+			return nil, fmt.Errorf("%s: version already used by %s", f, other)
+		}
+		seen[migration.Version] = f
+		m.migrations = append(m.migrations, migration)
+	}
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+	return m, nil
+}
+
+// parseMigration reads the Up and Down sections of a goose migration. Their
+// statements are run at once, StatementBegin and StatementEnd are ignored.
+func parseMigration(file, content string) (Migration, error) {
+	base := strings.TrimSuffix(path.Base(file), ".sql")
+	prefix, name, _ := strings.Cut(base, "_")
+	version, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil || version < 1 {
+		return Migration{}, fmt.Errorf("%s: file name must start with a version number", file)
+	}
+	migration := Migration{Version: version, Name: name}
+	var up, down strings.Builder
+	var section *strings.Builder
+	for _, line := range strings.SplitAfter(content, "\n") {
+		annotation, ok := strings.CutPrefix(strings.TrimSpace(line), "-- +goose ")
+		if !ok {
+			if section != nil {
+				section.WriteString(line)
+			}
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(annotation)) {
+		case "up":
+			section = &up
+		case "down":
+			section = &down
+		case "no transaction":
+			migration.NoTx = true
+		}
+	}
+	migration.Up = strings.TrimSpace(up.String())
+	migration.Down = strings.TrimSpace(down.String())
+	if migration.Up == "" {
+		return Migration{}, fmt.Errorf("%s: missing -- +goose Up section", file)
+	}
+	return migration, nil
+}
+
+// conn returns db, rebinding placeholders for PostgreSQL.
+func (m *Migrator) conn(db core.DBTX) core.DBTX {
+	if m.driver == DriverPostgres {
+		return core.Rebound(db)
+	}
+	return db
+}
+
+func (m *Migrator) init(ctx context.Context) error {
+	timestamp := "DATETIME"
+	if m.driver == DriverPostgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at `+timestamp+` NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	return m.importGoose(ctx)
+}
+
+// importGoose records as applied the versions goose applied, for databases
+// migrated with it before the migrations were run by the app.
+func (m *Migrator) importGoose(ctx context.Context) error {
+	exists := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'goose_db_version'"
+	if m.driver == DriverPostgres {
+		exists = "SELECT COUNT(*) FROM pg_tables WHERE schemaname = current_schema() AND tablename = 'goose_db_version'"
+	}
+	var count int
+	if err := m.db.QueryRowContext(ctx, exists).Scan(&count); err != nil || count == 0 {
+		return err
+	}
+	names := make(map[int64]string)
+	for _, migration := range m.migrations {
+		names[migration.Version] = migration.Name
+	}
+	rows, err := m.db.QueryContext(ctx, "SELECT version_id, MAX(tstamp) FROM goose_db_version WHERE version_id > 0 AND is_applied GROUP BY version_id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type applied struct {
+		version int64
+		at      time.Time
+	}
+	var versions []applied
+	for rows.Next() {
+		var a applied
+		var at any
+		if err := rows.Scan(&a.version, &at); err != nil {
+			return err
+		}
+		// SQLite returns the aggregated time as text.
+		switch at := at.(type) {
+		case time.Time:
+			a.at = at
+		case string:
+			a.at, _ = time.Parse(time.DateTime, at)
+		}
+		if a.at.IsZero() {
+			a.at = time.Now().UTC()
+		}
+		versions = append(versions, a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	for _, a := range versions {
+		_, err := m.conn(m.db).ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?) ON CONFLICT (version) DO NOTHING",
+			a.version, names[a.version], a.at,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// Up applies the pending migrations in order and returns them. Each one runs
+// in a transaction holding a lock, the write lock of SQLite taken by BEGIN
+// IMMEDIATE or an advisory lock of PostgreSQL, and is skipped when another
+// process applied it meanwhile.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if err := m.init(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		ran, err := m.run(ctx, migration, migration.Up, true)
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if ran {
+			done = append(done, migration)
+		}
+	}
+	return done, nil
+}
+
+// Down reverts the last applied migration and returns it.
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	if err := m.init(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if _, err := m.run(ctx, migration, migration.Down, false); err != nil {
+			return nil, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		return &migration, nil
+	}
+	return nil, ErrNoMigrationToRevert
+}
+
+// run executes one direction of a migration and records it, reporting
+// whether it ran.
+func (m *Migrator) run(ctx context.Context, migration Migration, statements string, up bool) (bool, error) {
+	record := "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?) ON CONFLICT (version) DO NOTHING"
+	args := []any{migration.Version, migration.Name, time.Now().UTC()}
+	if !up {
+		record = "DELETE FROM schema_migrations WHERE version = ?"
+		args = args[:1]
+	}
+	if migration.NoTx {
+		if statements != "" {
+			if _, err := m.db.ExecContext(ctx, statements); err != nil {
+				return false, err
+			}
+		}
+		_, err := m.conn(m.db).ExecContext(ctx, record, args...)
+		return err == nil, err
+	}
+
+	tx, done, err := m.lock(ctx)
+	if err != nil {
+		return false, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			done(false)
+		}
+	}()
+	var count int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = ?", migration.Version).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if (count == 1) == up {
+		return false, nil
+	}
+	if statements != "" {
+		if _, err := tx.ExecContext(ctx, statements); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return false, err
+	}
+	committed = true
+	return true, done(true)
+}
+
+// lock begins a transaction holding the migration lock. done commits it, or
+// rolls it back when commit is false.
+func (m *Migrator) lock(ctx context.Context) (tx core.DBTX, done func(commit bool) error, err error) {
+	if m.driver == DriverPostgres {
+		tx, err := m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockId); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		return m.conn(tx), func(commit bool) error {
+			if commit {
+				return tx.Commit()
+			}
+			return tx.Rollback()
+		}, nil
+	}
+
+	// BEGIN IMMEDIATE cannot be issued through database/sql transactions, a
+	// dedicated connection is used.
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, func(commit bool) error {
+		defer conn.Close()
+		end := "ROLLBACK"
+		if commit {
+			end = "COMMIT"
+		}
+		_, err := conn.ExecContext(context.Background(), end)
+		return err
+	}, nil
+}
+
+// Status lists every migration, with the time it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.init(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		status[i].Migration = migration
+		if at, ok := applied[migration.Version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
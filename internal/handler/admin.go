@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"app/internal/audit"
+	"app/internal/user"
+	"app/internal/view/component"
+	"net/http"
+)
+
+func (h *Handler) handleUnlockUserRequest(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	email := r.Form.Get("email")
+	if email == "" {
+		return Render(w, r, component.Error("Email is required"))
+	}
+	if err := h.lockout.Unlock(r.Context(), user.NormalizeEmail(email)); err != nil {
+		return err
+	}
+	// Only the account is recorded, not the email as it was typed.
+	entry := audit.Entry{
+		Action:     audit.ActionAccountUnlocked,
+		TargetType: audit.TargetUser,
+	}
+	if u, err := h.user.FindByEmail(r.Context(), email); err == nil {
+		entry.TargetId = u.Id
+	}
+	h.audit.Record(r.Context(), entry)
+	return Render(w, r, component.Success("Account unlocked"))
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"app/internal/core"
+	"app/internal/user"
+	"app/pkg/session"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxJSONBodyBytes = 1 << 20
+
+// apiError is the envelope of every error returned by the JSON API.
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// WantsJSON reports whether the client asked for a JSON response.
+func WantsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if v == nil {
+		return nil
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return core.WithFields(user.ErrInvalidRequest, map[string]string{"body": "Content-Type must be application/json"})
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return core.WithFields(user.ErrInvalidRequest, map[string]string{"body": err.Error()})
+	}
+	return nil
+}
+
+// WriteJSONError maps known errors to a status code and writes them using the
+// API error envelope. Unknown errors are logged and reported as internal.
+func WriteJSONError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code, message := http.StatusInternalServerError, "internal_error", "internal server error"
+	switch {
+	case errors.Is(err, user.ErrUserNotFound), errors.Is(err, user.ErrRoleNotFound):
+		status, code, message = http.StatusNotFound, "not_found", err.Error()
+	case errors.Is(err, user.ErrUserAlreadyExists), errors.Is(err, user.ErrRoleAlreadyExists):
+		status, code, message = http.StatusConflict, "conflict", err.Error()
+	case errors.Is(err, user.ErrInvalidRequest):
+		status, code, message = http.StatusUnprocessableEntity, "invalid_request", err.Error()
+	case errors.Is(err, user.ErrInvalidEmailOrPassword), errors.Is(err, session.ErrUserUnauthorized):
+		status, code, message = http.StatusUnauthorized, "unauthorized", err.Error()
+	case errors.Is(err, session.ErrUserForbidden), errors.Is(err, user.ErrRoleAssignmentForbidden):
+		status, code, message = http.StatusForbidden, "forbidden", err.Error()
+	default:
+		slog.Error("API", "err", err.Error(), "path", fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+	}
+	body := apiError{Error: apiErrorBody{Code: code, Message: message}}
+	var ferr *core.FieldError
+	if errors.As(err, &ferr) {
+		// The fields are reported on their own, keep them out of the message.
+		body.Error.Message = ferr.Err.Error()
+		body.Error.Fields = ferr.Fields
+	}
+	WriteJSON(w, status, body)
+}
+
+// MakeAPIHandler is the JSON counterpart of MakeHandler.
+func MakeAPIHandler(h HttpHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			WriteJSONError(w, r, err)
+		}
+	}
+}
+
+// MakeAPIMiddleware is the JSON counterpart of MakeMiddleware.
+func MakeAPIMiddleware(h Middleware) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := h(w, r); err != nil {
+				WriteJSONError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// listRequestFromQuery reads a list request, reporting the dates it could not
+// parse as field errors.
+func listRequestFromQuery(r *http.Request) (user.ListRequest, map[string]string) {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	pageSize, _ := strconv.Atoi(q.Get("page_size"))
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	errs := make(map[string]string)
+	req := user.ListRequest{
+		Page:     page,
+		PageSize: pageSize,
+		Search:   q.Get("search"),
+		// Only users are soft-deleted, roles ignore it.
+		IncludeDeleted: q.Get("include_deleted") == "true",
+		Status:         user.UserStatus(q.Get("status")),
+		RoleId:         q.Get("role_id"),
+		Sort:           q.Get("sort"),
+		Cursor:         q.Get("cursor"),
+		SkipRoles:      q.Get("skip_roles") == "true",
+	}
+	for name, t := range map[string]*time.Time{"created_from": &req.CreatedFrom, "created_to": &req.CreatedTo} {
+		if v := q.Get(name); v != "" {
+			parsed, err := parseQueryTime(v)
+			if err != nil {
+				errs[name] = "Use a date like 2006-01-02 or 2006-01-02T15:04:05Z"
+				continue
+			}
+			*t = parsed
+		}
+	}
+	return req, errs
+}
+
+// parseQueryTime accepts RFC 3339 times and dates, which start at midnight
+// UTC.
+func parseQueryTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
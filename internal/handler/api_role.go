@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"app/internal/core"
+	"app/internal/user"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) handleAPIListRoles(w http.ResponseWriter, r *http.Request) error {
+	req, errs := listRequestFromQuery(r)
+	if len(errs) > 0 {
+		return core.WithFields(user.ErrInvalidRequest, errs)
+	}
+	res, errs, err := h.user.ListRoles(r.Context(), req)
+	if err != nil {
+		return core.WithFields(err, errs)
+	}
+	if res.Roles == nil {
+		res.Roles = []user.Role{}
+	}
+	return WriteJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) handleAPIGetRole(w http.ResponseWriter, r *http.Request) error {
+	role, err := h.user.FindRole(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, role)
+}
+
+func (h *Handler) handleAPICreateRole(w http.ResponseWriter, r *http.Request) error {
+	var req user.CreateRoleRequest
+	if err := DecodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	role, errs, err := h.user.StoreRole(r.Context(), &req)
+	if err != nil {
+		return core.WithFields(err, errs)
+	}
+	return WriteJSON(w, http.StatusCreated, role)
+}
+
+func (h *Handler) handleAPIUpdateRole(w http.ResponseWriter, r *http.Request) error {
+	role, err := h.user.FindRole(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	var req user.CreateRoleRequest
+	if err := DecodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	errs, err := h.user.UpdateRole(r.Context(), role, &req)
+	if err != nil {
+		return core.WithFields(err, errs)
+	}
+	return WriteJSON(w, http.StatusOK, role)
+}
+
+func (h *Handler) handleAPIDeleteRole(w http.ResponseWriter, r *http.Request) error {
+	if err := h.user.DeleteRole(r.Context(), chi.URLParam(r, "id")); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"app/internal/core"
+	"app/internal/user"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type apiEraseUserRequest struct {
+	Mode user.EraseMode `json:"mode"`
+}
+
+type apiCreateUserRequest struct {
+	user.CreateUserRequest
+	RoleIds []string `json:"role_ids"`
+}
+
+// requireRoleAssignment checks that the request may change role membership,
+// the token it was made with included. The service checks the permissions
+// of the roles themselves.
+func (h *Handler) requireRoleAssignment(r *http.Request) error {
+	actor, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	if !h.Can(r, actor, user.PermissionRolesManage) {
+		return user.ErrRoleAssignmentForbidden
+	}
+	return nil
+}
+
+func (h *Handler) handleAPIListUsers(w http.ResponseWriter, r *http.Request) error {
+	req, errs := listRequestFromQuery(r)
+	if len(errs) > 0 {
+		return core.WithFields(user.ErrInvalidRequest, errs)
+	}
+	res, errs, err := h.user.ListUsers(r.Context(), req)
+	if err != nil {
+		return core.WithFields(err, errs)
+	}
+	if res.Users == nil {
+		res.Users = []user.User{}
+	}
+	return WriteJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) handleAPIGetUser(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.user.Find(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, u)
+}
+
+func (h *Handler) handleAPICreateUser(w http.ResponseWriter, r *http.Request) error {
+	var req apiCreateUserRequest
+	if err := DecodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	req.Roles = nil
+	if len(req.RoleIds) > 0 {
+		if err := h.requireRoleAssignment(r); err != nil {
+			return err
+		}
+		roles, err := h.user.FindRoles(r.Context(), req.RoleIds)
+		if err != nil {
+			return err
+		}
+		if len(roles) != len(req.RoleIds) {
+			return core.WithFields(user.ErrInvalidRequest, map[string]string{"role_ids": "Unknown role"})
+		}
+		req.Roles = roles
+	}
+	u, errs, err := h.user.StoreUser(r.Context(), &req.CreateUserRequest)
+	if err != nil {
+		return core.WithFields(err, errs)
+	}
+	return WriteJSON(w, http.StatusCreated, u)
+}
+
+func (h *Handler) handleAPIUpdateUser(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.user.Find(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	var req user.UpdateUserRequest
+	if err := DecodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if req.RoleIds != nil && !u.HasRoleIds(req.RoleIds) {
+		if err := h.requireRoleAssignment(r); err != nil {
+			return err
+		}
+	}
+	errs, err := h.user.UpdateUser(r.Context(), u, &req)
+	if err != nil {
+		return core.WithFields(err, errs)
+	}
+	return WriteJSON(w, http.StatusOK, u)
+}
+
+func (h *Handler) handleAPIDeleteUser(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+	if _, err := h.user.Find(r.Context(), id); err != nil {
+		return err
+	}
+	if err := h.user.Delete(r.Context(), id); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusNoContent, nil)
+}
+
+func (h *Handler) handleAPIEraseUser(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.user.Find(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	var req apiEraseUserRequest
+	if err := DecodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if !req.Mode.Valid() {
+		return core.WithFields(user.ErrInvalidRequest, map[string]string{"mode": "Mode must be anonymize or delete"})
+	}
+	if err := h.privacy.Erase(r.Context(), u, req.Mode); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusNoContent, nil)
+}
+
+func (h *Handler) handleAPIRestoreUser(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.user.Restore(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, u)
+}
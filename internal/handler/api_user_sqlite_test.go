@@ -0,0 +1,130 @@
+//go:build sqlite_fts5
+
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"app/internal/auth/apitoken"
+	"app/internal/blob"
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/internal/seed"
+	"app/internal/user"
+	"app/pkg/session"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// apiFixture serves the routes over an SQLite database, the requests being
+// made with an API token of an administrator.
+type apiFixture struct {
+	handler http.Handler
+	users   *user.UserService
+	token   string
+}
+
+func newAPIFixture(t *testing.T) *apiFixture {
+	hasher := core.DefaultPasswordHasher
+	core.DefaultPasswordHasher = &core.PasswordHasher{Algorithm: core.AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}
+	t.Cleanup(func() { core.DefaultPasswordHasher = hasher })
+
+	ctx := context.Background()
+	database := dbtest.Sqlite(t)
+	users := user.NewUserService(user.NewUserRepositorySqlite(database), &user.Options{
+		Avatars: blob.NewLocalStore(t.TempDir()),
+	})
+	roles, err := seed.Roles(ctx, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := users.StoreUser(ctx, &user.CreateUserRequest{
+		Name:          "Admin",
+		Email:         "admin@example.com",
+		Password:      "Xk9#pLm2qRt7",
+		PasswordCheck: "Xk9#pLm2qRt7",
+		Roles:         []user.Role{roles[seed.RoleAdmin]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := apitoken.NewService(apitoken.NewRepositorySqlite(database), users)
+	plain, _, _, err := tokens.Create(ctx, admin, &apitoken.CreateTokenRequest{Name: "test", Scopes: []string{user.PermissionAll}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := session.New(&session.Options{Lifetime: time.Hour, SecretKey: []byte("test")})
+	return &apiFixture{
+		handler: NewHttpHandler(chi.NewRouter(), users, sm, Options{APITokens: tokens}),
+		users:   users,
+		token:   plain,
+	}
+}
+
+func (f *apiFixture) do(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, apiPrefix+path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+f.token)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	f.handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIUpdateUserKeepsAvatar(t *testing.T) {
+	f := newAPIFixture(t)
+	ctx := context.Background()
+	u, _, err := f.users.StoreUser(ctx, &user.CreateUserRequest{
+		Name:          "Jane",
+		Email:         "jane@example.com",
+		Password:      "Qw7!zNb4vYc1",
+		PasswordCheck: "Qw7!zNb4vYc1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 32, 32))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.users.UpdateAvatar(ctx, u, &img); err != nil {
+		t.Fatal(err)
+	}
+	avatar := u.Avatar
+
+	w := f.do(t, http.MethodPut, "/users/"+u.Id, `{"name": "Jane Doe"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT without avatar: status %d: %s", w.Code, w.Body)
+	}
+	var got user.User
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Jane Doe" || got.Avatar != avatar {
+		t.Errorf("PUT without avatar returned name %q, avatar %q, want Jane Doe, %q", got.Name, got.Avatar, avatar)
+	}
+
+	// The avatar is not part of the request, setting one is rejected.
+	w = f.do(t, http.MethodPut, "/users/"+u.Id, `{"name": "Jane Doe", "avatar": "/uploads/avatars/other.png"}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("PUT with avatar: status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	stored, err := f.users.Find(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Avatar != avatar {
+		t.Errorf("stored avatar %q, want %q", stored.Avatar, avatar)
+	}
+}
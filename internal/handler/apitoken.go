@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"app/internal/audit"
+	"app/internal/auth/apitoken"
+	component_apitoken "app/internal/view/component/apitoken"
+	"app/internal/view/page"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) APITokensPage(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	tokens, err := h.apiTokens.List(r.Context(), u.Id)
+	if err != nil {
+		return err
+	}
+	return Render(w, r, page.APITokens(u.Permissions(), tokens))
+}
+
+func (h *Handler) handleCreateAPITokenRequest(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	values := component_apitoken.CreateTokenFormValues{
+		Name:      r.Form.Get("name"),
+		Scopes:    r.Form["scopes"],
+		ExpiresIn: r.Form.Get("expires_in"),
+	}
+	req := &apitoken.CreateTokenRequest{
+		Name:   values.Name,
+		Scopes: values.Scopes,
+	}
+	if days, err := strconv.Atoi(values.ExpiresIn); err == nil {
+		req.ExpiresAt = time.Now().AddDate(0, 0, days)
+	}
+
+	plain, t, errors, err := h.apiTokens.Create(r.Context(), u, req)
+	if err != nil && errors == nil {
+		return err
+	}
+	if t != nil {
+		h.audit.Record(r.Context(), audit.Entry{
+			Action:     audit.ActionTokenCreated,
+			TargetType: audit.TargetAPIToken,
+			TargetId:   t.Id,
+			Diff:       map[string]audit.Change{"name": {To: t.Name}, "scopes": {To: t.Scopes}},
+		})
+	}
+	tokens, err := h.apiTokens.List(r.Context(), u.Id)
+	if err != nil {
+		return err
+	}
+	if errors != nil {
+		return Render(w, r, component_apitoken.APITokens("", u.Permissions(), tokens, values, component_apitoken.CreateTokenFormErrors{
+			Name:      errors["name"],
+			ExpiresAt: errors["expires_at"],
+		}))
+	}
+	return Render(w, r, component_apitoken.APITokens(
+		plain,
+		u.Permissions(),
+		tokens,
+		component_apitoken.CreateTokenFormValues{},
+		component_apitoken.CreateTokenFormErrors{},
+	))
+}
+
+func (h *Handler) handleRevokeAPITokenRequest(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	t, err := h.apiTokens.Revoke(r.Context(), u.Id, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	h.audit.Record(r.Context(), audit.Entry{
+		Action:     audit.ActionTokenRevoked,
+		TargetType: audit.TargetAPIToken,
+		TargetId:   t.Id,
+	})
+	return Render(w, r, component_apitoken.TokenRow(*t))
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"app/internal/audit"
+	component_audit "app/internal/view/component/audit"
+	"app/internal/view/page"
+	"net/http"
+	"time"
+)
+
+// auditPageSize is how many events the admin page shows, exports are not
+// limited.
+const auditPageSize = 200
+
+func auditFilterFromQuery(r *http.Request) (component_audit.FilterValues, audit.Filter) {
+	q := r.URL.Query()
+	values := component_audit.FilterValues{
+		ActorId:    q.Get("actor_id"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetId:   q.Get("target_id"),
+		From:       q.Get("from"),
+		To:         q.Get("to"),
+	}
+	filter := audit.Filter{
+		ActorId:    values.ActorId,
+		Action:     values.Action,
+		TargetType: values.TargetType,
+		TargetId:   values.TargetId,
+	}
+	if t, err := time.Parse(time.DateOnly, values.From); err == nil {
+		filter.From = t
+	}
+	// The end date is inclusive.
+	if t, err := time.Parse(time.DateOnly, values.To); err == nil {
+		filter.To = t.AddDate(0, 0, 1)
+	}
+	return values, filter
+}
+
+func (h *Handler) AuditPage(w http.ResponseWriter, r *http.Request) error {
+	values, filter := auditFilterFromQuery(r)
+	filter.Limit = auditPageSize
+	events, err := h.audit.List(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+	return Render(w, r, page.Audit(values, events))
+}
+
+func (h *Handler) handleAuditExportRequest(w http.ResponseWriter, r *http.Request) error {
+	_, filter := auditFilterFromQuery(r)
+	events, err := h.audit.List(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+	name := "audit-" + time.Now().UTC().Format("20060102-150405")
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		return audit.WriteJSON(w, events)
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	return audit.WriteCSV(w, events)
+}
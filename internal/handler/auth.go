@@ -1,8 +1,15 @@
 package handler
 
 import (
+	"app/internal/auth/lockout"
+	"app/internal/user"
 	"app/internal/view/component"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"time"
 )
 
 func (h *Handler) handleLoginRequest(w http.ResponseWriter, r *http.Request) error {
@@ -13,30 +20,70 @@ func (h *Handler) handleLoginRequest(w http.ResponseWriter, r *http.Request) err
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
 	remember := r.Form.Get("remember") == "on"
+	values := component.LoginFormValues{
+		Email: email,
+		Password: password,
+		Remember: remember,
+		Providers: h.loginProviders(),
+	}
+
+	ip := ClientIP(r)
+	// Count attempts per account, whatever the case the email was typed in.
+	key := user.NormalizeEmail(email)
+	if h.lockout != nil {
+		wait, err := h.lockout.Check(r.Context(), key, ip)
+		if errors.Is(err, lockout.ErrTooManyAttempts) {
+			return Render(w, r, component.LoginForm(values, tooManyAttemptsMessage(wait)))
+		}
+		if err != nil {
+			return err
+		}
+	}
 
-	u, err := h.user.Authenticate(email, password)
+	u, err := h.user.Authenticate(r.Context(), email, password)
 	if err != nil {
-		return Render(w, r, component.LoginForm(component.LoginFormValues{
-			Email: email,
-			Password: password,
-			Remember: remember,
-		}, err.Error()))
+		if h.lockout != nil {
+			if err := h.lockout.Fail(r.Context(), key, ip); err != nil {
+				slog.Error("lockout", "err", err.Error())
+			}
+		}
+		return Render(w, r, component.LoginForm(values, err.Error()))
+	}
+	if h.lockout != nil {
+		if err := h.lockout.Succeed(r.Context(), key); err != nil {
+			slog.Error("lockout", "err", err.Error())
+		}
 	}
 
-	s, err := h.session.Create(r.Context(), u.Id, remember)
+	s, err := h.session.Create(r.Context(), u.Id)
 	if err != nil {
 		return err
 	}
-	h.session.SetCookie(w, s.Id, remember)
+	h.session.SetCookie(w, s.Id)
+	if remember {
+		if err := h.session.Remember(r.Context(), w, u.Id); err != nil {
+			return err
+		}
+	}
 
 	return HxRedirect(w, r, "/dashboard")
 }
 
 func (h *Handler) handleLogoutRequest(w http.ResponseWriter, r *http.Request) error {
+	if err := h.session.Forget(w, r); err != nil {
+		slog.Error("session", "err", err.Error())
+	}
 	session, err := h.session.GetSession(r.Context())
 	if err != nil {
 		return HxRedirect(w, r, "/")
 	}
-	h.session.Destroy(session.Id)
+	h.session.Destroy(r.Context(), session)
 	return HxRedirect(w, r, "/")
 }
+
+func tooManyAttemptsMessage(wait time.Duration) string {
+	if wait < time.Minute {
+		return fmt.Sprintf("Too many login attempts, please try again in %d seconds", int(math.Ceil(wait.Seconds())))
+	}
+	return fmt.Sprintf("Too many login attempts, please try again in %d minutes", int(math.Ceil(wait.Minutes())))
+}
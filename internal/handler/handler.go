@@ -1,11 +1,21 @@
 package handler
 
 import (
+	"app/internal/audit"
+	"app/internal/auth/apitoken"
+	"app/internal/auth/lockout"
+	"app/internal/auth/oidc"
+	"app/internal/blob"
+	"app/internal/openapi"
+	"app/internal/privacy"
 	"app/internal/user"
 	"app/internal/view/component"
 	"app/pkg/session"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"sync"
 
@@ -21,6 +31,13 @@ type Middleware func(w http.ResponseWriter, r *http.Request) error
 
 type Options struct {
 	AllowedOrigins []string
+	OIDC           *oidc.Service
+	Lockout        *lockout.Service
+	APITokens      *apitoken.Service
+	// Blobs serves uploaded avatars under /uploads.
+	Blobs          blob.BlobStore
+	Audit          *audit.Service
+	Privacy        *privacy.Service
 }
 
 type Handler struct {
@@ -28,6 +45,13 @@ type Handler struct {
 	mu *sync.Mutex
 	user *user.UserService
 	session *session.Manager
+	oidc *oidc.Service
+	lockout *lockout.Service
+	apiTokens *apitoken.Service
+	openAPI *openapi.Document
+	blobs blob.BlobStore
+	audit *audit.Service
+	privacy *privacy.Service
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -39,20 +63,35 @@ func NewHttpHandler(r *chi.Mux, userService *user.UserService, session *session.
 		mu: &sync.Mutex{},
 		user: userService,
 		session: session,
+		oidc: opts.OIDC,
+		lockout: opts.Lockout,
+		apiTokens: opts.APITokens,
+		openAPI: NewOpenAPIDocument(),
+		blobs: opts.Blobs,
+		audit: opts.Audit,
+		privacy: opts.Privacy,
 	}
 	r.Use(middleware.Logger)
 	r.Use(middleware.RequestID, middleware.Recoverer)
+	r.Use(auditRequestMiddleware)
 	r.Use(h.session.SetSessionMiddleware)
+	if h.apiTokens != nil {
+		r.Use(h.apiTokens.Middleware)
+	}
+	r.Use(h.loadUserMiddleware)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   opts.AllowedOrigins,
     	AllowedMethods:   []string{"GET", "PUT", "POST", "DELETE", "HEAD", "OPTION"},
-    	AllowedHeaders:   []string{"User-Agent", "Content-Type", "Accept", "Accept-Encoding", "Accept-Language", "Cache-Control", "Connection", "DNT", "Host", "Origin", "Pragma", "Referer"},
+    	AllowedHeaders:   []string{"Authorization", "User-Agent", "Content-Type", "Accept", "Accept-Encoding", "Accept-Language", "Cache-Control", "Connection", "DNT", "Host", "Origin", "Pragma", "Referer"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	r.Get("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))).ServeHTTP)
+	if h.blobs != nil {
+		r.Get(user.AvatarURLPrefix+"*", MakeHandler(h.handleBlobRequest))
+	}
 
 	r.Get("/", MakeHandler(h.HomePage))
 	r.Group(func (r chi.Router) {
@@ -61,12 +100,82 @@ func NewHttpHandler(r *chi.Mux, userService *user.UserService, session *session.
 		r.Get("/signup", MakeHandler(h.CreateUserPage))
 		r.Post("/user/create", MakeHandler(h.handleCreateUserRequest))
 		r.Get("/logout", MakeHandler(h.handleLogoutRequest))
+		r.Get("/profile/email/confirm", MakeHandler(h.handleConfirmEmailPage))
+		r.Post("/profile/email/confirm", MakeHandler(h.handleConfirmEmailRequest))
+		if h.oidc != nil {
+			r.Get("/auth/{provider}/login", MakeHandler(h.handleOIDCLogin))
+			r.Get("/auth/{provider}/callback", MakeHandler(h.handleOIDCCallback))
+		}
 	})
 	r.Group(func (r chi.Router) {
 		r.Use(MakeMiddleware(h.session.RequireAuthenticationMiddleware))
 		r.Get("/dashboard", MakeHandler(h.DashboardPage))
+		r.Get("/profile", MakeHandler(h.ProfilePage))
+		r.Post("/profile", MakeHandler(h.handleUpdateProfileRequest))
+		r.Post("/profile/email", MakeHandler(h.handleChangeEmailRequest))
+		r.Post("/profile/password", MakeHandler(h.handleChangePasswordRequest))
+		r.Post("/profile/avatar", MakeHandler(h.handleUploadAvatarRequest))
+		if h.privacy != nil {
+			r.Get("/profile/export", MakeHandler(h.handleExportDataRequest))
+			r.Post("/profile/erase", MakeHandler(h.handleEraseAccountRequest))
+		}
+		if h.apiTokens != nil {
+			r.Get("/profile/tokens", MakeHandler(h.APITokensPage))
+			r.Post("/profile/tokens", MakeHandler(h.handleCreateAPITokenRequest))
+			r.Delete("/profile/tokens/{id}", MakeHandler(h.handleRevokeAPITokenRequest))
+		}
+	})
+	r.Get("/api/openapi.json", MakeAPIHandler(h.handleOpenAPIDocument))
+	r.Route(apiPrefix, func (r chi.Router) {
+		r.Group(func (r chi.Router) {
+			r.Use(MakeAPIMiddleware(h.RequirePermission(user.PermissionUsersRead)))
+			r.Get("/users", MakeAPIHandler(h.handleAPIListUsers))
+			r.Get("/users/{id}", MakeAPIHandler(h.handleAPIGetUser))
+		})
+		r.Group(func (r chi.Router) {
+			r.Use(MakeAPIMiddleware(h.RequirePermission(user.PermissionUsersManage)))
+			r.Post("/users", MakeAPIHandler(h.handleAPICreateUser))
+			r.Put("/users/{id}", MakeAPIHandler(h.handleAPIUpdateUser))
+			r.Delete("/users/{id}", MakeAPIHandler(h.handleAPIDeleteUser))
+			if h.privacy != nil {
+				r.Post("/users/{id}/erase", MakeAPIHandler(h.handleAPIEraseUser))
+			}
+			r.Post("/users/{id}/restore", MakeAPIHandler(h.handleAPIRestoreUser))
+		})
+		r.Group(func (r chi.Router) {
+			r.Use(MakeAPIMiddleware(h.RequirePermission(user.PermissionRolesRead)))
+			r.Get("/roles", MakeAPIHandler(h.handleAPIListRoles))
+			r.Get("/roles/{id}", MakeAPIHandler(h.handleAPIGetRole))
+		})
+		r.Group(func (r chi.Router) {
+			r.Use(MakeAPIMiddleware(h.RequirePermission(user.PermissionRolesManage)))
+			r.Post("/roles", MakeAPIHandler(h.handleAPICreateRole))
+			r.Put("/roles/{id}", MakeAPIHandler(h.handleAPIUpdateRole))
+			r.Delete("/roles/{id}", MakeAPIHandler(h.handleAPIDeleteRole))
+		})
+	})
+	r.Group(func (r chi.Router) {
+		r.Use(MakeMiddleware(h.session.RequireAuthenticationMiddleware))
+		r.Use(MakeMiddleware(h.RequirePermission(user.PermissionUsersRead)))
+		r.Get("/admin/users", MakeHandler(h.UsersPage))
 	})
+	r.Group(func (r chi.Router) {
+		r.Use(MakeMiddleware(h.session.RequireAuthenticationMiddleware))
+		r.Use(MakeMiddleware(h.RequirePermission(user.PermissionUsersManage)))
+		if h.lockout != nil {
+			r.Post("/admin/users/unlock", MakeHandler(h.handleUnlockUserRequest))
+		}
+	})
+	if h.audit != nil {
+		r.Group(func (r chi.Router) {
+			r.Use(MakeMiddleware(h.session.RequireAuthenticationMiddleware))
+			r.Use(MakeMiddleware(h.RequirePermission(user.PermissionAuditRead)))
+			r.Get("/admin/audit", MakeHandler(h.AuditPage))
+			r.Get("/admin/audit/export", MakeHandler(h.handleAuditExportRequest))
+		})
+	}
 
+	h.checkOpenAPIDrift(r)
 	h.r = r
 	return h
 }
@@ -74,6 +183,10 @@ func NewHttpHandler(r *chi.Mux, userService *user.UserService, session *session.
 func MakeHandler(h HttpHandler) http.HandlerFunc {
 	return func (w http.ResponseWriter, r *http.Request) {
 		if err := h(w, r); err != nil {
+			if WantsJSON(r) {
+				WriteJSONError(w, r, err)
+				return
+			}
 			slog.Error("API", "err", err.Error(), "path", fmt.Sprintf("%s %s", r.Method, r.URL.Path))
 			Render(w, r, component.Error(err.Error()))
 		}
@@ -84,6 +197,10 @@ func MakeMiddleware(h Middleware) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if err := h(w, r); err != nil {
+				if WantsJSON(r) {
+					WriteJSONError(w, r, err)
+					return
+				}
 				Render(w, r, component.Error(err.Error()))
 				slog.Error("API", "err", err.Error(), "path", fmt.Sprintf("%s %s", r.Method, r.URL.Path))
 				return
@@ -93,6 +210,88 @@ func MakeMiddleware(h Middleware) func(http.Handler) http.Handler {
 	}
 }
 
+// loadUserMiddleware stores the user of the session in the request context,
+// unless an API token already authenticated the request.
+func (h *Handler) loadUserMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, ok := user.FromContext(r.Context()); ok {
+			next.ServeHTTP(w, r.WithContext(withUser(r.Context(), u)))
+			return
+		}
+		s, err := h.session.GetSession(r.Context())
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u, err := h.user.Find(r.Context(), s.UserId)
+		if errors.Is(err, user.ErrUserNotFound) || err == nil && u.Status != user.UserStatusActive {
+			// Deleted and deactivated users are logged out, whatever
+			// session they still hold.
+			ctx, err := h.session.Invalidate(r.Context(), w)
+			if err != nil {
+				slog.Error("invalidate session", "err", err.Error())
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withUser(r.Context(), u)))
+	})
+}
+
+// auditRequestMiddleware stores the details audit events are recorded with.
+// It runs before authentication so that session events get them too; the
+// actor is added once the user is known.
+func auditRequestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := audit.NewContext(r.Context(), audit.Request{
+			IP:        ClientIP(r),
+			RequestId: middleware.GetReqID(r.Context()),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func withUser(ctx context.Context, u *user.User) context.Context {
+	req, _ := audit.FromContext(ctx)
+	req.ActorId = u.Id
+	return user.NewContext(audit.NewContext(ctx, req), u)
+}
+
+// CurrentUser returns the user authenticated by an API token or by the
+// session cookie.
+func (h *Handler) CurrentUser(r *http.Request) (*user.User, error) {
+	if u, ok := user.FromContext(r.Context()); ok {
+		return u, nil
+	}
+	return nil, session.ErrUserUnauthorized
+}
+
+// Can reports whether the request may use the permission. Requests made with
+// an API token are further limited to the scopes of the token.
+func (h *Handler) Can(r *http.Request, u *user.User, permission string) bool {
+	if t, ok := apitoken.FromContext(r.Context()); ok && !t.HasScope(permission) {
+		return false
+	}
+	return u.HasPermission(permission)
+}
+
+func (h *Handler) RequirePermission(permission string) Middleware {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		u, err := h.CurrentUser(r)
+		if err != nil {
+			return err
+		}
+		if !h.Can(r, u, permission) {
+			return session.ErrUserForbidden
+		}
+		return nil
+	}
+}
+
 func Render(w http.ResponseWriter, r *http.Request, c templ.Component) error {
 	return c.Render(r.Context(), w)
 }
@@ -106,3 +305,11 @@ func HxRedirect(w http.ResponseWriter, r *http.Request, url string) error {
 	http.Redirect(w, r, url, http.StatusSeeOther)
 	return nil
 }
+
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"app/internal/auth/oidc"
+	"app/internal/view/component"
+	"app/internal/view/page"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const oidcStateCookie = "oidc_state"
+
+func (h *Handler) loginProviders() []component.LoginProvider {
+	if h.oidc == nil {
+		return nil
+	}
+	var providers []component.LoginProvider
+	for _, p := range h.oidc.Providers() {
+		providers = append(providers, component.LoginProvider{
+			Name:  p.Name,
+			Label: p.DisplayName,
+		})
+	}
+	return providers
+}
+
+func (h *Handler) handleOIDCLogin(w http.ResponseWriter, r *http.Request) error {
+	state, url, err := h.oidc.AuthCodeURL(r.Context(), chi.URLParam(r, "provider"))
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth",
+		MaxAge:   600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, url, http.StatusFound)
+	return nil
+}
+
+func (h *Handler) handleOIDCCallback(w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/auth",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	values := component.LoginFormValues{Providers: h.loginProviders()}
+	if e := r.URL.Query().Get("error"); e != "" {
+		return Render(w, r, page.Login(values, "Login was cancelled or denied by the identity provider"))
+	}
+
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		return Render(w, r, page.Login(values, "Invalid or expired login request, please try again"))
+	}
+
+	s, err := h.oidc.Complete(r.Context(), chi.URLParam(r, "provider"), state, r.URL.Query().Get("code"))
+	if err != nil {
+		if errors.Is(err, oidc.ErrEmailNotVerified) || errors.Is(err, oidc.ErrAccountDisabled) || errors.Is(err, oidc.ErrInvalidState) {
+			return Render(w, r, page.Login(values, err.Error()))
+		}
+		return err
+	}
+	h.session.SetCookie(w, s.Id)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"app/internal/openapi"
+	"app/internal/user"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const apiPrefix = "/api/v1"
+
+// apiRoutes documents the JSON API. Keep it in sync with the routes
+// registered in NewHttpHandler, drift is reported on startup and fails
+// TestOpenAPIDrift.
+func apiRoutes() []openapi.Route {
+	list := user.ListRequest{}
+	routes := []openapi.Route{
+		{Method: http.MethodGet, Path: "/users", Summary: "List users", Tags: []string{"users"}, Query: list, Response: user.ListUserResponse{}},
+		{Method: http.MethodPost, Path: "/users", Summary: "Create a user", Tags: []string{"users"}, Body: apiCreateUserRequest{}, Response: user.User{}, Status: http.StatusCreated},
+		{Method: http.MethodGet, Path: "/users/{id}", Summary: "Get a user", Tags: []string{"users"}, Response: user.User{}},
+		{Method: http.MethodPut, Path: "/users/{id}", Summary: "Update a user", Tags: []string{"users"}, Body: user.UpdateUserRequest{}, Response: user.User{}},
+		{Method: http.MethodDelete, Path: "/users/{id}", Summary: "Delete a user", Tags: []string{"users"}, Status: http.StatusNoContent},
+		{Method: http.MethodPost, Path: "/users/{id}/restore", Summary: "Restore a deleted user", Tags: []string{"users"}, Response: user.User{}},
+		{Method: http.MethodPost, Path: "/users/{id}/erase", Summary: "Erase the personal data of a user", Tags: []string{"users"}, Body: apiEraseUserRequest{}, Status: http.StatusNoContent},
+		{Method: http.MethodGet, Path: "/roles", Summary: "List roles", Tags: []string{"roles"}, Query: list, Response: user.ListRoleResponse{}},
+		{Method: http.MethodPost, Path: "/roles", Summary: "Create a role", Tags: []string{"roles"}, Body: user.CreateRoleRequest{}, Response: user.Role{}, Status: http.StatusCreated},
+		{Method: http.MethodGet, Path: "/roles/{id}", Summary: "Get a role", Tags: []string{"roles"}, Response: user.Role{}},
+		{Method: http.MethodPut, Path: "/roles/{id}", Summary: "Update a role", Tags: []string{"roles"}, Body: user.CreateRoleRequest{}, Response: user.Role{}},
+		{Method: http.MethodDelete, Path: "/roles/{id}", Summary: "Delete a role", Tags: []string{"roles"}, Status: http.StatusNoContent},
+	}
+	for i := range routes {
+		routes[i].Path = apiPrefix + routes[i].Path
+		routes[i].Error = apiError{}
+	}
+	return routes
+}
+
+func NewOpenAPIDocument() *openapi.Document {
+	spec := openapi.New("app", "1.0.0")
+	spec.SecurityScheme("bearer", openapi.SecurityScheme{Type: "http", Scheme: "bearer"})
+	spec.SecurityScheme("session", openapi.SecurityScheme{Type: "apiKey", In: "cookie", Name: "session_id"})
+	spec.Add(apiRoutes()...)
+	return spec.Document()
+}
+
+func (h *Handler) handleOpenAPIDocument(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, h.openAPI)
+}
+
+// checkOpenAPIDrift warns about API routes missing from the document and
+// documented operations that are no longer registered.
+func (h *Handler) checkOpenAPIDrift(r chi.Routes) {
+	drift, err := openapi.Drift(h.openAPI, r, apiPrefix)
+	if err != nil {
+		slog.Error("OpenAPI", "err", err.Error())
+		return
+	}
+	for _, d := range drift {
+		slog.Warn("OpenAPI", "drift", d)
+	}
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"app/internal/openapi"
+	"app/internal/privacy"
+	"app/pkg/session"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestOpenAPIDrift(t *testing.T) {
+	router := chi.NewRouter()
+	sm := session.New(&session.Options{Lifetime: time.Hour, SecretKey: []byte("test")})
+	// Optional routes are registered when their service is set.
+	h := NewHttpHandler(router, nil, sm, Options{Privacy: &privacy.Service{}}).(*Handler)
+	drift, err := openapi.Drift(h.openAPI, router, apiPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range drift {
+		t.Error(d)
+	}
+	if len(drift) > 0 {
+		t.Fatal("the OpenAPI document does not match the routes, update apiRoutes")
+	}
+}
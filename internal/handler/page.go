@@ -16,7 +16,7 @@ func (h *Handler) LoginPage(w http.ResponseWriter, r *http.Request) error {
 	if h.session.IsAuthenticated(r.Context()) {
 		return HxRedirect(w, r, "/dashboard")
 	}
-	return Render(w, r, page.Login(component.LoginFormValues{}, ""))
+	return Render(w, r, page.Login(component.LoginFormValues{Providers: h.loginProviders()}, ""))
 }
 
 func (h *Handler) DashboardPage(w http.ResponseWriter, r *http.Request) error {
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"app/internal/user"
+	component_user "app/internal/view/component/user"
+	"bytes"
+	"net/http"
+	"time"
+)
+
+// eraseLoginWindow is how recent a login through a provider must be to erase
+// an account without the password, which users created by a provider never
+// receive.
+const eraseLoginWindow = 10 * time.Minute
+
+func (h *Handler) handleExportDataRequest(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	// Build the archive first so that a failure still gets an error page.
+	var buf bytes.Buffer
+	if err := h.privacy.Export(r.Context(), &buf, u); err != nil {
+		return err
+	}
+	name := "my-data-" + time.Now().UTC().Format(time.DateOnly) + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// handleEraseAccountRequest lets users erase their own account. The account
+// is anonymized rather than deleted so that references to it stay valid.
+func (h *Handler) handleEraseAccountRequest(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	if !h.user.CheckPassword(u, r.Form.Get("current_password")) {
+		recent, err := h.recentProviderLogin(r, u)
+		if err != nil {
+			return err
+		}
+		if !recent {
+			return Render(w, r, component_user.DataForm("Current password is incorrect"))
+		}
+	}
+	if err := h.privacy.Erase(r.Context(), u, user.EraseAnonymize); err != nil {
+		return err
+	}
+	if err := h.session.Forget(w, r); err != nil {
+		return err
+	}
+	if s, err := h.session.GetSession(r.Context()); err == nil {
+		if err := h.session.Destroy(r.Context(), s); err != nil {
+			return err
+		}
+	}
+	return HxRedirect(w, r, "/")
+}
+
+// recentProviderLogin reports whether u is linked to a provider and the
+// current session comes from a login less than eraseLoginWindow ago.
+func (h *Handler) recentProviderLogin(r *http.Request, u *user.User) (bool, error) {
+	if h.oidc == nil {
+		return false, nil
+	}
+	s, err := h.session.GetSession(r.Context())
+	if err != nil || !s.AuthenticatedWithin(eraseLoginWindow) {
+		return false, nil
+	}
+	return h.oidc.Linked(r.Context(), u.Id)
+}
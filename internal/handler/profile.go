@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"app/internal/blob"
+	"app/internal/user"
+	component_user "app/internal/view/component/user"
+	"app/internal/view/page"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) ProfilePage(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	return Render(w, r, page.Profile(u, h.privacy != nil))
+}
+
+func (h *Handler) handleUpdateProfileRequest(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	values := component_user.ProfileFormValues{
+		Name: r.Form.Get("name"),
+	}
+	errors, err := h.user.UpdateProfile(r.Context(), u, &user.UpdateProfileRequest{Name: values.Name})
+	if err != nil && errors == nil {
+		return err
+	}
+	if errors != nil {
+		return Render(w, r, component_user.ProfileForm(values, component_user.ProfileFormErrors{
+			Name: errors["name"],
+		}, ""))
+	}
+	values.Name = u.Name
+	return Render(w, r, component_user.ProfileForm(values, component_user.ProfileFormErrors{}, "Profile updated"))
+}
+
+func (h *Handler) handleChangeEmailRequest(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	email := r.Form.Get("email")
+	errors, err := h.user.RequestEmailChange(r.Context(), u, &user.ChangeEmailRequest{
+		Email:           email,
+		CurrentPassword: r.Form.Get("current_password"),
+	})
+	if err != nil && errors == nil {
+		return err
+	}
+	if errors != nil {
+		return Render(w, r, component_user.EmailForm(email, component_user.EmailFormErrors{
+			Email:           errors["email"],
+			CurrentPassword: errors["current_password"],
+		}, ""))
+	}
+	return Render(w, r, component_user.EmailForm(u.Email, component_user.EmailFormErrors{}, "Check "+email+" for a link to confirm the change"))
+}
+
+// handleConfirmEmailPage asks to confirm an email change from the link sent
+// to the new address. Nothing is changed on GET, link scanners of mail
+// providers follow links too.
+func (h *Handler) handleConfirmEmailPage(w http.ResponseWriter, r *http.Request) error {
+	return Render(w, r, page.ConfirmEmail(r.URL.Query().Get("token")))
+}
+
+// handleConfirmEmailRequest applies an email change confirmed from
+// handleConfirmEmailPage. The link may be opened in any browser, so it does
+// not require a session; every session of the user but the current one is
+// ended.
+func (h *Handler) handleConfirmEmailRequest(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	current := ""
+	if s, err := h.session.GetSession(r.Context()); err == nil {
+		current = s.Id
+	}
+	u, err := h.user.ConfirmEmailChange(r.Context(), r.PostForm.Get("token"), current)
+	if errors.Is(err, user.ErrEmailChangeNotFound) || errors.Is(err, user.ErrEmailInUse) {
+		return Render(w, r, page.Notice("Email change", err.Error(), false))
+	}
+	if err != nil {
+		return err
+	}
+	return Render(w, r, page.Notice("Email change", "Your email address is now "+u.Email, true))
+}
+
+func (h *Handler) handleChangePasswordRequest(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	errors, err := h.user.ChangePassword(r.Context(), u, &user.ChangePasswordRequest{
+		CurrentPassword: r.Form.Get("current_password"),
+		Password:        r.Form.Get("password"),
+		PasswordCheck:   r.Form.Get("password_check"),
+	})
+	if err != nil && errors == nil {
+		return err
+	}
+	if errors != nil {
+		return Render(w, r, component_user.PasswordForm(component_user.PasswordFormErrors{
+			CurrentPassword: errors["current_password"],
+			Password:        errors["password"],
+			PasswordCheck:   errors["password_check"],
+		}, ""))
+	}
+	return Render(w, r, component_user.PasswordForm(component_user.PasswordFormErrors{}, "Password changed"))
+}
+
+func (h *Handler) handleUploadAvatarRequest(w http.ResponseWriter, r *http.Request) error {
+	u, err := h.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	// Leave room for the multipart framing around the file.
+	r.Body = http.MaxBytesReader(w, r.Body, user.AvatarMaxBytes+1<<20)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return Render(w, r, component_user.AvatarForm(u, "Image is too large"))
+		}
+		return Render(w, r, component_user.AvatarForm(u, "Choose an image to upload"))
+	}
+	defer file.Close()
+
+	errs, err := h.user.UpdateAvatar(r.Context(), u, file)
+	if err != nil && errs == nil {
+		return err
+	}
+	if errs != nil {
+		return Render(w, r, component_user.AvatarForm(u, errs["avatar"]))
+	}
+	return HxRedirect(w, r, "/profile")
+}
+
+// handleBlobRequest serves uploaded files. Keys are never reused, so they can
+// be cached indefinitely.
+func (h *Handler) handleBlobRequest(w http.ResponseWriter, r *http.Request) error {
+	key := chi.URLParam(r, "*")
+	if !user.IsAvatarKey(key) {
+		http.NotFound(w, r)
+		return nil
+	}
+	rc, contentType, err := h.blobs.Get(key)
+	if errors.Is(err, blob.ErrBlobNotFound) || errors.Is(err, blob.ErrInvalidKey) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	_, err = io.Copy(w, rc)
+	return err
+}
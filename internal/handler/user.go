@@ -4,7 +4,9 @@ import (
 	"app/internal/user"
 	"app/internal/view/component"
 	component_user "app/internal/view/component/user"
+	"app/internal/view/page"
 	"net/http"
+	"strconv"
 )
 
 func (h *Handler) handleCreateUserRequest(w http.ResponseWriter, r *http.Request) error {
@@ -24,11 +26,11 @@ func (h *Handler) handleCreateUserRequest(w http.ResponseWriter, r *http.Request
 		PasswordCheck: passwordCheck,
 	}
 
-	user, errors, err := h.user.StoreUser(req)
+	errors, err := h.user.SignUp(r.Context(), req)
 	if err != nil && errors == nil {
 		return Render(w, r, component.Error(err.Error()))
 	}
-	if user == nil {
+	if errors != nil {
 		return Render(w, r, component_user.CreateUserForm(
 			component_user.CreateUserFormValues{
 				Email: email,
@@ -46,3 +48,20 @@ func (h *Handler) handleCreateUserRequest(w http.ResponseWriter, r *http.Request
 	}
 	return HxRedirect(w, r, "/login")
 }
+
+// usersPageSize is how many users the admin list shows per page.
+const usersPageSize = 20
+
+func (h *Handler) UsersPage(w http.ResponseWriter, r *http.Request) error {
+	search := r.URL.Query().Get("search")
+	pageNumber, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	res, _, err := h.user.ListUsers(r.Context(), user.ListRequest{
+		Page:     pageNumber,
+		PageSize: usersPageSize,
+		Search:   search,
+	})
+	if err != nil {
+		return err
+	}
+	return Render(w, r, page.Users(search, res))
+}
@@ -0,0 +1,48 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Drift compares the documented operations with the routes registered on the
+// router under prefix and describes every mismatch. An empty result means the
+// document matches the router.
+func Drift(doc *Document, routes chi.Routes, prefix string) ([]string, error) {
+	registered := make(map[string]bool)
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		route = strings.TrimSuffix(strings.ReplaceAll(route, "/*/", "/"), "/*")
+		if strings.HasPrefix(route, prefix) && method != http.MethodHead && method != http.MethodOptions {
+			registered[method+" "+route] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	var drift []string
+	for route := range registered {
+		if !documented[route] {
+			drift = append(drift, fmt.Sprintf("%s is registered but not documented", route))
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			drift = append(drift, fmt.Sprintf("%s is documented but not registered", route))
+		}
+	}
+	sort.Strings(drift)
+	return drift, nil
+}
@@ -0,0 +1,199 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const Version = "3.1.0"
+
+// Document is the subset of an OpenAPI 3.1 document the application needs.
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem maps lower case HTTP methods to their operation.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationId string              `json:"operationId,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// Route describes one API endpoint. Query, Body and Response are sample values
+// whose types are reflected into schemas; nil means there is none.
+type Route struct {
+	Method   string
+	Path     string
+	Summary  string
+	Tags     []string
+	Query    any
+	Body     any
+	Response any
+	Status   int
+	// Error is the schema of every non successful response.
+	Error any
+}
+
+// Spec builds a Document from route descriptions.
+type Spec struct {
+	doc     *Document
+	schemas *schemaRegistry
+}
+
+func New(title, version string) *Spec {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Version: version},
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+		},
+	}
+	return &Spec{
+		doc:     doc,
+		schemas: &schemaRegistry{components: doc.Components.Schemas},
+	}
+}
+
+// SecurityScheme declares a scheme and requires it for every operation.
+func (s *Spec) SecurityScheme(name string, scheme SecurityScheme) {
+	if s.doc.Components.SecuritySchemes == nil {
+		s.doc.Components.SecuritySchemes = make(map[string]SecurityScheme)
+	}
+	s.doc.Components.SecuritySchemes[name] = scheme
+	s.doc.Security = append(s.doc.Security, map[string][]string{name: {}})
+}
+
+func (s *Spec) Add(routes ...Route) {
+	for _, route := range routes {
+		item, ok := s.doc.Paths[route.Path]
+		if !ok {
+			item = make(PathItem)
+			s.doc.Paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = s.operation(route)
+	}
+}
+
+func (s *Spec) Document() *Document {
+	return s.doc
+}
+
+func (s *Spec) operation(route Route) *Operation {
+	op := &Operation{
+		OperationId: operationId(route.Method, route.Path),
+		Summary:     route.Summary,
+		Tags:        route.Tags,
+		Responses:   make(map[string]Response),
+	}
+	for _, name := range pathParams(route.Path) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	if route.Query != nil {
+		op.Parameters = append(op.Parameters, s.schemas.queryParams(reflect.TypeOf(route.Query))...)
+	}
+	if route.Body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  jsonContent(s.schemas.of(reflect.TypeOf(route.Body))),
+		}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	res := Response{Description: http.StatusText(status)}
+	if route.Response != nil {
+		res.Content = jsonContent(s.schemas.of(reflect.TypeOf(route.Response)))
+	}
+	op.Responses[strconv.Itoa(status)] = res
+	if route.Error != nil {
+		op.Responses["default"] = Response{
+			Description: "Error",
+			Content:     jsonContent(s.schemas.of(reflect.TypeOf(route.Error))),
+		}
+	}
+	return op
+}
+
+func jsonContent(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: schema}}
+}
+
+func operationId(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.Split(path, "/") {
+		part = strings.Trim(part, "{}")
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func pathParams(path string) []string {
+	var params []string
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			params = append(params, strings.Trim(part, "{}"))
+		}
+	}
+	return params
+}
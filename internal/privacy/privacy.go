@@ -0,0 +1,190 @@
+package privacy
+
+import (
+	"app/internal/audit"
+	"app/internal/auth/apitoken"
+	"app/internal/auth/oidc"
+	"app/internal/core"
+	"app/internal/user"
+	"app/pkg/session"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+type Options struct {
+	Users    *user.UserService
+	Sessions *session.Manager
+	// The services below are optional, their data is skipped when nil.
+	APITokens  *apitoken.Service
+	Identities oidc.IdentityRepository
+	Audit      *audit.Service
+	// Tx makes Erase atomic, when nil its steps run one after the other.
+	Tx *core.TxManager
+}
+
+// Service exports and erases everything the application keeps about a user.
+type Service struct {
+	users      *user.UserService
+	sessions   *session.Manager
+	apiTokens  *apitoken.Service
+	identities oidc.IdentityRepository
+	audit      *audit.Service
+	tx         *core.TxManager
+}
+
+func New(opts *Options) *Service {
+	return &Service{
+		users:      opts.Users,
+		sessions:   opts.Sessions,
+		apiTokens:  opts.APITokens,
+		identities: opts.Identities,
+		audit:      opts.Audit,
+		tx:         opts.Tx,
+	}
+}
+
+// exportedSession leaves out the session id, which is a credential.
+type exportedSession struct {
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type exportedIdentity struct {
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// Export writes a zip archive with one JSON file per kind of data.
+func (s *Service) Export(ctx context.Context, w io.Writer, u *user.User) error {
+	files := map[string]any{
+		"profile.json": u,
+		"roles.json":   u.Roles,
+	}
+
+	sessions, err := s.sessions.UserSessions(ctx, u.Id)
+	if err != nil {
+		return err
+	}
+	exported := []exportedSession{}
+	for _, session := range sessions {
+		exported = append(exported, exportedSession{session.CreatedAt, session.ExpiresAt})
+	}
+	files["sessions.json"] = exported
+
+	if s.apiTokens != nil {
+		tokens, err := s.apiTokens.List(ctx, u.Id)
+		if err != nil {
+			return err
+		}
+		files["api_tokens.json"] = tokens
+	}
+	if s.identities != nil {
+		identities, err := s.identities.FindByUser(ctx, u.Id)
+		if err != nil {
+			return err
+		}
+		exported := []exportedIdentity{}
+		for _, i := range identities {
+			exported = append(exported, exportedIdentity{i.Provider, i.Subject, i.Email, i.CreatedAt, i.LastLoginAt})
+		}
+		files["identities.json"] = exported
+	}
+	if s.audit != nil {
+		events, err := s.userEvents(ctx, u.Id)
+		if err != nil {
+			return err
+		}
+		files["audit_events.json"] = events
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:     audit.ActionDataExported,
+		TargetType: audit.TargetUser,
+		TargetId:   u.Id,
+	})
+	return nil
+}
+
+// userEvents returns the events the user made or was the target of.
+func (s *Service) userEvents(ctx context.Context, userId string) ([]audit.Event, error) {
+	byActor, err := s.audit.List(ctx, audit.Filter{ActorId: userId})
+	if err != nil {
+		return nil, err
+	}
+	byTarget, err := s.audit.List(ctx, audit.Filter{TargetType: audit.TargetUser, TargetId: userId})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	events := []audit.Event{}
+	for _, e := range append(byActor, byTarget...) {
+		if !seen[e.Id] {
+			seen[e.Id] = true
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].OccurredAt.Before(events[j].OccurredAt)
+	})
+	return events, nil
+}
+
+// Erase logs the user out everywhere, unlinks external identities, revokes
+// API tokens and then anonymizes or deletes the account, all in one
+// transaction: a failure leaves the account as it was.
+func (s *Service) Erase(ctx context.Context, u *user.User, mode user.EraseMode) error {
+	if !mode.Valid() {
+		return user.ErrInvalidRequest
+	}
+	if s.tx == nil {
+		return s.erase(ctx, u, mode)
+	}
+	return s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		return s.erase(ctx, u, mode)
+	})
+}
+
+func (s *Service) erase(ctx context.Context, u *user.User, mode user.EraseMode) error {
+	if err := s.sessions.DestroyOtherSessions(ctx, u.Id, ""); err != nil {
+		return err
+	}
+	if s.identities != nil {
+		if err := s.identities.DeleteByUser(ctx, u.Id); err != nil {
+			return err
+		}
+	}
+	if s.apiTokens != nil {
+		if err := s.apiTokens.RevokeAll(ctx, u.Id); err != nil {
+			return err
+		}
+	}
+	return s.users.Erase(ctx, u, mode)
+}
@@ -0,0 +1,187 @@
+// Package seed creates the data a fresh database needs, default roles and an
+// administrator, and a demo dataset. Everything goes through the services
+// and is skipped when it already exists, so seeding can be run again and
+// used to set up fixtures.
+package seed
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"app/internal/core"
+	"app/internal/user"
+	"app/pkg/session"
+)
+
+const (
+	RoleAdmin   = "admin"
+	RoleManager = "manager"
+	RoleAuditor = "auditor"
+)
+
+// DefaultRoles are created by Roles. Roles edited since are left as they are.
+var DefaultRoles = []user.CreateRoleRequest{
+	{
+		Name:        RoleAdmin,
+		Description: "Full access",
+		Permissions: []string{user.PermissionAll},
+	},
+	{
+		Name:        RoleManager,
+		Description: "Manages users",
+		Permissions: []string{user.PermissionUsersRead, user.PermissionUsersManage, user.PermissionRolesRead},
+	},
+	{
+		Name:        RoleAuditor,
+		Description: "Reads users and the audit log",
+		Permissions: []string{user.PermissionUsersRead, user.PermissionRolesRead, user.PermissionAuditRead},
+	},
+}
+
+// Roles creates the missing default roles and returns all of them by name.
+func Roles(ctx context.Context, us *user.UserService) (map[string]user.Role, error) {
+	roles := make(map[string]user.Role, len(DefaultRoles))
+	for _, req := range DefaultRoles {
+		role, err := us.FindRoleByName(ctx, req.Name)
+		if errors.Is(err, user.ErrRoleNotFound) {
+			req.Permissions = append([]string{}, req.Permissions...)
+			var errs map[string]string
+			role, errs, err = us.StoreRole(ctx, &req)
+			if err != nil {
+				return nil, fmt.Errorf("role %s: %w", req.Name, core.WithFields(err, errs))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		roles[role.Name] = *role
+	}
+	return roles, nil
+}
+
+type AdminRequest struct {
+	Name     string
+	Email    string
+	Password string
+}
+
+// Admin creates a user with the admin role, creating the default roles if
+// needed. It reports false with the existing user when the email is taken.
+func Admin(ctx context.Context, us *user.UserService, req AdminRequest) (*user.User, bool, error) {
+	existing, err := us.FindByEmail(ctx, req.Email)
+	if err == nil {
+		return existing, false, nil
+	}
+	if !errors.Is(err, user.ErrUserNotFound) {
+		return nil, false, err
+	}
+	roles, err := Roles(ctx, us)
+	if err != nil {
+		return nil, false, err
+	}
+	u, errs, err := us.StoreUser(ctx, &user.CreateUserRequest{
+		Name:          req.Name,
+		Email:         req.Email,
+		Password:      req.Password,
+		PasswordCheck: req.Password,
+		Roles:         []user.Role{roles[RoleAdmin]},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("admin: %w", core.WithFields(err, errs))
+	}
+	return u, true, nil
+}
+
+type DemoOptions struct {
+	Users int
+	// Password is given to every demo user, it must pass the password policy.
+	Password string
+	// SessionsPerUser sessions are stored for every new demo user when
+	// Sessions is set.
+	SessionsPerUser int
+	Sessions        session.SessionRepository
+	// SessionLifetime defaults to a day.
+	SessionLifetime time.Duration
+}
+
+var (
+	demoFirstNames = []string{"Ada", "Alan", "Grace", "Edsger", "Barbara", "Donald", "Frances", "Ken", "Margaret", "Niklaus"}
+	demoLastNames  = []string{"Lovelace", "Turing", "Hopper", "Dijkstra", "Liskov", "Knuth", "Allen", "Thompson", "Hamilton", "Wirth"}
+)
+
+// DemoEmail is the address of the i-th demo user, starting at zero.
+func DemoEmail(i int) string {
+	return fmt.Sprintf("demo-%04d@example.com", i)
+}
+
+// Demo creates demo users named after computing pioneers, one in four a
+// manager and one in four an auditor, and returns the users it created.
+// Users already seeded are skipped, so the dataset can be grown.
+func Demo(ctx context.Context, us *user.UserService, opts DemoOptions) ([]user.User, error) {
+	roles, err := Roles(ctx, us)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SessionLifetime == 0 {
+		opts.SessionLifetime = 24 * time.Hour
+	}
+	var created []user.User
+	for i := 0; i < opts.Users; i++ {
+		email := DemoEmail(i)
+		if _, err := us.FindByEmail(ctx, email); err == nil {
+			continue
+		} else if !errors.Is(err, user.ErrUserNotFound) {
+			return created, err
+		}
+		var userRoles []user.Role
+		switch i % 4 {
+		case 1:
+			userRoles = []user.Role{roles[RoleManager]}
+		case 2:
+			userRoles = []user.Role{roles[RoleAuditor]}
+		}
+		n := len(demoFirstNames)
+		name := demoFirstNames[i%n] + " " + demoLastNames[(i+i/n)%n]
+		u, errs, err := us.StoreUser(ctx, &user.CreateUserRequest{
+			Name:          name,
+			Email:         email,
+			Password:      opts.Password,
+			PasswordCheck: opts.Password,
+			Roles:         userRoles,
+		})
+		if err != nil {
+			return created, fmt.Errorf("demo user %s: %w", email, core.WithFields(err, errs))
+		}
+		created = append(created, *u)
+		if opts.Sessions == nil {
+			continue
+		}
+		for j := 0; j < opts.SessionsPerUser; j++ {
+			if err := demoSession(ctx, opts.Sessions, u.Id, time.Duration(j)*time.Hour, opts.SessionLifetime); err != nil {
+				return created, err
+			}
+		}
+	}
+	return created, nil
+}
+
+// demoSession stores a session of the user created age ago. Its id is not
+// signed, it is only listed, nobody can log in with it.
+func demoSession(ctx context.Context, sessions session.SessionRepository, userId string, age, lifetime time.Duration) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	created := time.Now().UTC().Add(-age)
+	return sessions.Set(ctx, &session.Session{
+		Id:        base64.URLEncoding.EncodeToString(b),
+		UserId:    userId,
+		Data:      make(map[string]any),
+		CreatedAt: created,
+		ExpiresAt: created.Add(lifetime),
+	})
+}
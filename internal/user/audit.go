@@ -0,0 +1,47 @@
+package user
+
+import "app/internal/audit"
+
+// auditFields are the fields of a user compared in audit diffs. The password
+// and email are left out, their changes are recorded as actions of their own.
+func (u *User) auditFields() map[string]any {
+	roles := make([]string, 0, len(u.Roles))
+	for _, role := range u.Roles {
+		roles = append(roles, role.Name)
+	}
+	return map[string]any{
+		"name":   u.Name,
+		"avatar": u.Avatar,
+		"status": string(u.Status),
+		"roles":  roles,
+	}
+}
+
+func (r *Role) auditFields() map[string]any {
+	return map[string]any{
+		"name":        r.Name,
+		"description": r.Description,
+		"permissions": r.Permissions,
+	}
+}
+
+// userEntry describes an action on u. Its diff holds personal data, sealed
+// until the user is erased.
+func userEntry(action string, u *User, diff map[string]audit.Change) audit.Entry {
+	return audit.Entry{
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetId:   u.Id,
+		Diff:       diff,
+		Personal:   true,
+	}
+}
+
+func roleEntry(action string, r *Role, diff map[string]audit.Change) audit.Entry {
+	return audit.Entry{
+		Action:     action,
+		TargetType: audit.TargetRole,
+		TargetId:   r.Id,
+		Diff:       diff,
+	}
+}
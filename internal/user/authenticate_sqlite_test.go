@@ -0,0 +1,182 @@
+//go:build sqlite_fts5
+
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"app/internal/core"
+	"app/internal/db/dbtest"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useFastHasher hashes with argon2id, bcrypt hashes of earlier versions still
+// being verified, at the lowest cost, until the test ends.
+func useFastHasher(t *testing.T) *core.PasswordHasher {
+	hasher := core.DefaultPasswordHasher
+	core.DefaultPasswordHasher = &core.PasswordHasher{
+		Algorithm:  core.AlgorithmArgon2id,
+		BcryptCost: bcrypt.MinCost,
+		Argon2:     core.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	}
+	t.Cleanup(func() { core.DefaultPasswordHasher = hasher })
+	return core.DefaultPasswordHasher
+}
+
+// TestAuthenticatePathsAreEquivalent checks that every login, whatever makes
+// it fail, compares the password against one hash of each algorithm and that
+// the failures cannot be told apart.
+func TestAuthenticatePathsAreEquivalent(t *testing.T) {
+	hasher := useFastHasher(t)
+	ctx := context.Background()
+	r := NewUserRepositorySqlite(dbtest.Sqlite(t))
+	s := NewUserService(r, &Options{})
+	const password = "Qw7!zNb4vYc1"
+
+	now := time.Now().UTC()
+	store := func(email, algorithm string, status UserStatus) *User {
+		hash, err := hasher.HashWith(algorithm, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := &User{Id: core.NewID(), Name: "Jane", Email: email, Password: hash, Status: status, CreatedAt: now, UpdatedAt: now}
+		if err := r.Store(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	store("argon2@example.com", core.AlgorithmArgon2id, UserStatusActive)
+	store("bcrypt@example.com", core.AlgorithmBcrypt, UserStatusActive)
+	store("inactive@example.com", core.AlgorithmArgon2id, UserStatusInactive)
+	deleted := store("deleted@example.com", core.AlgorithmArgon2id, UserStatusActive)
+	if err := s.Delete(ctx, deleted.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	var compared []string
+	defer func(compare func(string, string) (bool, bool)) { comparePassword = compare }(comparePassword)
+	comparePassword = func(hash, password string) (bool, bool) {
+		compared = append(compared, hash)
+		return core.ComparePassword(hash, password)
+	}
+
+	dummies := dummyHashesOf(hasher)
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		ok       bool
+		// dummy is set when no account is found, every hash compared
+		// being a dummy one.
+		dummy bool
+	}{
+		{"unknown email", "nobody@example.com", password, false, true},
+		{"deleted account", "deleted@example.com", password, false, true},
+		{"inactive account", "inactive@example.com", password, false, false},
+		{"wrong password", "argon2@example.com", "wrong password", false, false},
+		{"wrong password of a bcrypt hash", "bcrypt@example.com", "wrong password", false, false},
+		{"good password", "argon2@example.com", password, true, false},
+		{"good password of a bcrypt hash", "bcrypt@example.com", password, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compared = nil
+			u, err := s.Authenticate(ctx, tt.email, tt.password)
+			if tt.ok && err != nil {
+				t.Fatalf("Authenticate = %v, want a user", err)
+			}
+			if !tt.ok && (u != nil || !errors.Is(err, ErrInvalidEmailOrPassword)) {
+				t.Fatalf("Authenticate = %v, %v, want ErrInvalidEmailOrPassword", u, err)
+			}
+
+			var algorithms []string
+			for _, hash := range compared {
+				algorithms = append(algorithms, core.HashAlgorithm(hash))
+			}
+			slices.Sort(algorithms)
+			if want := []string{core.AlgorithmArgon2id, core.AlgorithmBcrypt}; !slices.Equal(algorithms, want) {
+				t.Errorf("compared hashes of %v, want one of each of %v", algorithms, want)
+			}
+			var fake int
+			for _, hash := range compared {
+				if dummies[core.HashAlgorithm(hash)] == hash {
+					fake++
+				}
+			}
+			want := len(compared) - 1
+			if tt.dummy {
+				want = len(compared)
+			}
+			if fake != want {
+				t.Errorf("compared %d dummy hashes, want %d", fake, want)
+			}
+		})
+	}
+}
+
+// TestAuthenticateRehashes checks that a successful login, and only that,
+// upgrades a hash made with another algorithm or other parameters.
+func TestAuthenticateRehashes(t *testing.T) {
+	hasher := useFastHasher(t)
+	ctx := context.Background()
+	r := NewUserRepositorySqlite(dbtest.Sqlite(t))
+	s := NewUserService(r, &Options{})
+	const password = "Qw7!zNb4vYc1"
+
+	older := *hasher
+	older.Argon2.Iterations = 2
+	tests := []struct {
+		name   string
+		hasher *core.PasswordHasher
+		rehash bool
+	}{
+		{"bcrypt", &core.PasswordHasher{Algorithm: core.AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}, true},
+		{"older argon2id parameters", &older, true},
+		{"current parameters", hasher, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hasher.Hash(password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now().UTC()
+			u := &User{Id: core.NewID(), Name: "Jane", Email: fmt.Sprintf("jane%d@example.com", i), Password: hash, Status: UserStatusActive, CreatedAt: now, UpdatedAt: now}
+			if err := r.Store(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+			stored := func() string {
+				t.Helper()
+				u, err := r.Find(ctx, u.Id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return u.Password
+			}
+
+			if _, err := s.Authenticate(ctx, u.Email, "wrong password"); err == nil {
+				t.Fatal("Authenticate accepted a wrong password")
+			}
+			if stored() != hash {
+				t.Error("a failed login replaced the hash")
+			}
+
+			if _, err := s.Authenticate(ctx, u.Email, password); err != nil {
+				t.Fatal(err)
+			}
+			got := stored()
+			if (got != hash) != tt.rehash {
+				t.Errorf("hash replaced = %v, want %v", got != hash, tt.rehash)
+			}
+			if valid, rehash := core.ComparePassword(got, password); !valid || rehash {
+				t.Errorf("Compare of the stored hash = %v, %v, want true, false", valid, rehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package user
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	AvatarMaxBytes      = 5 << 20
+	AvatarMaxDimension  = 4096
+	AvatarSize          = 256
+	AvatarThumbnailSize = 64
+)
+
+// AvatarURLPrefix is the path blobs are served under.
+const AvatarURLPrefix = "/uploads/"
+
+const avatarKeyPrefix = "avatars/"
+
+// avatarKeyPattern matches the keys avatarKeys builds: a user id and an upload
+// id, both ULIDs, for the avatar or its thumbnail.
+var avatarKeyPattern = regexp.MustCompile(`^avatars/[0-9A-Z]{26}/[0-9A-Z]{26}(_thumb)?\.png$`)
+
+var avatarContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// decodeAvatar reads an uploaded image, checking its size, its actual content
+// type and its dimensions before decoding it.
+func decodeAvatar(r io.Reader) (image.Image, string) {
+	data, err := io.ReadAll(io.LimitReader(r, AvatarMaxBytes+1))
+	if err != nil {
+		return nil, "Could not read the image"
+	}
+	if len(data) > AvatarMaxBytes {
+		return nil, fmt.Sprintf("Image must be at most %d MB", AvatarMaxBytes>>20)
+	}
+	if !avatarContentTypes[http.DetectContentType(data)] {
+		return nil, "Image must be a PNG, JPEG or GIF"
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "Image is corrupted"
+	}
+	if cfg.Width > AvatarMaxDimension || cfg.Height > AvatarMaxDimension {
+		return nil, fmt.Sprintf("Image must be at most %dx%d pixels", AvatarMaxDimension, AvatarMaxDimension)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "Image is corrupted"
+	}
+	return img, ""
+}
+
+// resizeSquare crops the centre square of src and scales it to size pixels,
+// averaging every source pixel that falls into a destination pixel.
+func resizeSquare(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for dy := 0; dy < size; dy++ {
+		sy0 := y0 + dy*side/size
+		sy1 := max(y0+(dy+1)*side/size, sy0+1)
+		for dx := 0; dx < size; dx++ {
+			sx0 := x0 + dx*side/size
+			sx1 := max(x0+(dx+1)*side/size, sx0+1)
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					c := color.RGBA64Model.Convert(src.At(sx, sy)).(color.RGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					bl += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+func encodePNG(img image.Image) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func avatarKeys(userId, id string) (string, string) {
+	base := avatarKeyPrefix + userId + "/" + id
+	return base + ".png", base + "_thumb.png"
+}
+
+// IsAvatarKey reports whether key names an uploaded avatar, so that only those
+// are served from the blob store.
+func IsAvatarKey(key string) bool {
+	return avatarKeyPattern.MatchString(key)
+}
+
+// avatarKeysFromURL returns the blob keys behind an avatar URL, and false when
+// the avatar was not uploaded by the user, for example when it comes from a
+// provider. Keys of other users are refused, so they are never deleted.
+func avatarKeysFromURL(userId, url string) (string, string, bool) {
+	key, ok := strings.CutPrefix(url, AvatarURLPrefix)
+	if !ok || !strings.HasPrefix(key, avatarKeyPrefix+userId+"/") || !IsAvatarKey(key) || strings.HasSuffix(key, "_thumb.png") {
+		return "", "", false
+	}
+	return key, strings.TrimSuffix(key, ".png") + "_thumb.png", true
+}
+
+// AvatarThumbnail returns the small version of the avatar.
+func (u *User) AvatarThumbnail() string {
+	if _, thumb, ok := avatarKeysFromURL(u.Id, u.Avatar); ok {
+		return AvatarURLPrefix + thumb
+	}
+	return u.Avatar
+}
+
+// Initials returns up to two letters identifying the user when there is no
+// avatar to show.
+func (u *User) Initials() string {
+	var initials []rune
+	for _, word := range strings.Fields(u.Name) {
+		initials = append(initials, []rune(strings.ToUpper(word))[0])
+		if len(initials) == 2 {
+			break
+		}
+	}
+	if len(initials) == 1 {
+		if name := []rune(strings.ToUpper(u.Name)); len(name) > 1 {
+			initials = name[:2]
+		}
+	}
+	return string(initials)
+}
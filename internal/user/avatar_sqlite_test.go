@@ -0,0 +1,86 @@
+//go:build sqlite_fts5
+
+package user_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"app/internal/blob"
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/internal/user"
+)
+
+func uploadAvatar(t *testing.T, s *user.UserService, u *user.User) {
+	t.Helper()
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 32, 32))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateAvatar(context.Background(), u, &img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAvatarOfAnotherUserIsNeverDeleted points the avatar of a user at the
+// upload of another one, and checks that neither a new upload nor erasing
+// the user deletes it.
+func TestAvatarOfAnotherUserIsNeverDeleted(t *testing.T) {
+	ctx := context.Background()
+	r := user.NewUserRepositorySqlite(dbtest.Sqlite(t))
+	store := blob.NewLocalStore(t.TempDir())
+	s := user.NewUserService(r, &user.Options{Avatars: store})
+	now := time.Now().UTC()
+	var users []*user.User
+	for _, name := range []string{"Ada", "Alan"} {
+		u := &user.User{Id: core.NewID(), Name: name, Email: strings.ToLower(name) + "@example.com", Password: "hash", Status: user.UserStatusActive, CreatedAt: now, UpdatedAt: now}
+		if err := r.Store(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	owner, other := users[0], users[1]
+	uploadAvatar(t, s, owner)
+	key := strings.TrimPrefix(owner.Avatar, user.AvatarURLPrefix)
+	thumb := strings.TrimPrefix(owner.AvatarThumbnail(), user.AvatarURLPrefix)
+
+	stored := func() {
+		t.Helper()
+		for _, k := range []string{key, thumb} {
+			rc, _, err := store.Get(k)
+			if errors.Is(err, blob.ErrBlobNotFound) {
+				t.Fatalf("%s of the owner was deleted", k)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc.Close()
+		}
+	}
+
+	other.Avatar = owner.Avatar
+	if err := s.Update(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if got := other.AvatarThumbnail(); got != owner.Avatar {
+		t.Errorf("AvatarThumbnail = %q, want the avatar itself %q", got, owner.Avatar)
+	}
+	uploadAvatar(t, s, other)
+	stored()
+
+	other.Avatar = owner.Avatar
+	if err := s.Update(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Erase(ctx, other, user.EraseDelete); err != nil {
+		t.Fatal(err)
+	}
+	stored()
+}
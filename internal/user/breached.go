@@ -0,0 +1,52 @@
+package user
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// BreachedPasswordDir checks passwords against a local copy of the Have I Been
+// Pwned range files. The directory holds one <PREFIX>.txt file per 5 character
+// SHA-1 prefix, each listing "SUFFIX:COUNT" lines, so only the file of the
+// password's prefix is ever read.
+type BreachedPasswordDir struct {
+	dir string
+}
+
+func NewBreachedPasswordDir(dir string) *BreachedPasswordDir {
+	return &BreachedPasswordDir{dir}
+}
+
+func (b *BreachedPasswordDir) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,16 @@
+package user
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the authenticated user.
+func NewContext(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, u)
+}
+
+// FromContext returns the authenticated user stored in ctx, if any.
+func FromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(contextKey{}).(*User)
+	return u, ok && u != nil
+}
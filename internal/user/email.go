@@ -0,0 +1,113 @@
+package user
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+)
+
+// FoldEmailLocalPart makes the part of addresses before the "@" case
+// insensitive. Nearly every mail provider treats it that way, although the
+// standard allows it to be case sensitive.
+var FoldEmailLocalPart = true
+
+// NormalizeEmail returns the key an address is looked up and kept unique by:
+// trimmed, with a lower case domain and, when FoldEmailLocalPart is set, a
+// lower case local part.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return strings.ToLower(email)
+	}
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+	if FoldEmailLocalPart {
+		local = strings.ToLower(local)
+	}
+	return local + "@" + domain
+}
+
+// ValidEmail reports whether email is a single bare address, such as
+// "bruno@example.com", without a display name or angle brackets.
+func ValidEmail(email string) bool {
+	if len(email) > 254 {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return false
+	}
+	_, domain, _ := strings.Cut(addr.Address, "@")
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// EmailKey is the stored address of a user next to its lookup key.
+type EmailKey struct {
+	UserId     string
+	Email      string
+	Normalized string
+}
+
+// EmailDuplicate lists accounts whose addresses normalize to the same key.
+// The first one keeps the key; the others cannot log in by email until they
+// are merged or their address is changed.
+type EmailDuplicate struct {
+	Normalized string
+	UserIds    []string
+	Emails     []string
+}
+
+// RepairEmails recomputes the lookup key of every address, for example after
+// FoldEmailLocalPart changed, and reports the addresses that collide. The
+// oldest account keeps a contested key. Nothing is written when dryRun is set.
+func (s *UserService) RepairEmails(ctx context.Context, dryRun bool) ([]EmailDuplicate, int, error) {
+	keys, err := s.repo.ListEmailKeys(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var order []string
+	groups := make(map[string][]EmailKey)
+	updates := make(map[string]string)
+	for _, k := range keys {
+		// Anonymized users have no key, nobody logs in as them.
+		if k.Email == anonymizedEmail(k.UserId) {
+			if k.Normalized != "" {
+				updates[k.UserId] = ""
+			}
+			continue
+		}
+		n := NormalizeEmail(k.Email)
+		if _, ok := groups[n]; !ok {
+			order = append(order, n)
+		}
+		groups[n] = append(groups[n], k)
+	}
+
+	var duplicates []EmailDuplicate
+	for _, n := range order {
+		group := groups[n]
+		for i, k := range group {
+			want := n
+			if i > 0 {
+				want = ""
+			}
+			if k.Normalized != want {
+				updates[k.UserId] = want
+			}
+		}
+		if len(group) > 1 {
+			d := EmailDuplicate{Normalized: n}
+			for _, k := range group {
+				d.UserIds = append(d.UserIds, k.UserId)
+				d.Emails = append(d.Emails, k.Email)
+			}
+			duplicates = append(duplicates, d)
+		}
+	}
+
+	if dryRun || len(updates) == 0 {
+		return duplicates, len(updates), nil
+	}
+	return duplicates, len(updates), s.repo.SetNormalizedEmails(ctx, updates)
+}
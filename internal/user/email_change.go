@@ -0,0 +1,206 @@
+package user
+
+import (
+	"app/internal/audit"
+	"app/internal/core"
+	"app/internal/mail"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const emailChangeLifetime = 24 * time.Hour
+
+// EmailChange is a pending change of address. It is applied once the link
+// sent to the new address is followed; only a hash of its token is stored.
+type EmailChange struct {
+	TokenHash string
+	UserId    string
+	NewEmail  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type ChangeEmailRequest struct {
+	Email           string `json:"email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+func (r *ChangeEmailRequest) Validate() map[string]string {
+	errs := make(map[string]string)
+	if r.Email == "" {
+		errs["email"] = "Email is required"
+	} else if !ValidEmail(r.Email) {
+		errs["email"] = "Email is not valid"
+	}
+	if r.CurrentPassword == "" {
+		errs["current_password"] = "Current password is required"
+	}
+	return errs
+}
+
+func hashEmailChangeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestEmailChange sends a confirmation link to the new address and a notice
+// to the current one, in the background. Like SignUp it does the same work
+// whether the new address is taken or not, its owner is told about the
+// attempt instead of receiving the link.
+func (s *UserService) RequestEmailChange(ctx context.Context, user *User, req *ChangeEmailRequest) (map[string]string, error) {
+	req.Email = strings.TrimSpace(req.Email)
+	errs := req.Validate()
+	if req.Email != "" && NormalizeEmail(req.Email) == NormalizeEmail(user.Email) {
+		errs["email"] = "This is already your email address"
+	}
+	if req.CurrentPassword != "" {
+		if ok, _ := core.ComparePassword(user.Password, req.CurrentPassword); !ok {
+			errs["current_password"] = "Current password is incorrect"
+		}
+	}
+	if len(errs) > 0 {
+		return errs, ErrInvalidRequest
+	}
+
+	s.audit.Record(ctx, userEntry(audit.ActionEmailChangeRequest, user, map[string]audit.Change{
+		"email": {From: user.Email, To: req.Email},
+	}))
+	existing, err := s.repo.FindByEmail(ctx, req.Email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+
+	// The change is stored even when the address is taken, its link is then
+	// never sent, so that both cases do the same work.
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now().UTC()
+	err = s.repo.StoreEmailChange(ctx, &EmailChange{
+		TokenHash: hashEmailChangeToken(token),
+		UserId:    user.Id,
+		NewEmail:  req.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailChangeLifetime),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Waiting for the mail server would tell a taken address apart by the
+	// response time.
+	go s.notifyEmailChangeRequested(user, req.Email)
+	if existing != nil {
+		go s.notifyEmailChangeAttempt(existing)
+	} else {
+		go s.sendEmailChangeLink(user, req.Email, token)
+	}
+	return nil, nil
+}
+
+// ConfirmEmailChange applies the change the token was issued for and returns
+// the updated user. Every other pending change of the user is discarded and,
+// in the same transaction, every session of the user but keepSessionId is
+// ended. keepSessionId may be empty or belong to another user.
+func (s *UserService) ConfirmEmailChange(ctx context.Context, token, keepSessionId string) (*User, error) {
+	change, err := s.repo.FindEmailChange(ctx, hashEmailChangeToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().UTC().After(change.ExpiresAt) {
+		if err := s.repo.DeleteEmailChanges(ctx, change.UserId); err != nil {
+			log.Println(err)
+		}
+		return nil, ErrEmailChangeNotFound
+	}
+	var user *User
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		user, err = s.repo.Find(ctx, change.UserId)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.UpdateEmail(ctx, user.Id, change.NewEmail); err != nil {
+			return err
+		}
+		err := s.audit.Record(ctx, userEntry(audit.ActionEmailChanged, user, map[string]audit.Change{
+			"email": {From: user.Email, To: change.NewEmail},
+		}))
+		if err != nil {
+			return err
+		}
+		if err := s.repo.DeleteEmailChanges(ctx, user.Id); err != nil {
+			return err
+		}
+		if s.sessions == nil {
+			return nil
+		}
+		return s.sessions.DestroyOtherSessions(ctx, user.Id, keepSessionId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.Email = change.NewEmail
+	return user, nil
+}
+
+func (s *UserService) sendEmailChangeLink(user *User, email, token string) {
+	err := s.mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nFollow this link to use this address for your account:\n\n%s/profile/email/confirm?token=%s\n\n"+
+				"The link expires in %s. If you did not ask for this change, you can ignore this message.\n",
+			user.Name,
+			s.baseURL,
+			url.QueryEscape(token),
+			emailChangeLifetime,
+		),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *UserService) notifyEmailChangeRequested(user *User, email string) {
+	err := s.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nA change of the email address of your account to %s was requested. "+
+				"It only takes effect once confirmed from the new address.\n\n"+
+				"If it was not you, change your password at %s/profile.\n",
+			user.Name,
+			email,
+			s.baseURL,
+		),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *UserService) notifyEmailChangeAttempt(user *User) {
+	err := s.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Email change attempt with your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nSomeone tried to move another account to this email address, which already belongs to your account. "+
+				"No change was made.\n\nIf it was you, you can log in at %s/login.\n",
+			user.Name,
+			s.baseURL,
+		),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,86 @@
+//go:build sqlite_fts5
+
+package user_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"app/internal/mail"
+	"app/internal/user"
+)
+
+// requestEmailChange asks for the address of u to become email, failing the
+// test when it waits for the mail server.
+func requestEmailChange(t *testing.T, s *user.UserService, u *user.User, email string) (map[string]string, error) {
+	type result struct {
+		errs map[string]string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		errs, err := s.RequestEmailChange(context.Background(), u, &user.ChangeEmailRequest{
+			Email:           email,
+			CurrentPassword: "Qw7!zNb4vYc1",
+		})
+		done <- result{errs, err}
+	}()
+	select {
+	case r := <-done:
+		return r.errs, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("RequestEmailChange waited for the mail server")
+		return nil, nil
+	}
+}
+
+func TestRequestEmailChangeDoesNotTellTakenAddressesApart(t *testing.T) {
+	s, mailer := newSignUpService(t)
+	ctx := context.Background()
+	var users []*user.User
+	for _, email := range []string{"jane@example.com", "bob@example.com"} {
+		u, _, err := s.StoreUser(ctx, &user.CreateUserRequest{
+			Name:          "Jane",
+			Email:         email,
+			Password:      "Qw7!zNb4vYc1",
+			PasswordCheck: "Qw7!zNb4vYc1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	jane := users[0]
+
+	takenErrs, takenErr := requestEmailChange(t, s, jane, "bob@example.com")
+	freeErrs, freeErr := requestEmailChange(t, s, jane, "free@example.com")
+	if takenErrs != nil || takenErr != nil || freeErrs != nil || freeErr != nil {
+		t.Errorf("RequestEmailChange = %v, %v for a taken address and %v, %v for a free one, want nil, nil for both", takenErrs, takenErr, freeErrs, freeErr)
+	}
+
+	// Both requests notify jane, bob is told about the attempt and only the
+	// free address receives a link.
+	close(mailer.release)
+	got := make(map[string][]mail.Message)
+	for range 4 {
+		select {
+		case msg := <-mailer.sent:
+			got[msg.To] = append(got[msg.To], msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sent %v, want 4 messages", got)
+		}
+	}
+	if len(got["jane@example.com"]) != 2 {
+		t.Errorf("sent %d notices to jane@example.com, want 2", len(got["jane@example.com"]))
+	}
+	for _, msg := range got["bob@example.com"] {
+		if strings.Contains(msg.Body, "token=") {
+			t.Error("sent a confirmation link to the owner of the taken address")
+		}
+	}
+	if len(got["bob@example.com"]) != 1 || len(got["free@example.com"]) != 1 {
+		t.Errorf("sent %d messages to bob@example.com and %d to free@example.com, want 1 each", len(got["bob@example.com"]), len(got["free@example.com"]))
+	}
+}
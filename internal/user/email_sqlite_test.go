@@ -0,0 +1,65 @@
+//go:build sqlite_fts5
+
+package user_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/internal/user"
+)
+
+// TestRepairEmailsAfterBackfill starts from the keys the SQL backfill of
+// migration 00009 computes, which only fold ASCII.
+func TestRepairEmailsAfterBackfill(t *testing.T) {
+	defer func(fold bool) { user.FoldEmailLocalPart = fold }(user.FoldEmailLocalPart)
+	ctx := context.Background()
+	database := dbtest.Sqlite(t)
+	r := user.NewUserRepositorySqlite(database)
+	s := user.NewUserService(r, &user.Options{})
+	now := time.Now().UTC()
+	u := &user.User{Id: core.NewID(), Name: "Émile", Email: "ÉMILE@Example.com", Password: "hash", Status: user.UserStatusActive, CreatedAt: now, UpdatedAt: now}
+	if err := r.Store(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	erased := &user.User{Id: core.NewID(), Name: "Erased", Email: "erased@example.com", Password: "hash", Status: user.UserStatusActive, CreatedAt: now, UpdatedAt: now}
+	if err := r.Store(ctx, erased); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Anonymize(ctx, erased.Id, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Exec("UPDATE users SET email_normalized = LOWER(TRIM(email))"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fold   bool
+		lookup string
+	}{
+		{true, "émile@example.com"},
+		{false, "ÉMILE@example.com"},
+	}
+	for _, tt := range tests {
+		user.FoldEmailLocalPart = tt.fold
+		if _, _, err := s.RepairEmails(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+		found, err := r.FindByEmail(ctx, tt.lookup)
+		if err != nil || found.Id != u.Id {
+			t.Errorf("fold %v: FindByEmail(%q) = %v, %v", tt.fold, tt.lookup, found, err)
+		}
+	}
+	keys, err := r.ListEmailKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if k.UserId == erased.Id && k.Normalized != "" {
+			t.Errorf("anonymized user got the key %q", k.Normalized)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package user
+
+import (
+	"app/internal/audit"
+	"app/internal/core"
+	"context"
+	"log"
+	"time"
+)
+
+type EraseMode string
+
+const (
+	// EraseAnonymize keeps the row, so references to the user stay valid
+	// until the deleted users are purged, but replaces everything that
+	// identifies the person.
+	EraseAnonymize EraseMode = "anonymize"
+	// EraseDelete removes the row and every row that references it.
+	EraseDelete EraseMode = "delete"
+)
+
+const anonymizedName = "Deleted user"
+
+// anonymizedEmail is unique per user and cannot receive mail.
+func anonymizedEmail(id string) string {
+	return "deleted-" + id + "@invalid"
+}
+
+func (m EraseMode) Valid() bool {
+	return m == EraseAnonymize || m == EraseDelete
+}
+
+// Erase removes the personal data of the user and records a tombstone in the
+// audit log. Events already in the audit log are kept, they are needed to
+// investigate past incidents, but the key sealing their diffs is deleted.
+func (s *UserService) Erase(ctx context.Context, user *User, mode EraseMode) error {
+	if !mode.Valid() {
+		return ErrInvalidRequest
+	}
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		var err error
+		if mode == EraseDelete {
+			err = s.repo.Erase(ctx, user.Id)
+		} else {
+			err = s.repo.Anonymize(ctx, user.Id, time.Now())
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.audit.Forget(ctx, user.Id); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, audit.Entry{
+			Action:     audit.ActionUserErased,
+			TargetType: audit.TargetUser,
+			TargetId:   user.Id,
+			Diff:       map[string]audit.Change{"mode": {To: string(mode)}},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if key, thumb, ok := avatarKeysFromURL(user.Id, user.Avatar); ok && s.avatars != nil {
+		for _, k := range []string{key, thumb} {
+			if err := s.avatars.Delete(k); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckPassword reports whether password is the current password of the user.
+func (s *UserService) CheckPassword(user *User, password string) bool {
+	ok, _ := core.ComparePassword(user.Password, password)
+	return ok
+}
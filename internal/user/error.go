@@ -8,6 +8,16 @@ var ErrRoleNotFound = errors.New("role not found")
 
 var ErrUserAlreadyExists = errors.New("user already exists")
 
+var ErrRoleAlreadyExists = errors.New("role already exists")
+
 var ErrInvalidRequest = errors.New("invalid request")
 
 var ErrInvalidEmailOrPassword = errors.New("email or password invalid")
+
+var ErrAvatarUploadDisabled = errors.New("avatar upload is disabled")
+
+var ErrEmailInUse = errors.New("this email address is already used by another account")
+
+var ErrEmailChangeNotFound = errors.New("email change link is invalid or has expired")
+
+var ErrRoleAssignmentForbidden = errors.New("you may not give or take away these roles")
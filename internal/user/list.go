@@ -0,0 +1,81 @@
+package user
+
+import (
+	"app/internal/core"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// userSortColumns and roleSortColumns are the indexed columns lists can be
+// sorted by. Email is qualified as searches join users_fts, which has one too.
+var userSortColumns = map[string]core.SortColumn{
+	"id":         {Column: "id"},
+	"email":      {Column: "users.email"},
+	"created_at": {Column: "created_at", Time: true},
+}
+
+var roleSortColumns = map[string]core.SortColumn{
+	"id":   {Column: "id"},
+	"name": {Column: "name"},
+}
+
+func (r *ListRequest) validate(columns map[string]core.SortColumn) map[string]string {
+	errs := make(map[string]string)
+	sort, err := core.ParseSort(r.Sort, columns, "id")
+	if err != nil {
+		errs["sort"] = "Unknown sort column"
+	} else if r.Cursor != "" {
+		if _, err := core.DecodeCursor(r.Cursor, sort.SortColumn); err != nil {
+			errs["cursor"] = "Invalid cursor"
+		}
+	}
+	statuses := []UserStatus{UserStatusActive, UserStatusInactive, UserStatusDeleted}
+	if r.Status != "" && !slices.Contains(statuses, r.Status) {
+		errs["status"] = "Invalid status"
+	}
+	if !r.CreatedFrom.IsZero() && !r.CreatedTo.IsZero() && !r.CreatedTo.After(r.CreatedFrom) {
+		errs["created_to"] = "End must be after start"
+	}
+	return errs
+}
+
+// normalize applies the default page size and starts at the first page.
+func (r *ListRequest) normalize() {
+	if r.Page < 1 {
+		r.Page = 1
+	}
+	if r.PageSize < 1 {
+		r.PageSize = 10
+	}
+}
+
+// searchWords splits free text into words like the unicode61 tokenizer of
+// FTS5 does, so no query syntax gets through.
+func searchWords(search string) []string {
+	return strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// ftsQuery turns free text into an FTS5 query matching rows that have every
+// word as a prefix.
+func ftsQuery(search string) string {
+	words := searchWords(search)
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = `"` + w + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// tsQuery is ftsQuery for PostgreSQL, a tsquery matching every word as a
+// prefix.
+func tsQuery(search string) string {
+	words := searchWords(search)
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = w + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
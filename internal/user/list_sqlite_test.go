@@ -0,0 +1,112 @@
+//go:build sqlite_fts5
+
+package user_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/internal/user"
+)
+
+// seedListUsers inserts n users, each with one or two of five roles unless
+// withRoles is false. Rows are written directly, hashing passwords would
+// take most of the run.
+func seedListUsers(b *testing.B, database *sql.DB, n int, withRoles bool) {
+	b.Helper()
+	tx, err := database.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	roles := make([]string, 5)
+	for i := range roles {
+		roles[i] = core.NewID()
+		_, err := tx.Exec(
+			"INSERT INTO roles (id, name, description, permissions, created_at, updated_at) VALUES (?, ?, '', '[]', ?, ?)",
+			roles[i], fmt.Sprintf("role-%d", i), now, now,
+		)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		id := core.NewID()
+		email := fmt.Sprintf("user%d@example.com", i)
+		created := now.Add(time.Duration(i-n) * time.Second)
+		_, err := tx.Exec(
+			`INSERT INTO users (id, email, email_normalized, name, password, status, created_at, updated_at)
+			VALUES (?, ?, ?, ?, '', ?, ?, ?)`,
+			id, email, email, fmt.Sprintf("User %d", i), user.UserStatusActive, created, created,
+		)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !withRoles {
+			continue
+		}
+		for j := 0; j <= i%2; j++ {
+			if _, err := tx.Exec("INSERT INTO user_roles (user_id, role_id) VALUES (?, ?)", id, roles[(i+j)%len(roles)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkListUsers lists the first page of 100k users, the OFFSET scan and
+// the sort staying out of the numbers so that only loading roles differs.
+// "roles per row" loads the roles of each listed user with its own query, the
+// way they were loaded before being batched. The users are seeded once for
+// every sub-benchmark, and not at all with -short.
+func BenchmarkListUsers(b *testing.B) {
+	if testing.Short() {
+		b.Skip("seeding 100k users is slow")
+	}
+	withRoles := dbtest.Sqlite(b)
+	seedListUsers(b, withRoles, 100_000, true)
+	withoutRoles := dbtest.Sqlite(b)
+	seedListUsers(b, withoutRoles, 100_000, false)
+
+	benchmarks := []struct {
+		name      string
+		database  *sql.DB
+		skipRoles bool
+		perRow    bool
+	}{
+		{"with roles", withRoles, false, false},
+		{"without roles", withoutRoles, false, false},
+		{"skip roles", withRoles, true, false},
+		{"roles per row", withRoles, true, true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			repo := user.NewUserRepositorySqlite(bm.database)
+			req := user.ListRequest{Page: 1, PageSize: 50, IncludeDeleted: true, SkipRoles: bm.skipRoles}
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res, err := repo.ListUsers(ctx, req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if !bm.perRow {
+					continue
+				}
+				for j := range res.Users {
+					if res.Users[j].Roles, err = repo.GetUserRoles(ctx, res.Users[j].Id); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
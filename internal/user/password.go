@@ -0,0 +1,95 @@
+package user
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcryptMaxBytes is the length past which bcrypt silently ignores input.
+const bcryptMaxBytes = 72
+
+type PasswordPolicy struct {
+	MinLength     int
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// DisallowPersonalInfo rejects passwords containing the user name or email.
+	DisallowPersonalInfo bool
+	// Breached, when set, rejects passwords found in known data breaches.
+	Breached BreachChecker
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:            12,
+	MaxBytes:             bcryptMaxBytes,
+	DisallowPersonalInfo: true,
+}
+
+// Check returns a message for every rule the password breaks.
+func (p *PasswordPolicy) Check(password, name, email string) []string {
+	var errs []string
+	if utf8.RuneCountInString(password) < p.MinLength {
+		errs = append(errs, fmt.Sprintf("Password must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		errs = append(errs, fmt.Sprintf("Password must be at most %d bytes long", p.MaxBytes))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		errs = append(errs, "Password must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		errs = append(errs, "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		errs = append(errs, "Password must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		errs = append(errs, "Password must contain a symbol")
+	}
+
+	if p.DisallowPersonalInfo && containsPersonalInfo(password, name, email) {
+		errs = append(errs, "Password must not contain your name or email")
+	}
+
+	if p.Breached != nil && len(errs) == 0 {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			log.Println(err)
+		}
+		if breached {
+			errs = append(errs, "Password has appeared in a data breach, please choose another one")
+		}
+	}
+	return errs
+}
+
+func containsPersonalInfo(password, name, email string) bool {
+	password = strings.ToLower(password)
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	parts := append(strings.Fields(strings.ToLower(name)), local)
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package user
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	strict := PasswordPolicy{
+		MinLength:     8,
+		MaxBytes:      bcryptMaxBytes,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	}
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		want     []string
+	}{
+		{"default accepts a long password", DefaultPasswordPolicy, "correct horse battery", nil},
+		{"too short", DefaultPasswordPolicy, "Qw7!zNb4", []string{"Password must be at least 12 characters long"}},
+		{"length counts characters, not bytes", DefaultPasswordPolicy, "éééééééééééé", nil},
+		{"72 bytes", DefaultPasswordPolicy, strings.Repeat("x", 72), nil},
+		{"past the bcrypt limit", DefaultPasswordPolicy, strings.Repeat("x", 73), []string{"Password must be at most 72 bytes long"}},
+		{"bcrypt limit counts bytes", DefaultPasswordPolicy, strings.Repeat("é", 37), []string{"Password must be at most 72 bytes long"}},
+		{"every class", strict, "Qw7!zNb4", nil},
+		{"no uppercase", strict, "qw7!znb4", []string{"Password must contain an uppercase letter"}},
+		{"no lowercase", strict, "QW7!ZNB4", []string{"Password must contain a lowercase letter"}},
+		{"no digit", strict, "Qwe!zNbv", []string{"Password must contain a digit"}},
+		{"no symbol", strict, "Qw7czNb4", []string{"Password must contain a symbol"}},
+		{"space counts as a symbol", strict, "Qw7 zNb4", nil},
+		{"letters only", strict, "abcdefgh", []string{
+			"Password must contain an uppercase letter",
+			"Password must contain a digit",
+			"Password must contain a symbol",
+		}},
+		{"contains the name", DefaultPasswordPolicy, "ilovejane2024!", []string{"Password must not contain your name or email"}},
+		{"contains the name in another case", DefaultPasswordPolicy, "iloveJANE2024!", []string{"Password must not contain your name or email"}},
+		{"contains the local part of the email", DefaultPasswordPolicy, "jdoe-password-1", []string{"Password must not contain your name or email"}},
+		{"contains the domain only", DefaultPasswordPolicy, "example-password", nil},
+		{"personal info allowed", PasswordPolicy{MinLength: 12}, "ilovejane2024!", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.Check(tt.password, "Jane Doe", "jdoe@example.com")
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Check(%q) = %q, want %q", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainsPersonalInfoIgnoresShortParts(t *testing.T) {
+	// Parts shorter than 3 characters would reject far too many passwords.
+	if containsPersonalInfo("a good long password", "Al Li", "al@example.com") {
+		t.Error("short name parts rejected the password")
+	}
+}
+
+type breachFunc func(password string) (bool, error)
+
+func (f breachFunc) IsBreached(password string) (bool, error) {
+	return f(password)
+}
+
+func TestPasswordPolicyBreached(t *testing.T) {
+	var checked []string
+	policy := DefaultPasswordPolicy
+	policy.Breached = breachFunc(func(password string) (bool, error) {
+		checked = append(checked, password)
+		switch password {
+		case "password1234":
+			return true, nil
+		case "unreachable service":
+			return false, errors.New("unreachable")
+		}
+		return false, nil
+	})
+
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"password1234", 1},
+		{"a password never seen", 0},
+		// The check fails open, the other rules still apply.
+		{"unreachable service", 0},
+	}
+	for _, tt := range tests {
+		if got := policy.Check(tt.password, "Jane", "jane@example.com"); len(got) != tt.want {
+			t.Errorf("Check(%q) = %q, want %d message", tt.password, got, tt.want)
+		}
+	}
+
+	// A password already rejected is not sent to the checker.
+	checked = nil
+	policy.Check("short", "Jane", "jane@example.com")
+	if len(checked) != 0 {
+		t.Errorf("checked %q against breaches, want nothing", checked)
+	}
+}
+
+// writeRange writes the range file of the passwords' prefixes, as in the Have
+// I Been Pwned dump.
+func writeRange(t *testing.T, dir string, passwords ...string) {
+	t.Helper()
+	files := make(map[string][]string)
+	for _, p := range passwords {
+		sum := sha1.Sum([]byte(p))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		files[hash[:5]] = append(files[hash[:5]], hash[5:]+":42")
+	}
+	for prefix, lines := range files {
+		// Other suffixes of the prefix, and Windows line endings.
+		lines = append([]string{strings.Repeat("0", 35) + ":1"}, lines...)
+		body := strings.Join(lines, "\r\n") + "\r\n"
+		if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBreachedPasswordDir(t *testing.T) {
+	dir := t.TempDir()
+	writeRange(t, dir, "password1234", "hunter2hunter2")
+	b := NewBreachedPasswordDir(dir)
+
+	// The range file of "password1234" is lowercased, suffixes are compared
+	// regardless of case.
+	sum := sha1.Sum([]byte("password1234"))
+	prefix := strings.ToUpper(hex.EncodeToString(sum[:]))[:5]
+	lower := filepath.Join(dir, prefix+".txt")
+	body, err := os.ReadFile(lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lower, []byte(strings.ToLower(string(body))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password1234", true},
+		{"hunter2hunter2", true},
+		{"password12345", false},
+		{"Password1234", false},
+	}
+	for _, tt := range tests {
+		got, err := b.IsBreached(tt.password)
+		if err != nil {
+			t.Fatalf("IsBreached(%q): %v", tt.password, err)
+		}
+		if got != tt.want {
+			t.Errorf("IsBreached(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestBreachedPasswordDirMissing(t *testing.T) {
+	b := NewBreachedPasswordDir(filepath.Join(t.TempDir(), "missing"))
+	if got, err := b.IsBreached("password1234"); got || err != nil {
+		t.Errorf("IsBreached without range files = %v, %v, want false, nil", got, err)
+	}
+}
@@ -0,0 +1,166 @@
+package user
+
+import (
+	"app/internal/audit"
+	"app/internal/core"
+	"context"
+	"io"
+	"log"
+	"strings"
+)
+
+type UpdateProfileRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *UpdateProfileRequest) Validate() map[string]string {
+	errs := make(map[string]string)
+	if strings.TrimSpace(r.Name) == "" {
+		errs["name"] = "Name is required"
+	}
+	return errs
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+	PasswordCheck   string `json:"password_check"`
+}
+
+func (r *ChangePasswordRequest) Validate() map[string]string {
+	errs := make(map[string]string)
+	if r.CurrentPassword == "" {
+		errs["current_password"] = "Current password is required"
+	}
+	if r.Password == "" {
+		errs["password"] = "Password is required"
+	}
+	if r.Password != r.PasswordCheck {
+		errs["password_check"] = "Password and password confirmation must be the same"
+	}
+	return errs
+}
+
+// UpdateProfile changes the fields users may edit about themselves.
+func (s *UserService) UpdateProfile(ctx context.Context, user *User, req *UpdateProfileRequest) (map[string]string, error) {
+	errs := req.Validate()
+	if len(errs) > 0 {
+		return errs, ErrInvalidRequest
+	}
+	before := user.auditFields()
+	user.Name = strings.TrimSpace(req.Name)
+	return nil, s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.Update(ctx, user); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, userEntry(audit.ActionUserUpdated, user, audit.Diff(before, user.auditFields())))
+	})
+}
+
+// ChangePassword replaces the password after checking the current one.
+func (s *UserService) ChangePassword(ctx context.Context, user *User, req *ChangePasswordRequest) (map[string]string, error) {
+	errs := req.Validate()
+	if _, ok := errs["password"]; !ok {
+		if msgs := s.policy.Check(req.Password, user.Name, user.Email); len(msgs) > 0 {
+			errs["password"] = strings.Join(msgs, "\n")
+		}
+	}
+	if req.CurrentPassword != "" {
+		if ok, _ := core.ComparePassword(user.Password, req.CurrentPassword); !ok {
+			errs["current_password"] = "Current password is incorrect"
+		}
+	}
+	if len(errs) > 0 {
+		return errs, ErrInvalidRequest
+	}
+	hash, err := core.HashPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdatePassword(ctx, user.Id, hash); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, userEntry(audit.ActionPasswordChanged, user, nil))
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hash
+	return nil, nil
+}
+
+// SetPassword replaces the password without asking for the current one, for
+// operators resetting it. The password policy still applies.
+func (s *UserService) SetPassword(ctx context.Context, user *User, password string) (map[string]string, error) {
+	if password == "" {
+		return map[string]string{"password": "Password is required"}, ErrInvalidRequest
+	}
+	if msgs := s.policy.Check(password, user.Name, user.Email); len(msgs) > 0 {
+		return map[string]string{"password": strings.Join(msgs, "\n")}, ErrInvalidRequest
+	}
+	hash, err := core.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdatePassword(ctx, user.Id, hash); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, userEntry(audit.ActionPasswordChanged, user, nil))
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hash
+	return nil, nil
+}
+
+// UpdateAvatar stores the uploaded image, resized to a square avatar and a
+// thumbnail, and removes the previous upload.
+func (s *UserService) UpdateAvatar(ctx context.Context, user *User, upload io.Reader) (map[string]string, error) {
+	if s.avatars == nil {
+		return nil, ErrAvatarUploadDisabled
+	}
+	img, msg := decodeAvatar(upload)
+	if msg != "" {
+		return map[string]string{"avatar": msg}, ErrInvalidRequest
+	}
+	avatar, err := encodePNG(resizeSquare(img, AvatarSize))
+	if err != nil {
+		return nil, err
+	}
+	thumb, err := encodePNG(resizeSquare(img, AvatarThumbnailSize))
+	if err != nil {
+		return nil, err
+	}
+
+	key, thumbKey := avatarKeys(user.Id, core.NewID())
+	if err := s.avatars.Put(key, avatar); err != nil {
+		return nil, err
+	}
+	if err := s.avatars.Put(thumbKey, thumb); err != nil {
+		return nil, err
+	}
+
+	before := user.auditFields()
+	previous := user.Avatar
+	user.Avatar = AvatarURLPrefix + key
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.Update(ctx, user); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, userEntry(audit.ActionUserUpdated, user, audit.Diff(before, user.auditFields())))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if oldKey, oldThumb, ok := avatarKeysFromURL(user.Id, previous); ok {
+		for _, k := range []string{oldKey, oldThumb} {
+			if err := s.avatars.Delete(k); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,71 @@
+package user
+
+import (
+	"app/internal/audit"
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultRetention is how long soft-deleted users are kept before they are
+// purged.
+const DefaultRetention = 30 * 24 * time.Hour
+
+// Restore brings back a soft-deleted user as active.
+func (s *UserService) Restore(ctx context.Context, id string) (*User, error) {
+	var user *User
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Restore(ctx, id); err != nil {
+			return err
+		}
+		var err error
+		user, err = s.repo.Find(ctx, id)
+		if err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, userEntry(audit.ActionUserRestored, user, map[string]audit.Change{
+			"status": {From: string(UserStatusDeleted), To: string(user.Status)},
+		}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// PurgeDeleted hard-deletes the users soft-deleted before the given time,
+// anonymized ones included, and returns how many were removed.
+func (s *UserService) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	users, err := s.repo.ListDeleted(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for i := range users {
+		if err := s.Erase(ctx, &users[i], EraseDelete); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RunPurge purges the users deleted longer than retention ago, every
+// interval, until the process exits.
+func (s *UserService) RunPurge(retention, interval time.Duration) {
+	if interval == 0 {
+		interval = 1 * time.Hour
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			n, err := s.PurgeDeleted(context.Background(), time.Now().Add(-retention))
+			if err != nil {
+				log.Println(err)
+			}
+			if n > 0 {
+				log.Printf("purged %d deleted users", n)
+			}
+		}
+	}()
+}
@@ -1,32 +1,136 @@
 package user
 
 import (
+	"app/internal/audit"
+	"app/internal/blob"
 	"app/internal/core"
+	"app/internal/mail"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
-type UserService struct {
-	repo UserRepository
+type Options struct {
+	Mailer         mail.Mailer
+	BaseURL        string
+	PasswordPolicy *PasswordPolicy
+	// Avatars stores uploaded avatars, uploads are disabled when nil.
+	Avatars blob.BlobStore
+	// Audit records changes to users and roles, nothing is recorded when nil.
+	Audit *audit.Service
+	// Tx runs every operation, its audit event included, as one unit of
+	// work. Repository calls run on their own when nil.
+	Tx *core.TxManager
+	// Sessions are revoked when a user is deactivated or changes email,
+	// nothing is revoked when nil.
+	Sessions SessionRevoker
 }
 
-func NewUserService(repo UserRepository) *UserService {
-	return &UserService{repo}
+// SessionRevoker ends the sessions of a user, session.Manager implements it.
+type SessionRevoker interface {
+	DestroyOtherSessions(ctx context.Context, userId, exceptId string) error
 }
 
-func (s *UserService) Find(id string) (*User, error) {
-	return s.repo.Find(id)
+type UserService struct {
+	repo     UserRepository
+	mailer   mail.Mailer
+	baseURL  string
+	policy   *PasswordPolicy
+	avatars  blob.BlobStore
+	audit    *audit.Service
+	tx       *core.TxManager
+	sessions SessionRevoker
 }
 
-func (s *UserService) FindByEmail(email string) (*User, error) {
-	return s.repo.FindByEmail(email)
+func NewUserService(repo UserRepository, opts *Options) *UserService {
+	if opts.Mailer == nil {
+		opts.Mailer = mail.NewLogMailer()
+	}
+	if opts.PasswordPolicy == nil {
+		opts.PasswordPolicy = &DefaultPasswordPolicy
+	}
+	return &UserService{
+		repo:     repo,
+		mailer:   opts.Mailer,
+		baseURL:  opts.BaseURL,
+		policy:   opts.PasswordPolicy,
+		avatars:  opts.Avatars,
+		audit:    opts.Audit,
+		tx:       opts.Tx,
+		sessions: opts.Sessions,
+	}
 }
 
-func (s *UserService) StoreUser(req *CreateUserRequest) (*User, map[string]string, error) {
-	user, _ := s.repo.FindByEmail(req.Email)
-	if user != nil {
-		return nil, nil, ErrUserAlreadyExists
+// inTx runs fn as one unit of work when a TxManager is configured. fn may be
+// run again when the database is busy, mail is sent once it returned.
+func (s *UserService) inTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.tx == nil {
+		return fn(ctx)
 	}
+	return s.tx.WithinTx(ctx, fn)
+}
+
+// dummyHashes holds a hash for every algorithm stored hashes may have been
+// made with. Authenticate compares against those the account does not use,
+// so that unknown emails and accounts with a hash of any algorithm take as
+// long. They are made again when core.DefaultPasswordHasher is replaced.
+var dummyHashes = struct {
+	sync.Mutex
+	hasher *core.PasswordHasher
+	hashes map[string]string
+}{}
+
+func dummyHashesOf(hasher *core.PasswordHasher) map[string]string {
+	dummyHashes.Lock()
+	defer dummyHashes.Unlock()
+	if dummyHashes.hasher == hasher {
+		return dummyHashes.hashes
+	}
+	hashes := make(map[string]string)
+	for _, algorithm := range hasher.Algorithms() {
+		hash, err := hasher.HashWith(algorithm, "dummy password used for unknown accounts")
+		if err != nil {
+			panic(err)
+		}
+		hashes[algorithm] = hash
+	}
+	dummyHashes.hasher, dummyHashes.hashes = hasher, hashes
+	return hashes
+}
+
+// comparePassword is core.ComparePassword, replaced by the tests counting the
+// comparisons Authenticate runs.
+var comparePassword = core.ComparePassword
+
+func (s *UserService) Find(ctx context.Context, id string) (*User, error) {
+	return s.repo.Find(ctx, id)
+}
+
+func (s *UserService) FindByEmail(ctx context.Context, email string) (*User, error) {
+	return s.repo.FindByEmail(ctx, email)
+}
+
+// validate trims the email, then checks the request and the password against
+// the password policy. Every broken policy rule is reported on its own line
+// under "password".
+func (s *UserService) validate(req *CreateUserRequest) map[string]string {
+	req.Email = strings.TrimSpace(req.Email)
 	errs := req.Validate()
+	if _, ok := errs["password"]; !ok {
+		if msgs := s.policy.Check(req.Password, req.Name, req.Email); len(msgs) > 0 {
+			errs["password"] = strings.Join(msgs, "\n")
+		}
+	}
+	return errs
+}
+
+func (s *UserService) StoreUser(ctx context.Context, req *CreateUserRequest) (*User, map[string]string, error) {
+	errs := s.validate(req)
 	if len(errs) > 0 {
 		return nil, errs, ErrInvalidRequest
 	}
@@ -34,72 +138,360 @@ func (s *UserService) StoreUser(req *CreateUserRequest) (*User, map[string]strin
 		req.Name,
 		req.Email,
 		req.Password,
-		req.Avatar,
 	)
 	if err != nil {
 		return nil, nil, err
 	}
-	if err := s.repo.Store(user); err != nil {
+	if err := checkRoleAssignment(ctx, req.Roles); err != nil {
+		return nil, nil, err
+	}
+	user.Roles = req.Roles
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if existing, _ := s.repo.FindByEmail(ctx, req.Email); existing != nil {
+			return ErrUserAlreadyExists
+		}
+		if err := s.repo.Store(ctx, user); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, userEntry(audit.ActionUserCreated, user, audit.Diff(nil, user.auditFields())))
+	})
+	if err != nil {
 		return nil, nil, err
 	}
 	return user, nil, nil
 }
 
-func (s *UserService) UpdateUser(user *User, req *CreateUserRequest) (map[string]string, error) {
+// SignUp registers an account from the public signup form. It does the same
+// work and returns the same result whether the email is taken or not, the
+// owner of an existing account is notified by email instead.
+func (s *UserService) SignUp(ctx context.Context, req *CreateUserRequest) (map[string]string, error) {
+	errs := s.validate(req)
+	if len(errs) > 0 {
+		return errs, ErrInvalidRequest
+	}
+	user, err := NewUser(
+		req.Name,
+		req.Email,
+		req.Password,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var existing *User
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		var err error
+		existing, err = s.repo.FindByEmail(ctx, req.Email)
+		if err == nil || !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		if err := s.repo.Store(ctx, user); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, userEntry(audit.ActionUserCreated, user, audit.Diff(nil, user.auditFields())))
+	})
+	// A deleted account keeps its email until it is purged, so that it can
+	// be restored. The insert then fails and is answered like any other
+	// existing account.
+	if errors.Is(err, ErrUserAlreadyExists) {
+		go s.notifyDeletedSignupAttempt(req.Email)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		// Waiting for the mail server would tell an existing account apart
+		// by the response time.
+		go s.notifySignupAttempt(existing)
+	}
+	return nil, nil
+}
+
+func (s *UserService) notifySignupAttempt(user *User) {
+	err := s.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Sign up attempt with your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nSomeone tried to create a new account using this email address. "+
+				"If it was you, you already have an account and can log in at %s/login.\n\n"+
+				"If it was not you, you can ignore this message.\n",
+			user.Name,
+			s.baseURL,
+		),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *UserService) notifyDeletedSignupAttempt(email string) {
+	err := s.mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Sign up attempt with your email",
+		Body: "Hi,\n\nSomeone tried to create a new account using this email address, " +
+			"which belongs to an account that was deleted. " +
+			"If it was you, an administrator can restore your account.\n\n" +
+			"If it was not you, you can ignore this message.\n",
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *UserService) UpdateUser(ctx context.Context, user *User, req *UpdateUserRequest) (map[string]string, error) {
 	errs := req.Validate()
 	if len(errs) > 0 {
 		return errs, ErrInvalidRequest
 	}
-	var roles []Role
-	for _, role := range user.Roles {
-		roles = append(roles, role)
+	before := user.auditFields()
+	errs = nil
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		if req.RoleIds != nil {
+			roles, err := s.repo.FindRoles(ctx, req.RoleIds)
+			if err != nil {
+				return err
+			}
+			if len(roles) != len(req.RoleIds) {
+				errs = map[string]string{"role_ids": "Unknown role"}
+				return ErrInvalidRequest
+			}
+			if !user.HasRoleIds(req.RoleIds) {
+				if err := checkRoleAssignment(ctx, roleChanges(user.Roles, roles)); err != nil {
+					return err
+				}
+			}
+			user.Roles = roles
+		}
+		user.Name = req.Name
+		if req.Status != "" {
+			user.Status = req.Status
+		}
+		if err := s.Update(ctx, user); err != nil {
+			return err
+		}
+		if err := s.revokeInactive(ctx, user); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, userEntry(audit.ActionUserUpdated, user, audit.Diff(before, user.auditFields())))
+	})
+	return errs, err
+}
+
+// checkRoleAssignment returns ErrRoleAssignmentForbidden unless the user of
+// ctx may give or take away roles: it takes roles.manage and every permission
+// the roles carry, so that nobody grants more than they hold. Calls made
+// without a user, by the commands, are trusted.
+func checkRoleAssignment(ctx context.Context, roles []Role) error {
+	actor, ok := FromContext(ctx)
+	if !ok || len(roles) == 0 {
+		return nil
+	}
+	if !actor.HasPermission(PermissionRolesManage) {
+		return ErrRoleAssignmentForbidden
+	}
+	for _, role := range roles {
+		for _, p := range role.Permissions {
+			if !actor.HasPermission(p) {
+				return ErrRoleAssignmentForbidden
+			}
+		}
+	}
+	return nil
+}
+
+// roleChanges returns the roles given or taken away going from before to
+// after.
+func roleChanges(before, after []Role) []Role {
+	var changes []Role
+	for _, role := range before {
+		if !slices.ContainsFunc(after, func(r Role) bool { return r.Id == role.Id }) {
+			changes = append(changes, role)
+		}
 	}
-	user.Name = req.Name
-	user.Avatar = req.Avatar
-	user.Roles = append(req.Roles, roles...)
-	return nil, s.Update(user)
+	for _, role := range after {
+		if !slices.ContainsFunc(before, func(r Role) bool { return r.Id == role.Id }) {
+			changes = append(changes, role)
+		}
+	}
+	return changes
 }
 
-func (s *UserService) Update(user *User) error {
+func (s *UserService) Update(ctx context.Context, user *User) error {
 	user.UpdatedAt = time.Now()
-	return s.repo.Update(user)
+	return s.repo.Update(ctx, user)
 }
 
-func (s *UserService) Delete(id string) error {
-	return s.repo.Delete(id)
+func (s *UserService) Delete(ctx context.Context, id string) error {
+	return s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, id); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, audit.Entry{Action: audit.ActionUserDeleted, TargetType: audit.TargetUser, TargetId: id})
+	})
 }
 
-func (s *UserService) ListUsers(req ListRequest) (*ListUserResponse, error) {
-	return s.repo.ListUsers(req)
+func (s *UserService) ListUsers(ctx context.Context, req ListRequest) (*ListUserResponse, map[string]string, error) {
+	errs := req.validate(userSortColumns)
+	if req.Cursor != "" && req.Search != "" && req.Sort == "" {
+		errs["cursor"] = "Search results ranked by relevance are paged by page number"
+	}
+	if len(errs) > 0 {
+		return nil, errs, ErrInvalidRequest
+	}
+	res, err := s.repo.ListUsers(ctx, req)
+	return res, nil, err
 }
 
-func (s *UserService) ChangeStatus(user *User, status UserStatus) error {
+func (s *UserService) ChangeStatus(ctx context.Context, user *User, status UserStatus) error {
+	before := user.auditFields()
 	user.Status = status
-	return s.repo.Update(user)
+	return s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, user); err != nil {
+			return err
+		}
+		if err := s.revokeInactive(ctx, user); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, userEntry(audit.ActionUserUpdated, user, audit.Diff(before, user.auditFields())))
+	})
+}
+
+// revokeInactive logs a user who may no longer log in out everywhere.
+func (s *UserService) revokeInactive(ctx context.Context, user *User) error {
+	if user.Status == UserStatusActive || s.sessions == nil {
+		return nil
+	}
+	return s.sessions.DestroyOtherSessions(ctx, user.Id, "")
 }
 
-func (s *UserService) ListRoles(req ListRequest) (*ListRoleResponse, error) {
-	return s.repo.ListRoles(req)
+func (s *UserService) ListRoles(ctx context.Context, req ListRequest) (*ListRoleResponse, map[string]string, error) {
+	if errs := req.validate(roleSortColumns); len(errs) > 0 {
+		return nil, errs, ErrInvalidRequest
+	}
+	res, err := s.repo.ListRoles(ctx, req)
+	return res, nil, err
 }
 
-func (s *UserService) FindRole(id string) (*Role, error) {
-	return s.repo.FindRole(id)
+func (s *UserService) FindRole(ctx context.Context, id string) (*Role, error) {
+	return s.repo.FindRole(ctx, id)
 }
 
-func (s *UserService) FindRoles(ids []string) ([]Role, error) {
-	return s.repo.FindRoles(ids)
+func (s *UserService) FindRoleByName(ctx context.Context, name string) (*Role, error) {
+	return s.repo.FindRoleByName(ctx, name)
 }
 
-func (s *UserService) Authenticate(email, password string) (*User, error) {
-	time.Sleep(core.GetRandomSleep())
-	user, err := s.repo.FindByEmail(email)
-	if err != nil || user == nil {
-		return nil, ErrInvalidEmailOrPassword
+func (s *UserService) FindRoles(ctx context.Context, ids []string) ([]Role, error) {
+	return s.repo.FindRoles(ctx, ids)
+}
+
+func (s *UserService) StoreRole(ctx context.Context, req *CreateRoleRequest) (*Role, map[string]string, error) {
+	errs := req.Validate()
+	if len(errs) > 0 {
+		return nil, errs, ErrInvalidRequest
+	}
+	role := NewRole(req.Name, req.Description, req.Permissions)
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.StoreRole(ctx, role); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, roleEntry(audit.ActionRoleCreated, role, audit.Diff(nil, role.auditFields())))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return role, nil, nil
+}
+
+func (s *UserService) UpdateRole(ctx context.Context, role *Role, req *CreateRoleRequest) (map[string]string, error) {
+	errs := req.Validate()
+	if len(errs) > 0 {
+		return errs, ErrInvalidRequest
+	}
+	before := role.auditFields()
+	role.Name = req.Name
+	role.Description = req.Description
+	role.Permissions = req.Permissions
+	if role.Permissions == nil {
+		role.Permissions = []string{}
 	}
+	role.UpdatedAt = time.Now()
+	return nil, s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdateRole(ctx, role); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, roleEntry(audit.ActionRoleUpdated, role, audit.Diff(before, role.auditFields())))
+	})
+}
+
+func (s *UserService) DeleteRole(ctx context.Context, id string) error {
+	return s.inTx(ctx, func(ctx context.Context) error {
+		role, err := s.repo.FindRole(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.DeleteRole(ctx, id); err != nil {
+			return err
+		}
+		return s.audit.Record(ctx, roleEntry(audit.ActionRoleDeleted, role, audit.Diff(role.auditFields(), nil)))
+	})
+}
 
+// Authenticate always runs one password comparison per algorithm in use,
+// against dummy hashes for the algorithms the account does not use or when
+// the email is unknown, so every path takes the same time. Users that are not
+// active are rejected like a wrong password.
+func (s *UserService) Authenticate(ctx context.Context, email, password string) (*User, error) {
 	time.Sleep(core.GetRandomSleep())
-	if !user.ComparePassword(password) {
+	user, err := s.repo.FindByEmail(ctx, email)
+	if err != nil {
+		user = nil
+	}
+	var valid, needsRehash bool
+	if user != nil {
+		valid, needsRehash = comparePassword(user.Password, password)
+	}
+	for algorithm, hash := range dummyHashesOf(core.DefaultPasswordHasher) {
+		if user == nil || core.HashAlgorithm(user.Password) != algorithm {
+			comparePassword(hash, password)
+		}
+	}
+
+	if user == nil || !valid || user.Status != UserStatusActive {
+		// The submitted email is not kept, audit events cannot be deleted
+		// and it may be a password typed into the wrong field.
+		entry := audit.Entry{
+			Action:     audit.ActionLoginFailed,
+			TargetType: audit.TargetUser,
+		}
+		if user != nil {
+			entry.TargetId = user.Id
+		}
+		s.audit.Record(ctx, entry)
 		return nil, ErrInvalidEmailOrPassword
 	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:     audit.ActionLoginSucceeded,
+		ActorId:    user.Id,
+		TargetType: audit.TargetUser,
+		TargetId:   user.Id,
+	})
+	if needsRehash {
+		s.rehash(ctx, user, password)
+	}
 	return user, nil
 }
+
+// rehash upgrades the stored hash to the current algorithm and parameters.
+// Failures are only logged, the old hash keeps working.
+func (s *UserService) rehash(ctx context.Context, user *User, password string) {
+	hash, err := core.HashPassword(password)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := s.repo.UpdatePassword(ctx, user.Id, hash); err != nil {
+		log.Println(err)
+		return
+	}
+	user.Password = hash
+}
@@ -0,0 +1,102 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestCheckRoleAssignment(t *testing.T) {
+	admin := Role{Id: "admin", Permissions: []string{PermissionAll}}
+	manager := Role{Id: "manager", Permissions: []string{PermissionUsersManage, PermissionRolesManage}}
+	viewer := Role{Id: "viewer", Permissions: []string{PermissionUsersRead}}
+	userManager := Role{Id: "user-manager", Permissions: []string{PermissionUsersManage, PermissionUsersRead}}
+
+	tests := []struct {
+		name  string
+		actor *User
+		roles []Role
+		ok    bool
+	}{
+		{"without a user", nil, []Role{admin}, true},
+		{"without roles.manage", &User{Roles: []Role{userManager}}, []Role{viewer}, false},
+		{"holding the permissions", &User{Roles: []Role{manager, viewer}}, []Role{viewer}, true},
+		{"missing a permission", &User{Roles: []Role{manager}}, []Role{viewer}, false},
+		{"granting everything", &User{Roles: []Role{manager, viewer}}, []Role{admin}, false},
+		{"as an administrator", &User{Roles: []Role{admin}}, []Role{admin, viewer}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.actor != nil {
+				ctx = NewContext(ctx, tt.actor)
+			}
+			err := checkRoleAssignment(ctx, tt.roles)
+			if tt.ok && err != nil {
+				t.Errorf("checkRoleAssignment = %v, want nil", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrRoleAssignmentForbidden) {
+				t.Errorf("checkRoleAssignment = %v, want ErrRoleAssignmentForbidden", err)
+			}
+		})
+	}
+}
+
+func TestRoleChanges(t *testing.T) {
+	a, b, c := Role{Id: "a"}, Role{Id: "b"}, Role{Id: "c"}
+	changes := roleChanges([]Role{a, b}, []Role{b, c})
+	if len(changes) != 2 || changes[0].Id != "a" || changes[1].Id != "c" {
+		t.Errorf("roleChanges = %v, want [a c]", changes)
+	}
+	u := &User{Roles: []Role{a, b}}
+	if !u.HasRoleIds([]string{"b", "a"}) || u.HasRoleIds([]string{"a"}) {
+		t.Error("HasRoleIds compares the ids in any order")
+	}
+}
+
+type revokerFunc func(ctx context.Context, userId, exceptId string) error
+
+func (f revokerFunc) DestroyOtherSessions(ctx context.Context, userId, exceptId string) error {
+	return f(ctx, userId, exceptId)
+}
+
+func TestRevokeInactive(t *testing.T) {
+	var revoked []string
+	s := &UserService{sessions: revokerFunc(func(ctx context.Context, userId, exceptId string) error {
+		revoked = append(revoked, userId)
+		return nil
+	})}
+	for _, u := range []*User{
+		{Id: "active", Status: UserStatusActive},
+		{Id: "inactive", Status: UserStatusInactive},
+		{Id: "deleted", Status: UserStatusDeleted},
+	} {
+		if err := s.revokeInactive(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Equal(revoked, []string{"inactive", "deleted"}) {
+		t.Errorf("revoked the sessions of %v, want [inactive deleted]", revoked)
+	}
+}
+
+func TestIsAvatarKey(t *testing.T) {
+	key, thumb := avatarKeys("01J9Z3M8Q4R7T2V6W0X5Y1Z8AB", "01J9Z3M8Q4R7T2V6W0X5Y1Z8CD")
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{key, true},
+		{thumb, true},
+		{"exports/users.csv", false},
+		{"avatars/01J9Z3M8Q4R7T2V6W0X5Y1Z8AB/notes.txt", false},
+		{"avatars/../exports/01J9Z3M8Q4R7T2V6W0X5Y1Z8CD.png", false},
+		{"avatars/01J9Z3M8Q4R7T2V6W0X5Y1Z8AB/01J9Z3M8Q4R7T2V6W0X5Y1Z8CD.png.bak", false},
+	}
+	for _, tt := range tests {
+		if got := IsAvatarKey(tt.key); got != tt.ok {
+			t.Errorf("IsAvatarKey(%q) = %v, want %v", tt.key, got, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+//go:build sqlite_fts5
+
+package user_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/internal/mail"
+	"app/internal/user"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// blockingMailer holds every message until release is closed, like a slow
+// mail server.
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan mail.Message
+}
+
+func (m *blockingMailer) Send(msg mail.Message) error {
+	<-m.release
+	m.sent <- msg
+	return nil
+}
+
+// newSignUpService returns a user service whose notices wait for release.
+func newSignUpService(t *testing.T) (*user.UserService, *blockingMailer) {
+	hasher := core.DefaultPasswordHasher
+	core.DefaultPasswordHasher = &core.PasswordHasher{Algorithm: core.AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}
+	t.Cleanup(func() { core.DefaultPasswordHasher = hasher })
+	database := dbtest.Sqlite(t)
+	mailer := &blockingMailer{release: make(chan struct{}), sent: make(chan mail.Message, 1)}
+	s := user.NewUserService(user.NewUserRepositorySqlite(database), &user.Options{
+		Mailer: mailer,
+		Tx:     core.NewTxManager(database),
+	})
+	return s, mailer
+}
+
+// signUp signs up jane@example.com, failing the test when it waits for the
+// mail server.
+func signUp(t *testing.T, s *user.UserService) (map[string]string, error) {
+	type result struct {
+		errs map[string]string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		errs, err := s.SignUp(context.Background(), &user.CreateUserRequest{
+			Name:          "Jane",
+			Email:         "jane@example.com",
+			Password:      "Qw7!zNb4vYc1",
+			PasswordCheck: "Qw7!zNb4vYc1",
+		})
+		done <- result{errs, err}
+	}()
+	select {
+	case r := <-done:
+		return r.errs, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("SignUp waited for the mail server")
+		return nil, nil
+	}
+}
+
+func wantNotice(t *testing.T, mailer *blockingMailer) {
+	t.Helper()
+	close(mailer.release)
+	select {
+	case msg := <-mailer.sent:
+		if msg.To != "jane@example.com" {
+			t.Errorf("notice sent to %q, want jane@example.com", msg.To)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notice sent for the existing account")
+	}
+}
+
+func TestSignUpDoesNotTellExistingAccountsApart(t *testing.T) {
+	s, mailer := newSignUpService(t)
+	newErrs, newErr := signUp(t, s)
+	existingErrs, existingErr := signUp(t, s)
+	if newErrs != nil || newErr != nil || existingErrs != nil || existingErr != nil {
+		t.Errorf("SignUp = %v, %v for a new email and %v, %v for an existing one, want nil, nil for both", newErrs, newErr, existingErrs, existingErr)
+	}
+	wantNotice(t, mailer)
+}
+
+func TestSignUpDoesNotTellDeletedAccountsApart(t *testing.T) {
+	s, mailer := newSignUpService(t)
+	ctx := context.Background()
+	if _, err := signUp(t, s); err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.FindByEmail(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, u.Id); err != nil {
+		t.Fatal(err)
+	}
+	if errs, err := signUp(t, s); errs != nil || err != nil {
+		t.Errorf("SignUp with the email of a deleted account = %v, %v, want nil, nil", errs, err)
+	}
+	wantNotice(t, mailer)
+}
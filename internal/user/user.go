@@ -2,6 +2,8 @@ package user
 
 import (
 	"app/internal/core"
+	"context"
+	"slices"
 	"time"
 )
 
@@ -13,6 +15,17 @@ const (
 	UserStatusDeleted  UserStatus = "deleted"
 )
 
+// PermissionAll grants every permission to the roles that carry it.
+const PermissionAll = "*"
+
+const (
+	PermissionUsersRead   = "users.read"
+	PermissionUsersManage = "users.manage"
+	PermissionRolesRead   = "roles.read"
+	PermissionRolesManage = "roles.manage"
+	PermissionAuditRead   = "audit.read"
+)
+
 type Role struct {
 	Id          string    `json:"id"`
 	Name        string    `json:"name"`
@@ -32,50 +45,90 @@ type User struct {
 	Status    UserStatus `json:"status"`
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
+	// DeletedAt is set while the user is soft-deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type ListRequest struct {
-	Page     int
-	PageSize int
-	Search   string
+	Page     int `query:"page"`
+	PageSize int `query:"page_size"`
+	// Search matches users having every word as a prefix of a word of their
+	// name or email, best matches first unless Sort is set. Roles are
+	// matched by substring of their name or description.
+	Search string `query:"search"`
+	// IncludeDeleted lists soft-deleted users too. Roles ignore it.
+	IncludeDeleted bool `query:"include_deleted"`
+	// Status and RoleId filter users, roles ignore them.
+	Status UserStatus `query:"status"`
+	RoleId string     `query:"role_id"`
+	// CreatedFrom and CreatedTo bound the creation time, CreatedTo is
+	// exclusive. Zero values leave the range open.
+	CreatedFrom time.Time `query:"created_from"`
+	CreatedTo   time.Time `query:"created_to"`
+	// Sort is a column name, prefixed with "-" for descending order.
+	Sort string `query:"sort"`
+	// Cursor continues after the page that returned it, Page is ignored.
+	Cursor string `query:"cursor"`
+	// SkipRoles leaves the roles of listed users empty, saving a query.
+	SkipRoles bool `query:"skip_roles"`
 }
 
+// Page is 0 and LastPage only informative when the list was read after a
+// cursor. NextCursor is empty on the last page.
 type ListUserResponse struct {
-	Users    []User `json:"users"`
-	Total    int    `json:"total"`
-	Page     int    `json:"page"`
-	PageSize int    `json:"page_size"`
-	LastPage int    `json:"last_page"`
+	Users      []User `json:"users"`
+	Total      int    `json:"total"`
+	Page       int    `json:"page"`
+	PageSize   int    `json:"page_size"`
+	LastPage   int    `json:"last_page"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 type ListRoleResponse struct {
-	Roles    []Role `json:"roles"`
-	Total    int    `json:"total"`
-	Page     int    `json:"page"`
-	PageSize int    `json:"page_size"`
-	LastPage int    `json:"last_page"`
+	Roles      []Role `json:"roles"`
+	Total      int    `json:"total"`
+	Page       int    `json:"page"`
+	PageSize   int    `json:"page_size"`
+	LastPage   int    `json:"last_page"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 type UserRepository interface {
-	Find(id string) (*User, error)
-	FindByEmail(email string) (*User, error)
-	Store(user *User) error
-	Update(user *User) error
-	Delete(id string) error
-	ListUsers(req ListRequest) (*ListUserResponse, error)
-	ListRoles(req ListRequest) (*ListRoleResponse, error)
-	FindRole(id string) (*Role, error)
-	FindRoles(ids []string) ([]Role, error)
-	StoreRole(role *Role) error
-	UpdateRole(role *Role) error
-	DeleteRole(id string) error
-	GetUserRoles(userId string) ([]Role, error)
+	Find(ctx context.Context, id string) (*User, error)
+	FindByEmail(ctx context.Context, email string) (*User, error)
+	Store(ctx context.Context, user *User) error
+	Update(ctx context.Context, user *User) error
+	Delete(ctx context.Context, id string) error
+	ListUsers(ctx context.Context, req ListRequest) (*ListUserResponse, error)
+	ListRoles(ctx context.Context, req ListRequest) (*ListRoleResponse, error)
+	FindRole(ctx context.Context, id string) (*Role, error)
+	FindRoleByName(ctx context.Context, name string) (*Role, error)
+	FindRoles(ctx context.Context, ids []string) ([]Role, error)
+	StoreRole(ctx context.Context, role *Role) error
+	UpdateRole(ctx context.Context, role *Role) error
+	DeleteRole(ctx context.Context, id string) error
+	GetUserRoles(ctx context.Context, userId string) ([]Role, error)
+	UpdatePassword(ctx context.Context, id, hash string) error
+	UpdateEmail(ctx context.Context, id, email string) error
+	StoreEmailChange(ctx context.Context, change *EmailChange) error
+	FindEmailChange(ctx context.Context, tokenHash string) (*EmailChange, error)
+	DeleteEmailChanges(ctx context.Context, userId string) error
+	ListEmailKeys(ctx context.Context) ([]EmailKey, error)
+	SetNormalizedEmails(ctx context.Context, keys map[string]string) error
+	// Anonymize replaces the personal data of the user and removes its roles.
+	Anonymize(ctx context.Context, id string, at time.Time) error
+	// Erase deletes the user row, cascading to the rows that reference it.
+	Erase(ctx context.Context, id string) error
+	// Restore undoes Delete. Anonymized users cannot be restored.
+	Restore(ctx context.Context, id string) error
+	// ListDeleted returns the users soft-deleted before the given time,
+	// without their roles.
+	ListDeleted(ctx context.Context, before time.Time) ([]User, error)
 }
 
 type CreateUserRequest struct {
 	Name          string `json:"name"`
 	Email         string `json:"email"`
-	Avatar		  string `json:"avatar"`
 	Password      string `json:"password"`
 	PasswordCheck string `json:"password_check"`
 	Roles         []Role `json:"roles"`
@@ -88,6 +141,8 @@ func (r *CreateUserRequest) Validate() map[string]string {
 	}
 	if r.Email == "" {
 		errs["email"] = "Email is required"
+	} else if !ValidEmail(r.Email) {
+		errs["email"] = "Email is not valid"
 	}
 	if r.Password == "" {
 		errs["password"] = "Password is required"
@@ -104,7 +159,56 @@ func (r *CreateUserRequest) Validate() map[string]string {
 	return errs
 }
 
-func NewUser(name, email, password, avatar string) (*User, error) {
+// UpdateUserRequest leaves the avatar out, it only changes through
+// UserService.UpdateAvatar.
+type UpdateUserRequest struct {
+	Name    string     `json:"name"`
+	Status  UserStatus `json:"status"`
+	RoleIds []string   `json:"role_ids"`
+}
+
+func (r *UpdateUserRequest) Validate() map[string]string {
+	errs := make(map[string]string)
+	if r.Name == "" {
+		errs["name"] = "Name is required"
+	}
+	switch r.Status {
+	case "", UserStatusActive, UserStatusInactive:
+	default:
+		errs["status"] = "Status must be active or inactive"
+	}
+	return errs
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (r *CreateRoleRequest) Validate() map[string]string {
+	errs := make(map[string]string)
+	if r.Name == "" {
+		errs["name"] = "Name is required"
+	}
+	return errs
+}
+
+func NewRole(name, description string, permissions []string) *Role {
+	if permissions == nil {
+		permissions = []string{}
+	}
+	return &Role{
+		Id:          core.NewID(),
+		Name:        name,
+		Description: description,
+		Permissions: permissions,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+}
+
+func NewUser(name, email, password string) (*User, error) {
 	hash, err := core.HashPassword(password)
 	if err != nil {
 		return nil, err
@@ -113,7 +217,6 @@ func NewUser(name, email, password, avatar string) (*User, error) {
 		Id:        core.NewID(),
 		Name:      name,
 		Email:     email,
-		Avatar:    avatar,
 		Password:  hash,
 		Status:    UserStatusActive,
 		CreatedAt: time.Now(),
@@ -121,6 +224,42 @@ func NewUser(name, email, password, avatar string) (*User, error) {
 	}, nil
 }
 
-func (u *User) ComparePassword(password string) bool {
+// ComparePassword reports whether the password matches and whether the stored
+// hash should be upgraded to the current hashing parameters.
+func (u *User) ComparePassword(password string) (bool, bool) {
 	return core.ComparePassword(u.Password, password)
 }
+
+// Permissions returns the permissions granted by all of the user's roles.
+func (u *User) Permissions() []string {
+	var permissions []string
+	for _, role := range u.Roles {
+		permissions = append(permissions, role.Permissions...)
+	}
+	slices.Sort(permissions)
+	return slices.Compact(permissions)
+}
+
+// HasRoleIds reports whether the roles of the user are exactly ids, in any
+// order.
+func (u *User) HasRoleIds(ids []string) bool {
+	current := make([]string, len(u.Roles))
+	for i, role := range u.Roles {
+		current[i] = role.Id
+	}
+	ids = slices.Clone(ids)
+	slices.Sort(current)
+	slices.Sort(ids)
+	return slices.Equal(slices.Compact(current), slices.Compact(ids))
+}
+
+func (u *User) HasPermission(permission string) bool {
+	for _, role := range u.Roles {
+		for _, p := range role.Permissions {
+			if p == permission || p == PermissionAll {
+				return true
+			}
+		}
+	}
+	return false
+}
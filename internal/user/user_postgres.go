@@ -0,0 +1,502 @@
+package user
+
+import (
+	"app/internal/core"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UserRepositoryPostgres stores users in PostgreSQL. Queries are written
+// with ? placeholders like those of UserRepositorySqlite, conn rebinds
+// them, so that lists share ListQuery and listPage.
+type UserRepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewUserRepositoryPostgres(db *sql.DB) *UserRepositoryPostgres {
+	return &UserRepositoryPostgres{db}
+}
+
+func (r *UserRepositoryPostgres) conn(ctx context.Context) core.DBTX {
+	return core.Rebound(core.Conn(ctx, r.db))
+}
+
+// inTx is core.InTx with the placeholders of the transaction rebound.
+func (r *UserRepositoryPostgres) inTx(ctx context.Context, fn func(tx core.DBTX) error) error {
+	return core.InTx(ctx, r.db, func(tx core.DBTX) error {
+		return fn(core.Rebound(tx))
+	})
+}
+
+// findUser reads a single user with its roles.
+func (r *UserRepositoryPostgres) findUser(ctx context.Context, query string, args ...any) (*User, error) {
+	u, err := scanUserRow(r.conn(ctx).QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return nil, err
+	}
+	u.Roles, err = r.GetUserRoles(ctx, u.Id)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *UserRepositoryPostgres) Find(ctx context.Context, id string) (*User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = ? AND deleted_at IS NULL"
+	return r.findUser(ctx, query, id)
+}
+
+func (r *UserRepositoryPostgres) FindByEmail(ctx context.Context, email string) (*User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE email_normalized = ? AND deleted_at IS NULL"
+	return r.findUser(ctx, query, NormalizeEmail(email))
+}
+
+func (r *UserRepositoryPostgres) Store(ctx context.Context, user *User) error {
+	return r.inTx(ctx, func(tx core.DBTX) error {
+		query := `INSERT INTO users (
+			id, email, email_normalized, name, password, avatar, status, created_at, updated_at
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		_, err := tx.ExecContext(ctx,
+			query,
+			user.Id,
+			user.Email,
+			NormalizeEmail(user.Email),
+			user.Name,
+			user.Password,
+			user.Avatar,
+			user.Status,
+			user.CreatedAt,
+			user.UpdatedAt,
+		)
+		if core.IsUniqueViolation(err) {
+			return ErrUserAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		return r.insertRoles(ctx, tx, user.Id, user.Roles)
+	})
+}
+
+func (r *UserRepositoryPostgres) insertRoles(ctx context.Context, tx core.DBTX, userId string, roles []Role) error {
+	for _, role := range roles {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO user_roles (user_id, role_id) VALUES (?, ?) ON CONFLICT (user_id, role_id) DO NOTHING",
+			userId,
+			role.Id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *UserRepositoryPostgres) Update(ctx context.Context, user *User) error {
+	return r.inTx(ctx, func(tx core.DBTX) error {
+		// deleted_at follows the status, keeping the time of an earlier delete.
+		_, err := tx.ExecContext(ctx,
+			`UPDATE users SET name = ?, avatar = ?, status = ?, updated_at = ?,
+			deleted_at = CASE WHEN ?::text = ? THEN COALESCE(deleted_at, ?::timestamptz) END
+			WHERE id = ?`,
+			user.Name,
+			user.Avatar,
+			user.Status,
+			user.UpdatedAt,
+			user.Status,
+			UserStatusDeleted,
+			user.UpdatedAt,
+			user.Id,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = ?", user.Id); err != nil {
+			return err
+		}
+		return r.insertRoles(ctx, tx, user.Id, user.Roles)
+	})
+}
+
+func (r *UserRepositoryPostgres) UpdatePassword(ctx context.Context, id, hash string) error {
+	_, err := r.conn(ctx).ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", hash, id)
+	return err
+}
+
+func (r *UserRepositoryPostgres) UpdateEmail(ctx context.Context, id, email string) error {
+	_, err := r.conn(ctx).ExecContext(ctx,
+		"UPDATE users SET email = ?, email_normalized = ?, updated_at = ? WHERE id = ?",
+		email,
+		NormalizeEmail(email),
+		time.Now(),
+		id,
+	)
+	if core.IsUniqueViolation(err) {
+		return ErrEmailInUse
+	}
+	return err
+}
+
+func (r *UserRepositoryPostgres) ListEmailKeys(ctx context.Context) ([]EmailKey, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx, "SELECT id, email, email_normalized FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []EmailKey
+	for rows.Next() {
+		var k EmailKey
+		var normalized sql.NullString
+		if err := rows.Scan(&k.UserId, &k.Email, &normalized); err != nil {
+			return nil, err
+		}
+		k.Normalized = normalized.String
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// SetNormalizedEmails sets the lookup keys by user id, an empty key clears
+// it. Keys are cleared first so that keys moving between users do not
+// conflict halfway through.
+func (r *UserRepositoryPostgres) SetNormalizedEmails(ctx context.Context, keys map[string]string) error {
+	return r.inTx(ctx, func(tx core.DBTX) error {
+		for id := range keys {
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET email_normalized = NULL WHERE id = ?", id); err != nil {
+				return err
+			}
+		}
+		for id, key := range keys {
+			if key == "" {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET email_normalized = ? WHERE id = ?", key, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *UserRepositoryPostgres) Anonymize(ctx context.Context, id string, at time.Time) error {
+	return r.inTx(ctx, func(tx core.DBTX) error {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE users SET email = ?, email_normalized = NULL, name = ?, password = '', avatar = NULL, status = ?, updated_at = ?,
+			deleted_at = COALESCE(deleted_at, ?)
+			WHERE id = ?`,
+			anonymizedEmail(id),
+			anonymizedName,
+			UserStatusDeleted,
+			at,
+			at,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM email_changes WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func (r *UserRepositoryPostgres) Erase(ctx context.Context, id string) error {
+	return r.affectUser(r.conn(ctx).ExecContext(ctx, "DELETE FROM users WHERE id = ?", id))
+}
+
+// affectUser turns a statement that changed no row into ErrUserNotFound.
+func (r *UserRepositoryPostgres) affectUser(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepositoryPostgres) StoreEmailChange(ctx context.Context, change *EmailChange) error {
+	query := `INSERT INTO email_changes (
+		token_hash, user_id, new_email, created_at, expires_at
+	) VALUES (
+		?, ?, ?, ?, ?
+	)`
+	_, err := r.conn(ctx).ExecContext(ctx,
+		query,
+		change.TokenHash,
+		change.UserId,
+		change.NewEmail,
+		change.CreatedAt,
+		change.ExpiresAt,
+	)
+	return err
+}
+
+func (r *UserRepositoryPostgres) FindEmailChange(ctx context.Context, tokenHash string) (*EmailChange, error) {
+	query := "SELECT token_hash, user_id, new_email, created_at, expires_at FROM email_changes WHERE token_hash = ?"
+	var c EmailChange
+	err := r.conn(ctx).QueryRowContext(ctx, query, tokenHash).Scan(
+		&c.TokenHash,
+		&c.UserId,
+		&c.NewEmail,
+		&c.CreatedAt,
+		&c.ExpiresAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEmailChangeNotFound
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *UserRepositoryPostgres) DeleteEmailChanges(ctx context.Context, userId string) error {
+	_, err := r.conn(ctx).ExecContext(ctx, "DELETE FROM email_changes WHERE user_id = ?", userId)
+	return err
+}
+
+func (r *UserRepositoryPostgres) Delete(ctx context.Context, id string) error {
+	now := time.Now()
+	query := "UPDATE users SET status = ?, deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL"
+	return r.affectUser(r.conn(ctx).ExecContext(ctx, query, UserStatusDeleted, now, now, id))
+}
+
+// Restore only matches rows that still have an email lookup key, which
+// anonymized rows lost.
+func (r *UserRepositoryPostgres) Restore(ctx context.Context, id string) error {
+	query := `UPDATE users SET status = ?, deleted_at = NULL, updated_at = ?
+	WHERE id = ? AND deleted_at IS NOT NULL AND email_normalized IS NOT NULL`
+	return r.affectUser(r.conn(ctx).ExecContext(ctx, query, UserStatusActive, time.Now(), id))
+}
+
+func (r *UserRepositoryPostgres) ListDeleted(ctx context.Context, before time.Time) ([]User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ? ORDER BY deleted_at"
+	rows, err := r.conn(ctx).QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUserRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ListUsers searches the generated search column of users, which holds the
+// words of the name and email.
+func (r *UserRepositoryPostgres) ListUsers(ctx context.Context, req ListRequest) (*ListUserResponse, error) {
+	sort, err := core.ParseSort(req.Sort, userSortColumns, "id")
+	if err != nil {
+		return nil, err
+	}
+	q := core.NewListQuery("users", userColumns, sort)
+	if match := tsQuery(req.Search); match != "" {
+		q.Where("users.search @@ to_tsquery('simple', ?)", match)
+		// Best matches come first unless a sort was asked for.
+		if req.Sort == "" {
+			q.Rank("ts_rank(users.search, to_tsquery('simple', ?)) DESC", match)
+		}
+	}
+	if !req.IncludeDeleted && req.Status != UserStatusDeleted {
+		q.Where("deleted_at IS NULL")
+	}
+	if req.Status != "" {
+		q.Where("status = ?", req.Status)
+	}
+	if req.RoleId != "" {
+		q.Where("EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = users.id AND ur.role_id = ?)", req.RoleId)
+	}
+	filterCreated(q, req)
+
+	users, p, err := listPage(ctx, r.conn(ctx), q, req, scanUserRow, func(u *User) (any, string) {
+		switch sort.Column {
+		case "users.email":
+			return u.Email, u.Id
+		case "created_at":
+			return u.CreatedAt, u.Id
+		}
+		return u.Id, u.Id
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !req.SkipRoles {
+		if err := loadRoles(ctx, r.conn(ctx), users); err != nil {
+			return nil, err
+		}
+	}
+	return &ListUserResponse{
+		Users:      users,
+		Total:      p.total,
+		Page:       p.page,
+		PageSize:   p.pageSize,
+		LastPage:   p.lastPage,
+		NextCursor: p.nextCursor,
+	}, nil
+}
+
+func (r *UserRepositoryPostgres) ListRoles(ctx context.Context, req ListRequest) (*ListRoleResponse, error) {
+	sort, err := core.ParseSort(req.Sort, roleSortColumns, "id")
+	if err != nil {
+		return nil, err
+	}
+	q := core.NewListQuery("roles", "id, name, description, permissions, created_at, updated_at", sort)
+	if req.Search != "" {
+		// LIKE is case sensitive in PostgreSQL, unlike in SQLite.
+		search := "%" + req.Search + "%"
+		q.Where("(name ILIKE ? OR description ILIKE ?)", search, search)
+	}
+	filterCreated(q, req)
+
+	roles, p, err := listPage(ctx, r.conn(ctx), q, req, scanRoleRow, func(role *Role) (any, string) {
+		if sort.Column == "name" {
+			return role.Name, role.Id
+		}
+		return role.Id, role.Id
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ListRoleResponse{
+		Roles:      roles,
+		Total:      p.total,
+		Page:       p.page,
+		PageSize:   p.pageSize,
+		LastPage:   p.lastPage,
+		NextCursor: p.nextCursor,
+	}, nil
+}
+
+func (r *UserRepositoryPostgres) FindRole(ctx context.Context, id string) (*Role, error) {
+	query := "SELECT id, name, description, permissions, created_at, updated_at FROM roles WHERE id = ?"
+	return scanRoleRow(r.conn(ctx).QueryRowContext(ctx, query, id))
+}
+
+func (r *UserRepositoryPostgres) FindRoleByName(ctx context.Context, name string) (*Role, error) {
+	query := "SELECT id, name, description, permissions, created_at, updated_at FROM roles WHERE name = ?"
+	return scanRoleRow(r.conn(ctx).QueryRowContext(ctx, query, name))
+}
+
+func (r *UserRepositoryPostgres) FindRoles(ctx context.Context, ids []string) ([]Role, error) {
+	if len(ids) == 0 {
+		return []Role{}, nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	query := fmt.Sprintf(`
+		SELECT id, name, description, permissions, created_at, updated_at
+		FROM roles
+		WHERE id IN (%s) ORDER BY id`,
+		strings.Join(placeholders, ","),
+	)
+	return r.listRoles(ctx, query, args...)
+}
+
+func (r *UserRepositoryPostgres) listRoles(ctx context.Context, query string, args ...any) ([]Role, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var roles []Role
+	for rows.Next() {
+		role, err := scanRoleRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// StoreRole and UpdateRole send permissions as a string, pq sends a []byte
+// as bytea which the text column rejects.
+func (r *UserRepositoryPostgres) StoreRole(ctx context.Context, role *Role) error {
+	query := `INSERT INTO roles (
+		id, name, description, permissions, created_at, updated_at
+	) VALUES (
+		?, ?, ?, ?, ?, ?
+	)`
+	p, err := json.Marshal(role.Permissions)
+	if err != nil {
+		return err
+	}
+	_, err = r.conn(ctx).ExecContext(ctx,
+		query,
+		role.Id,
+		role.Name,
+		role.Description,
+		string(p),
+		role.CreatedAt,
+		role.UpdatedAt,
+	)
+	if core.IsUniqueViolation(err) {
+		return ErrRoleAlreadyExists
+	}
+	return err
+}
+
+func (r *UserRepositoryPostgres) UpdateRole(ctx context.Context, role *Role) error {
+	query := `UPDATE roles SET name = ?, description = ?, permissions = ?, updated_at = ? WHERE id = ?`
+	p, err := json.Marshal(role.Permissions)
+	if err != nil {
+		return err
+	}
+	_, err = r.conn(ctx).ExecContext(ctx,
+		query,
+		role.Name,
+		role.Description,
+		string(p),
+		role.UpdatedAt,
+		role.Id,
+	)
+	if core.IsUniqueViolation(err) {
+		return ErrRoleAlreadyExists
+	}
+	return err
+}
+
+func (r *UserRepositoryPostgres) DeleteRole(ctx context.Context, id string) error {
+	_, err := r.conn(ctx).ExecContext(ctx, "DELETE FROM roles WHERE id = ?", id)
+	return err
+}
+
+func (r *UserRepositoryPostgres) GetUserRoles(ctx context.Context, userId string) ([]Role, error) {
+	query := `
+		SELECT r.id, r.name, r.description, r.permissions, r.created_at, r.updated_at
+		FROM user_roles ur
+		JOIN roles r ON ur.role_id = r.id
+		WHERE ur.user_id = ? ORDER BY r.id
+	`
+	return r.listRoles(ctx, query, userId)
+}
@@ -0,0 +1,15 @@
+package user_test
+
+import (
+	"testing"
+
+	"app/internal/db/dbtest"
+	"app/internal/user"
+	"app/internal/user/usertest"
+)
+
+func TestUserRepositoryPostgres(t *testing.T) {
+	usertest.RunRepositoryTests(t, func(t *testing.T) user.UserRepository {
+		return user.NewUserRepositoryPostgres(dbtest.Postgres(t))
+	})
+}
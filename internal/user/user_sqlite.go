@@ -2,24 +2,41 @@ package user
 
 import (
 	"app/internal/core"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"math"
 	"strings"
+	"time"
 )
 
 type UserRepositorySqlite struct {
-	db *sql.DB
+	pools *core.Pools
 }
 
 func NewUserRepositorySqlite(db *sql.DB) *UserRepositorySqlite {
-	return &UserRepositorySqlite{db}
+	return NewUserRepositorySqlitePools(db, db)
 }
 
-func (r *UserRepositorySqlite) scanUserRow(row core.Rowscan) (*User, error) {
+// NewUserRepositorySqlitePools sends the reads made outside transactions to
+// reader, a pool of query-only connections to the database of writer.
+func NewUserRepositorySqlitePools(writer, reader *sql.DB) *UserRepositorySqlite {
+	return &UserRepositorySqlite{core.NewPools(writer, reader)}
+}
+
+func (r *UserRepositorySqlite) conn(ctx context.Context) core.DBTX {
+	return r.pools.Write(ctx)
+}
+
+func (r *UserRepositorySqlite) read(ctx context.Context) core.DBTX {
+	return r.pools.Read(ctx)
+}
+
+func scanUserRow(row core.Rowscan) (*User, error) {
 	var u User
 	var nullableAvatar sql.NullString
+	var deletedAt sql.NullTime
 	err := row.Scan(
 		&u.Id,
 		&u.Email,
@@ -29,6 +46,7 @@ func (r *UserRepositorySqlite) scanUserRow(row core.Rowscan) (*User, error) {
 		&u.Status,
 		&u.CreatedAt,
 		&u.UpdatedAt,
+		&deletedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -37,16 +55,77 @@ func (r *UserRepositorySqlite) scanUserRow(row core.Rowscan) (*User, error) {
 		return nil, err
 	}
 	u.Avatar = nullableAvatar.String
-	roles, err := r.GetUserRoles(u.Id)
+	if deletedAt.Valid {
+		u.DeletedAt = &deletedAt.Time
+	}
+	return &u, nil
+}
+
+// findUser reads a single user with its roles.
+func (r *UserRepositorySqlite) findUser(ctx context.Context, query string, args ...any) (*User, error) {
+	u, err := scanUserRow(r.read(ctx).QueryRowContext(ctx, query, args...))
 	if err != nil {
 		return nil, err
 	}
-	u.Roles = roles
-	return &u, nil
+	u.Roles, err = r.GetUserRoles(ctx, u.Id)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// prefixScan scans the leading columns of a row into prefix, then the rest
+// into the destinations it is called with.
+type prefixScan struct {
+	row    core.Rowscan
+	prefix []any
 }
 
+func (p prefixScan) Scan(dest ...any) error {
+	return p.row.Scan(append(p.prefix, dest...)...)
+}
 
-func (r *UserRepositorySqlite) scanRoleRow(row core.Rowscan) (*Role, error) {
+// loadRoles sets the roles of every user with a single query. It must not be
+// called while rows of another query are still open.
+func loadRoles(ctx context.Context, db core.DBTX, users []User) error {
+	if len(users) == 0 {
+		return nil
+	}
+	byId := make(map[string]*User, len(users))
+	placeholders := make([]string, len(users))
+	args := make([]any, len(users))
+	for i := range users {
+		byId[users[i].Id] = &users[i]
+		placeholders[i] = "?"
+		args[i] = users[i].Id
+	}
+	query := fmt.Sprintf(`
+		SELECT ur.user_id, r.id, r.name, r.description, r.permissions, r.created_at, r.updated_at
+		FROM user_roles ur
+		JOIN roles r ON ur.role_id = r.id
+		WHERE ur.user_id IN (%s) ORDER BY r.id`,
+		strings.Join(placeholders, ","),
+	)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userId string
+		role, err := scanRoleRow(prefixScan{rows, []any{&userId}})
+		if err != nil {
+			return err
+		}
+		if u, ok := byId[userId]; ok {
+			u.Roles = append(u.Roles, *role)
+		}
+	}
+	return rows.Err()
+}
+
+
+func scanRoleRow(row core.Rowscan) (*Role, error) {
 	var role Role
 	var nullablePermissions sql.NullString
 	err := row.Scan(
@@ -75,46 +154,89 @@ func (r *UserRepositorySqlite) scanRoleRow(row core.Rowscan) (*Role, error) {
 }
 
 
-func (r *UserRepositorySqlite) Find(id string) (*User, error) {
-	query := "SELECT id, email, name, password, avatar, status, created_at, updated_at FROM users WHERE id = ?"
-	return r.scanUserRow(r.db.QueryRow(query, id))
+// userColumns are qualified, lists join users_fts which also has name and
+// email columns.
+const userColumns = "users.id, users.email, users.name, users.password, users.avatar, users.status, users.created_at, users.updated_at, users.deleted_at"
+
+func (r *UserRepositorySqlite) Find(ctx context.Context, id string) (*User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = ? AND deleted_at IS NULL"
+	return r.findUser(ctx, query, id)
 }
 
-func (r *UserRepositorySqlite) FindByEmail(email string) (*User, error) {
-	query := "SELECT id, email, name, password, avatar, status, created_at, updated_at FROM users WHERE email = ?"
-	return r.scanUserRow(r.db.QueryRow(query, email))
+func (r *UserRepositorySqlite) FindByEmail(ctx context.Context, email string) (*User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE email_normalized = ? AND deleted_at IS NULL"
+	return r.findUser(ctx, query, NormalizeEmail(email))
 }
 
-func (r *UserRepositorySqlite) Store(user *User) error {
-	tx, err := r.db.Begin()
-    if err != nil {
-        return err
-    }
-    defer tx.Rollback()
+func (r *UserRepositorySqlite) Store(ctx context.Context, user *User) error {
+	return r.pools.InTx(ctx, func(tx core.DBTX) error {
+		query := `INSERT INTO users (
+			id, email, email_normalized, name, password, avatar, status, created_at, updated_at
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		_, err := tx.ExecContext(ctx,
+			query,
+			user.Id,
+			user.Email,
+			NormalizeEmail(user.Email),
+			user.Name,
+			user.Password,
+			user.Avatar,
+			user.Status,
+			user.CreatedAt,
+			user.UpdatedAt,
+		)
+		if core.IsUniqueViolation(err) {
+			return ErrUserAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		if len(user.Roles) > 0 {
+			for _, role := range user.Roles {
+				query = "INSERT INTO user_roles (user_id, role_id) VALUES (?, ?) ON CONFLICT (user_id, role_id) DO NOTHING"
+				_, err = tx.ExecContext(ctx,
+					query,
+					user.Id,
+					role.Id,
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (r *UserRepositorySqlite) Update(ctx context.Context, user *User) error {
+	return r.pools.InTx(ctx, func(tx core.DBTX) error {
+		// deleted_at follows the status, keeping the time of an earlier delete.
+		_, err := tx.ExecContext(ctx,
+			`UPDATE users SET name = ?, avatar = ?, status = ?, updated_at = ?,
+			deleted_at = CASE WHEN ? = ? THEN COALESCE(deleted_at, ?) END
+			WHERE id = ?`,
+			user.Name,
+			user.Avatar,
+			user.Status,
+			user.UpdatedAt,
+			user.Status,
+			UserStatusDeleted,
+			user.UpdatedAt,
+			user.Id,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = r.deleteRolesFromUser(ctx, tx, user.Id)
+		if err != nil {
+			return err
+		}
 
-	query := `INSERT INTO users (
-		id, email, name, password, avatar, status, created_at, updated_at
-	) VALUES (
-		?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err = tx.Exec(
-		query,
-		user.Id,
-		user.Email,
-		user.Name,
-		user.Password,
-		user.Avatar,
-		user.Status,
-		user.CreatedAt,
-		user.UpdatedAt,
-	)
-	if err != nil {
-		return err
-	}
-	if len(user.Roles) > 0 {
 		for _, role := range user.Roles {
-			query = "INSERT INTO user_roles (user_id, role_id) VALUES (?, ?) ON DUPLICATE KEY UPDATE role_id = VALUES(role_id)"
-			_, err = tx.Exec(
-				query,
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO user_roles (user_id, role_id) VALUES (?, ?) ON CONFLICT (user_id, role_id) DO NOTHING",
 				user.Id,
 				role.Id,
 			)
@@ -122,90 +244,203 @@ func (r *UserRepositorySqlite) Store(user *User) error {
 				return err
 			}
 		}
-	}
-	return tx.Commit()
+
+		return nil
+	})
 }
 
-func (r *UserRepositorySqlite) Update(user *User) error {
-	tx, err := r.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+func (r *UserRepositorySqlite) UpdatePassword(ctx context.Context, id, hash string) error {
+	_, err := r.conn(ctx).ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", hash, id)
+	return err
+}
 
-	_, err = tx.Exec(
-		"UPDATE users SET name = ?, avatar = ?, status = ?, updated_at = ? WHERE id = ?",
-		user.Name,
-		user.Avatar,
-		user.Status,
-		user.UpdatedAt,
-		user.Id,
+func (r *UserRepositorySqlite) UpdateEmail(ctx context.Context, id, email string) error {
+	_, err := r.conn(ctx).ExecContext(ctx,
+		"UPDATE users SET email = ?, email_normalized = ?, updated_at = ? WHERE id = ?",
+		email,
+		NormalizeEmail(email),
+		time.Now(),
+		id,
 	)
-	if err != nil {
-		return err
+	if core.IsUniqueViolation(err) {
+		return ErrEmailInUse
 	}
+	return err
+}
 
-	err = r.deleteRolesFromUser(tx, user.Id)
+func (r *UserRepositorySqlite) ListEmailKeys(ctx context.Context) ([]EmailKey, error) {
+	rows, err := r.read(ctx).QueryContext(ctx, "SELECT id, email, email_normalized FROM users ORDER BY id")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []EmailKey
+	for rows.Next() {
+		var k EmailKey
+		var normalized sql.NullString
+		if err := rows.Scan(&k.UserId, &k.Email, &normalized); err != nil {
+			return nil, err
+		}
+		k.Normalized = normalized.String
+		keys = append(keys, k)
 	}
+	return keys, rows.Err()
+}
 
-	for _, role := range user.Roles {
-		_, err = tx.Exec(
-			"INSERT INTO user_roles (user_id, role_id) VALUES (?, ?) ON DUPLICATE KEY UPDATE role_id = VALUES(role_id)",
-			user.Id,
-			role.Id,
+// SetNormalizedEmails sets the lookup keys by user id, an empty key clears
+// it. Keys are cleared first so that keys moving between users do not
+// conflict halfway through.
+func (r *UserRepositorySqlite) SetNormalizedEmails(ctx context.Context, keys map[string]string) error {
+	return r.pools.InTx(ctx, func(tx core.DBTX) error {
+		for id := range keys {
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET email_normalized = NULL WHERE id = ?", id); err != nil {
+				return err
+			}
+		}
+		for id, key := range keys {
+			if key == "" {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET email_normalized = ? WHERE id = ?", key, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *UserRepositorySqlite) Anonymize(ctx context.Context, id string, at time.Time) error {
+	return r.pools.InTx(ctx, func(tx core.DBTX) error {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE users SET email = ?, email_normalized = NULL, name = ?, password = '', avatar = NULL, status = ?, updated_at = ?,
+			deleted_at = COALESCE(deleted_at, ?)
+			WHERE id = ?`,
+			anonymizedEmail(id),
+			anonymizedName,
+			UserStatusDeleted,
+			at,
+			at,
+			id,
 		)
 		if err != nil {
 			return err
 		}
-	}
+		if err := r.deleteRolesFromUser(ctx, tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM email_changes WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		return nil
+	})
+}
 
-	return tx.Commit()
+func (r *UserRepositorySqlite) Erase(ctx context.Context, id string) error {
+	res, err := r.conn(ctx).ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 
-func (r *UserRepositorySqlite) Delete(id string) error {
-	query := "UPDATE users SET status = ? WHERE id = ?"
-	_, err := r.db.Exec(
+func (r *UserRepositorySqlite) StoreEmailChange(ctx context.Context, change *EmailChange) error {
+	query := `INSERT INTO email_changes (
+		token_hash, user_id, new_email, created_at, expires_at
+	) VALUES (
+		?, ?, ?, ?, ?
+	)`
+	_, err := r.conn(ctx).ExecContext(ctx,
 		query,
-		UserStatusDeleted,
-		id,
+		change.TokenHash,
+		change.UserId,
+		change.NewEmail,
+		change.CreatedAt,
+		change.ExpiresAt,
 	)
 	return err
 }
 
-func (r *UserRepositorySqlite) ListUsers(req ListRequest) (*ListUserResponse, error) {
-	where := ""
-	args := []interface{}{}
-	if req.Search != "" {
-		where = "WHERE email LIKE ? OR name LIKE ? OR username LIKE ?"
-		search := "%" + req.Search + "%"
-		args = append(args, search, search, search)
+func (r *UserRepositorySqlite) FindEmailChange(ctx context.Context, tokenHash string) (*EmailChange, error) {
+	query := "SELECT token_hash, user_id, new_email, created_at, expires_at FROM email_changes WHERE token_hash = ?"
+	var c EmailChange
+	err := r.read(ctx).QueryRowContext(ctx, query, tokenHash).Scan(
+		&c.TokenHash,
+		&c.UserId,
+		&c.NewEmail,
+		&c.CreatedAt,
+		&c.ExpiresAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEmailChangeNotFound
+		}
+		return nil, err
 	}
+	return &c, nil
+}
+
+func (r *UserRepositorySqlite) DeleteEmailChanges(ctx context.Context, userId string) error {
+	_, err := r.conn(ctx).ExecContext(ctx, "DELETE FROM email_changes WHERE user_id = ?", userId)
+	return err
+}
 
-	countQuery := "SELECT COUNT(*) FROM users " + where
-	var total int
-	err := r.db.QueryRow(countQuery, args...).Scan(&total)
+func (r *UserRepositorySqlite) Delete(ctx context.Context, id string) error {
+	now := time.Now()
+	query := "UPDATE users SET status = ?, deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL"
+	res, err := r.conn(ctx).ExecContext(ctx,
+		query,
+		UserStatusDeleted,
+		now,
+		now,
+		id,
+	)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if req.Page < 1 {
-		req.Page = 1
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
 	}
-	if req.PageSize < 1 {
-		req.PageSize = 10
+	if n == 0 {
+		return ErrUserNotFound
 	}
-	offset := (req.Page - 1) * req.PageSize
-	lastPage := int(math.Ceil(float64(total) / float64(req.PageSize)))
+	return nil
+}
 
-	query := fmt.Sprintf(`
-        SELECT id, email, name, password, avatar, status, created_at, updated_at
-        FROM users %s
-        ORDER BY id
-        LIMIT ? OFFSET ?`, where)
+// Restore only matches rows that still have an email lookup key, which
+// anonymized rows lost.
+func (r *UserRepositorySqlite) Restore(ctx context.Context, id string) error {
+	query := `UPDATE users SET status = ?, deleted_at = NULL, updated_at = ?
+	WHERE id = ? AND deleted_at IS NOT NULL AND email_normalized IS NOT NULL`
+	res, err := r.conn(ctx).ExecContext(ctx,
+		query,
+		UserStatusActive,
+		time.Now(),
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
 
-	args = append(args, req.PageSize, offset)
-	rows, err := r.db.Query(query, args...)
+func (r *UserRepositorySqlite) ListDeleted(ctx context.Context, before time.Time) ([]User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ? ORDER BY deleted_at"
+	rows, err := r.read(ctx).QueryContext(ctx, query, before)
 	if err != nil {
 		return nil, err
 	}
@@ -213,7 +448,7 @@ func (r *UserRepositorySqlite) ListUsers(req ListRequest) (*ListUserResponse, er
 
 	var users []User
 	for rows.Next() {
-		u, err := r.scanUserRow(rows)
+		u, err := scanUserRow(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -222,89 +457,183 @@ func (r *UserRepositorySqlite) ListUsers(req ListRequest) (*ListUserResponse, er
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
-	return &ListUserResponse{
-		Users:    users,
-		Total:    total,
-		Page:     req.Page,
-		PageSize: req.PageSize,
-		LastPage: lastPage,
-	}, nil
-}
-
-
-func (r *UserRepositorySqlite) deleteRolesFromUser(tx *sql.Tx, userId string) error {
-	_, err := tx.Exec("DELETE FROM user_roles WHERE user_id = ?", userId)
-	return err
+	return users, nil
 }
 
-func (r *UserRepositorySqlite) ListRoles(req ListRequest) (*ListRoleResponse, error) {
-	where := ""
-	args := []interface{}{}
-	if req.Search != "" {
-		where = "WHERE name LIKE ? OR description LIKE ?"
-		search := "%" + req.Search + "%"
-		args = append(args, search, search)
+func (r *UserRepositorySqlite) ListUsers(ctx context.Context, req ListRequest) (*ListUserResponse, error) {
+	sort, err := core.ParseSort(req.Sort, userSortColumns, "id")
+	if err != nil {
+		return nil, err
 	}
+	table := "users"
+	match := ftsQuery(req.Search)
+	if match != "" {
+		table = "users JOIN users_fts ON users_fts.rowid = users.search_id"
+	}
+	q := core.NewListQuery(table, userColumns, sort)
+	if match != "" {
+		q.Where("users_fts MATCH ?", match)
+		// Best matches come first unless a sort was asked for.
+		if req.Sort == "" {
+			q.Rank("users_fts.rank")
+		}
+	}
+	if !req.IncludeDeleted && req.Status != UserStatusDeleted {
+		q.Where("deleted_at IS NULL")
+	}
+	if req.Status != "" {
+		q.Where("status = ?", req.Status)
+	}
+	if req.RoleId != "" {
+		q.Where("EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = users.id AND ur.role_id = ?)", req.RoleId)
+	}
+	filterCreated(q, req)
 
-	countQuery := "SELECT COUNT(*) FROM roles " + where
-	var total int
-	err := r.db.QueryRow(countQuery, args...).Scan(&total)
+	users, p, err := listPage(ctx, r.read(ctx), q, req, scanUserRow, func(u *User) (any, string) {
+		switch sort.Column {
+		case "users.email":
+			return u.Email, u.Id
+		case "created_at":
+			return u.CreatedAt, u.Id
+		}
+		return u.Id, u.Id
+	})
 	if err != nil {
 		return nil, err
 	}
-	if req.Page < 1 {
-		req.Page = 1
+	if !req.SkipRoles {
+		if err := loadRoles(ctx, r.read(ctx), users); err != nil {
+			return nil, err
+		}
+	}
+	return &ListUserResponse{
+		Users:      users,
+		Total:      p.total,
+		Page:       p.page,
+		PageSize:   p.pageSize,
+		LastPage:   p.lastPage,
+		NextCursor: p.nextCursor,
+	}, nil
+}
+
+func filterCreated(q *core.ListQuery, req ListRequest) {
+	if !req.CreatedFrom.IsZero() {
+		q.Where("created_at >= ?", req.CreatedFrom)
 	}
-	if req.PageSize < 1 {
-		req.PageSize = 10
+	if !req.CreatedTo.IsZero() {
+		q.Where("created_at < ?", req.CreatedTo)
 	}
-	offset := (req.Page - 1) * req.PageSize
-	lastPage := int(math.Ceil(float64(total) / float64(req.PageSize)))
+}
 
-	query := fmt.Sprintf(`
-        SELECT id, name, description,
-		permissions, created_at, updated_at
-		FROM roles %s
-        ORDER BY id
-        LIMIT ? OFFSET ?`, where)
-
-	args = append(args, req.PageSize, offset)
-	rows, err := r.db.Query(query, args...)
+type pageInfo struct {
+	total      int
+	page       int
+	pageSize   int
+	lastPage   int
+	nextCursor string
+}
+
+// listPage reads one page of q, by offset or after the cursor of the
+// request. One extra row is read to know whether a next page exists, key
+// returns the sort value and id the next cursor is made of.
+func listPage[T any](ctx context.Context, db core.DBTX, q *core.ListQuery, req ListRequest, scan func(core.Rowscan) (*T, error), key func(*T) (any, string)) ([]T, pageInfo, error) {
+	var p pageInfo
+	query, args := q.Count()
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&p.total); err != nil {
+		return nil, p, err
+	}
+	req.normalize()
+	p.page = req.Page
+	p.pageSize = req.PageSize
+	p.lastPage = int(math.Ceil(float64(p.total) / float64(req.PageSize)))
+
+	if req.Cursor != "" {
+		var err error
+		query, args, err = q.After(req.Cursor, req.PageSize+1)
+		if err != nil {
+			return nil, p, err
+		}
+		p.page = 0
+	} else {
+		query, args = q.Page(req.PageSize+1, (req.Page-1)*req.PageSize)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, p, err
 	}
 	defer rows.Close()
 
-	var roles []Role
+	var items []T
 	for rows.Next() {
-		rl, err := r.scanRoleRow(rows)
+		item, err := scan(rows)
 		if err != nil {
-			return nil, err
+			return nil, p, err
 		}
-		roles = append(roles, *rl)
+		items = append(items, *item)
 	}
 	if err = rows.Err(); err != nil {
+		return nil, p, err
+	}
+	if len(items) > req.PageSize {
+		items = items[:req.PageSize]
+		if !q.Ranked() {
+			p.nextCursor = core.EncodeCursor(key(&items[len(items)-1]))
+		}
+	}
+	return items, p, nil
+}
+
+func (r *UserRepositorySqlite) deleteRolesFromUser(ctx context.Context, tx core.DBTX, userId string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = ?", userId)
+	return err
+}
+
+func (r *UserRepositorySqlite) ListRoles(ctx context.Context, req ListRequest) (*ListRoleResponse, error) {
+	sort, err := core.ParseSort(req.Sort, roleSortColumns, "id")
+	if err != nil {
+		return nil, err
+	}
+	q := core.NewListQuery("roles", "id, name, description, permissions, created_at, updated_at", sort)
+	if req.Search != "" {
+		search := "%" + req.Search + "%"
+		q.Where("(name LIKE ? OR description LIKE ?)", search, search)
+	}
+	filterCreated(q, req)
+
+	roles, p, err := listPage(ctx, r.read(ctx), q, req, scanRoleRow, func(role *Role) (any, string) {
+		if sort.Column == "name" {
+			return role.Name, role.Id
+		}
+		return role.Id, role.Id
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &ListRoleResponse{
-		Roles:    roles,
-		Total:    total,
-		Page:     req.Page,
-		PageSize: req.PageSize,
-		LastPage: lastPage,
+		Roles:      roles,
+		Total:      p.total,
+		Page:       p.page,
+		PageSize:   p.pageSize,
+		LastPage:   p.lastPage,
+		NextCursor: p.nextCursor,
 	}, nil
 }
 
-func (r *UserRepositorySqlite) FindRole(id string) (*Role, error) {
+func (r *UserRepositorySqlite) FindRole(ctx context.Context, id string) (*Role, error) {
 	query := "SELECT id, name, description, permissions, created_at, updated_at FROM roles WHERE id = ?"
-	role, err := r.scanRoleRow(r.db.QueryRow(query, id))
+	role, err := scanRoleRow(r.read(ctx).QueryRowContext(ctx, query, id))
 	if err != nil {
 		return nil, err
 	}
 	return role, nil
 }
 
-func (r *UserRepositorySqlite) FindRoles(ids []string) ([]Role, error) {
+func (r *UserRepositorySqlite) FindRoleByName(ctx context.Context, name string) (*Role, error) {
+	query := "SELECT id, name, description, permissions, created_at, updated_at FROM roles WHERE name = ?"
+	return scanRoleRow(r.read(ctx).QueryRowContext(ctx, query, name))
+}
+
+func (r *UserRepositorySqlite) FindRoles(ctx context.Context, ids []string) ([]Role, error) {
 	if len(ids) == 0 {
 		return []Role{}, nil
 	}
@@ -327,7 +656,7 @@ func (r *UserRepositorySqlite) FindRoles(ids []string) ([]Role, error) {
 		WHERE id IN (%s) ORDER BY id`,
 		strings.Join(placeholders, ","),
 	)
-	rows, err := r.db.Query(query, args...)
+	rows, err := r.read(ctx).QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -335,7 +664,7 @@ func (r *UserRepositorySqlite) FindRoles(ids []string) ([]Role, error) {
 
 	var roles []Role
 	for rows.Next() {
-		r, err := r.scanRoleRow(rows)
+		r, err := scanRoleRow(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -348,7 +677,7 @@ func (r *UserRepositorySqlite) FindRoles(ids []string) ([]Role, error) {
 }
 
 
-func (r *UserRepositorySqlite) StoreRole(role *Role) error {
+func (r *UserRepositorySqlite) StoreRole(ctx context.Context, role *Role) error {
 	query := `INSERT INTO roles (
 		id, name, description, permissions, created_at, updated_at
 	) VALUES (
@@ -358,7 +687,7 @@ func (r *UserRepositorySqlite) StoreRole(role *Role) error {
 	if err != nil {
 		return err
 	}
-	_, err = r.db.Exec(
+	_, err = r.conn(ctx).ExecContext(ctx,
 		query,
 		role.Id,
 		role.Name,
@@ -367,19 +696,22 @@ func (r *UserRepositorySqlite) StoreRole(role *Role) error {
 		role.CreatedAt,
 		role.UpdatedAt,
 	)
+	if core.IsUniqueViolation(err) {
+		return ErrRoleAlreadyExists
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *UserRepositorySqlite) UpdateRole(role *Role) error {
+func (r *UserRepositorySqlite) UpdateRole(ctx context.Context, role *Role) error {
 	query := `UPDATE roles SET name = ?, description = ?, permissions = ?, updated_at = ? WHERE id = ?`
 	p, err := json.Marshal(role.Permissions)
 	if err != nil {
 		return err
 	}
-	_, err = r.db.Exec(
+	_, err = r.conn(ctx).ExecContext(ctx,
 		query,
 		role.Name,
 		role.Description,
@@ -387,36 +719,39 @@ func (r *UserRepositorySqlite) UpdateRole(role *Role) error {
 		role.UpdatedAt,
 		role.Id,
 	)
+	if core.IsUniqueViolation(err) {
+		return ErrRoleAlreadyExists
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *UserRepositorySqlite) DeleteRole(id string) error {
+func (r *UserRepositorySqlite) DeleteRole(ctx context.Context, id string) error {
 	query := "DELETE FROM roles WHERE id = ?"
-	_, err := r.db.Exec(query, id)
+	_, err := r.conn(ctx).ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *UserRepositorySqlite) GetUserRoles(userId string) ([]Role, error) {
+func (r *UserRepositorySqlite) GetUserRoles(ctx context.Context, userId string) ([]Role, error) {
 	query := `
 		SELECT r.id, r.name, r.description, r.permissions, r.created_at, r.updated_at
 		FROM user_roles ur
 		JOIN roles r ON ur.role_id = r.id
 		WHERE ur.user_id = ? ORDER BY id
 	`
-	rows, err := r.db.Query(query, userId)
+	rows, err := r.read(ctx).QueryContext(ctx, query, userId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var roles []Role
 	for rows.Next() {
-		r, err := r.scanRoleRow(rows)
+		r, err := scanRoleRow(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,55 @@
+//go:build sqlite_fts5
+
+package user_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/internal/user"
+	"app/internal/user/usertest"
+)
+
+func TestUserRepositorySqlite(t *testing.T) {
+	usertest.RunRepositoryTests(t, func(t *testing.T) user.UserRepository {
+		return user.NewUserRepositorySqlite(dbtest.Sqlite(t))
+	})
+}
+
+// TestSearchAfterVacuum checks that the search index, keyed by search_id,
+// still finds users after deletions and a VACUUM.
+func TestSearchAfterVacuum(t *testing.T) {
+	ctx := context.Background()
+	database := dbtest.Sqlite(t)
+	r := user.NewUserRepositorySqlite(database)
+	now := time.Now().UTC()
+	ids := make(map[string]string)
+	for _, name := range []string{"Ada", "Grace", "Linus", "Barbara"} {
+		u := &user.User{Id: core.NewID(), Name: name, Email: strings.ToLower(name) + "@example.com", Password: "hash", Status: user.UserStatusActive, CreatedAt: now, UpdatedAt: now}
+		if err := r.Store(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = u.Id
+	}
+	for _, name := range []string{"Ada", "Linus"} {
+		if err := r.Erase(ctx, ids[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := database.Exec("VACUUM"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Grace", "Barbara"} {
+		res, err := r.ListUsers(ctx, user.ListRequest{Search: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Users) != 1 || res.Users[0].Id != ids[name] {
+			t.Errorf("search %q found %+v, want %s", name, res.Users, ids[name])
+		}
+	}
+}
@@ -0,0 +1,923 @@
+// Package usertest is the conformance suite of user.UserRepository. Every
+// backend runs it from its tests with a factory returning an empty
+// repository:
+//
+//	func TestUserRepositorySqlite(t *testing.T) {
+//		usertest.RunRepositoryTests(t, func(t *testing.T) user.UserRepository {
+//			return user.NewUserRepositorySqlite(dbtest.Sqlite(t))
+//		})
+//	}
+package usertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"app/internal/core"
+	"app/internal/user"
+)
+
+// Factory returns a repository over an empty, migrated database.
+type Factory func(t *testing.T) user.UserRepository
+
+// base is the creation time of fixtures, whole seconds so that every
+// backend stores it exactly.
+var base = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// RunRepositoryTests runs every test of the suite against repositories made
+// by newRepo, one for each test.
+func RunRepositoryTests(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r user.UserRepository)
+	}{
+		{"StoreAndFind", testStoreAndFind},
+		{"FindUnknown", testFindUnknown},
+		{"StoreDuplicateEmail", testStoreDuplicateEmail},
+		{"FindByEmail", testFindByEmail},
+		{"Update", testUpdate},
+		{"UpdateStatusDeleted", testUpdateStatusDeleted},
+		{"UpdatePassword", testUpdatePassword},
+		{"UpdateEmail", testUpdateEmail},
+		{"DeleteAndRestore", testDeleteAndRestore},
+		{"ListDeleted", testListDeleted},
+		{"Anonymize", testAnonymize},
+		{"Erase", testErase},
+		{"EmailChanges", testEmailChanges},
+		{"EmailKeys", testEmailKeys},
+		{"ListUsersPages", testListUsersPages},
+		{"ListUsersCursor", testListUsersCursor},
+		{"ListUsersFilters", testListUsersFilters},
+		{"ListUsersSearch", testListUsersSearch},
+		{"ListUsersSort", testListUsersSort},
+		{"StoreAndFindRole", testStoreAndFindRole},
+		{"RoleNames", testRoleNames},
+		{"UpdateRole", testUpdateRole},
+		{"DeleteRole", testDeleteRole},
+		{"FindRoles", testFindRoles},
+		{"ListRoles", testListRoles},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func newRole(t *testing.T, r user.UserRepository, name string, permissions ...string) user.Role {
+	t.Helper()
+	role := user.Role{
+		Id:          core.NewID(),
+		Name:        name,
+		Description: "The " + name + " role",
+		Permissions: append([]string{}, permissions...),
+		CreatedAt:   base,
+		UpdatedAt:   base,
+	}
+	if err := r.StoreRole(context.Background(), &role); err != nil {
+		t.Fatalf("StoreRole(%s): %v", name, err)
+	}
+	return role
+}
+
+func newUser(t *testing.T, r user.UserRepository, name, email string, roles ...user.Role) user.User {
+	t.Helper()
+	u := user.User{
+		Id:        core.NewID(),
+		Name:      name,
+		Email:     email,
+		Password:  "hash",
+		Status:    user.UserStatusActive,
+		Roles:     roles,
+		CreatedAt: base,
+		UpdatedAt: base,
+	}
+	if err := r.Store(context.Background(), &u); err != nil {
+		t.Fatalf("Store(%s): %v", email, err)
+	}
+	return u
+}
+
+func find(t *testing.T, r user.UserRepository, id string) *user.User {
+	t.Helper()
+	u, err := r.Find(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Find(%s): %v", id, err)
+	}
+	return u
+}
+
+func wantErr(t *testing.T, op string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: got error %v, want %v", op, err, want)
+	}
+}
+
+func roleIds(roles []user.Role) []string {
+	ids := make([]string, len(roles))
+	for i, role := range roles {
+		ids[i] = role.Id
+	}
+	return ids
+}
+
+func userIds(users []user.User) []string {
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.Id
+	}
+	return ids
+}
+
+// sortedIds returns the ids in the order of the repository, which sorts by
+// id unless asked otherwise.
+func sortedIds(ids ...string) []string {
+	ids = slices.Clone(ids)
+	sort.Strings(ids)
+	return ids
+}
+
+func equalRole(t *testing.T, got, want user.Role) {
+	t.Helper()
+	if got.Id != want.Id || got.Name != want.Name || got.Description != want.Description {
+		t.Errorf("role %+v, want %+v", got, want)
+	}
+	if !slices.Equal(got.Permissions, want.Permissions) {
+		t.Errorf("role %s permissions %v, want %v", want.Name, got.Permissions, want.Permissions)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("role %s times %s %s, want %s %s", want.Name, got.CreatedAt, got.UpdatedAt, want.CreatedAt, want.UpdatedAt)
+	}
+}
+
+func testStoreAndFind(t *testing.T, r user.UserRepository) {
+	admin := newRole(t, r, "admin", user.PermissionAll)
+	auditor := newRole(t, r, "auditor", user.PermissionAuditRead)
+	want := user.User{
+		Id:        core.NewID(),
+		Name:      "Ada Lovelace",
+		Email:     "Ada@Example.com",
+		Password:  "hash",
+		Avatar:    "avatars/ada.png",
+		Status:    user.UserStatusInactive,
+		Roles:     []user.Role{auditor, admin},
+		CreatedAt: base,
+		UpdatedAt: base.Add(time.Minute),
+	}
+	if err := r.Store(context.Background(), &want); err != nil {
+		t.Fatal(err)
+	}
+	got := find(t, r, want.Id)
+	if got.Name != want.Name || got.Email != want.Email || got.Password != want.Password ||
+		got.Avatar != want.Avatar || got.Status != want.Status {
+		t.Errorf("Find: %+v, want %+v", got, want)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("Find times %s %s, want %s %s", got.CreatedAt, got.UpdatedAt, want.CreatedAt, want.UpdatedAt)
+	}
+	if got.DeletedAt != nil {
+		t.Errorf("Find: DeletedAt %s, want nil", got.DeletedAt)
+	}
+	if ids := roleIds(got.Roles); !slices.Equal(ids, sortedIds(admin.Id, auditor.Id)) {
+		t.Errorf("Find roles %v, want %v sorted", ids, []string{admin.Id, auditor.Id})
+	}
+	for _, role := range got.Roles {
+		if role.Id == admin.Id {
+			equalRole(t, role, admin)
+		}
+	}
+
+	plain := newUser(t, r, "Alan Turing", "alan@example.com")
+	if got := find(t, r, plain.Id); got.Avatar != "" || len(got.Roles) != 0 {
+		t.Errorf("Find user without avatar nor roles: avatar %q, roles %v", got.Avatar, got.Roles)
+	}
+}
+
+func testFindUnknown(t *testing.T, r user.UserRepository) {
+	_, err := r.Find(context.Background(), core.NewID())
+	wantErr(t, "Find", err, user.ErrUserNotFound)
+	_, err = r.FindByEmail(context.Background(), "nobody@example.com")
+	wantErr(t, "FindByEmail", err, user.ErrUserNotFound)
+	_, err = r.FindRole(context.Background(), core.NewID())
+	wantErr(t, "FindRole", err, user.ErrRoleNotFound)
+	_, err = r.FindRoleByName(context.Background(), "nobody")
+	wantErr(t, "FindRoleByName", err, user.ErrRoleNotFound)
+	_, err = r.FindEmailChange(context.Background(), "unknown")
+	wantErr(t, "FindEmailChange", err, user.ErrEmailChangeNotFound)
+	roles, err := r.GetUserRoles(context.Background(), core.NewID())
+	if err != nil || len(roles) != 0 {
+		t.Errorf("GetUserRoles of unknown user: %v, %v", roles, err)
+	}
+}
+
+func testStoreDuplicateEmail(t *testing.T, r user.UserRepository) {
+	role := newRole(t, r, "admin")
+	first := newUser(t, r, "Ada", "ada@example.com")
+	dup := user.User{
+		Id:        core.NewID(),
+		Name:      "Ada again",
+		Email:     " ADA@example.COM",
+		Password:  "hash",
+		Status:    user.UserStatusActive,
+		Roles:     []user.Role{role},
+		CreatedAt: base,
+		UpdatedAt: base,
+	}
+	wantErr(t, "Store", r.Store(context.Background(), &dup), user.ErrUserAlreadyExists)
+	// Nothing of the rejected user is left behind.
+	_, err := r.Find(context.Background(), dup.Id)
+	wantErr(t, "Find rejected user", err, user.ErrUserNotFound)
+	if roles, _ := r.GetUserRoles(context.Background(), dup.Id); len(roles) != 0 {
+		t.Errorf("rejected user has roles %v", roles)
+	}
+	if got := find(t, r, first.Id); got.Name != "Ada" {
+		t.Errorf("first user changed: %+v", got)
+	}
+}
+
+func testFindByEmail(t *testing.T, r user.UserRepository) {
+	u := newUser(t, r, "Grace Hopper", "Grace.Hopper@Example.com")
+	for _, email := range []string{"Grace.Hopper@Example.com", "grace.hopper@example.com", "  GRACE.HOPPER@EXAMPLE.COM "} {
+		got, err := r.FindByEmail(context.Background(), email)
+		if err != nil {
+			t.Fatalf("FindByEmail(%q): %v", email, err)
+		}
+		if got.Id != u.Id || got.Email != u.Email {
+			t.Errorf("FindByEmail(%q) = %s %s, want %s %s", email, got.Id, got.Email, u.Id, u.Email)
+		}
+	}
+}
+
+func testUpdate(t *testing.T, r user.UserRepository) {
+	admin := newRole(t, r, "admin")
+	manager := newRole(t, r, "manager")
+	auditor := newRole(t, r, "auditor")
+	u := newUser(t, r, "Ada", "ada@example.com", admin, manager)
+
+	u.Name = "Ada Lovelace"
+	u.Avatar = "avatars/ada.png"
+	u.Status = user.UserStatusInactive
+	u.Roles = []user.Role{manager, auditor}
+	u.UpdatedAt = base.Add(time.Hour)
+	if err := r.Update(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	got := find(t, r, u.Id)
+	if got.Name != u.Name || got.Avatar != u.Avatar || got.Status != u.Status || !got.UpdatedAt.Equal(u.UpdatedAt) {
+		t.Errorf("Find after Update: %+v, want %+v", got, u)
+	}
+	if got.Email != "ada@example.com" || got.Password != "hash" {
+		t.Errorf("Update changed email or password: %s %s", got.Email, got.Password)
+	}
+	if ids := roleIds(got.Roles); !slices.Equal(ids, sortedIds(manager.Id, auditor.Id)) {
+		t.Errorf("roles after Update %v, want %v", ids, sortedIds(manager.Id, auditor.Id))
+	}
+
+	u.Roles = nil
+	if err := r.Update(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, r, u.Id); len(got.Roles) != 0 {
+		t.Errorf("roles after Update without roles: %v", got.Roles)
+	}
+}
+
+func testUpdateStatusDeleted(t *testing.T, r user.UserRepository) {
+	u := newUser(t, r, "Ada", "ada@example.com")
+	deletedAt := base.Add(time.Hour)
+	u.Status = user.UserStatusDeleted
+	u.UpdatedAt = deletedAt
+	if err := r.Update(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	_, err := r.Find(context.Background(), u.Id)
+	wantErr(t, "Find user updated as deleted", err, user.ErrUserNotFound)
+
+	// Updating a deleted user again keeps the time it was deleted.
+	u.UpdatedAt = base.Add(2 * time.Hour)
+	if err := r.Update(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := r.ListDeleted(context.Background(), base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].DeletedAt == nil || !deleted[0].DeletedAt.Equal(deletedAt) {
+		t.Fatalf("ListDeleted = %+v, want %s deleted at %s", deleted, u.Id, deletedAt)
+	}
+
+	u.Status = user.UserStatusActive
+	if err := r.Update(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, r, u.Id); got.DeletedAt != nil || got.Status != user.UserStatusActive {
+		t.Errorf("user updated as active: status %s, DeletedAt %v", got.Status, got.DeletedAt)
+	}
+}
+
+func testUpdatePassword(t *testing.T, r user.UserRepository) {
+	u := newUser(t, r, "Ada", "ada@example.com")
+	other := newUser(t, r, "Alan", "alan@example.com")
+	if err := r.UpdatePassword(context.Background(), u.Id, "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, r, u.Id); got.Password != "new-hash" {
+		t.Errorf("password %q, want new-hash", got.Password)
+	}
+	if got := find(t, r, other.Id); got.Password != "hash" {
+		t.Errorf("password of another user changed to %q", got.Password)
+	}
+}
+
+func testUpdateEmail(t *testing.T, r user.UserRepository) {
+	u := newUser(t, r, "Ada", "ada@example.com")
+	newUser(t, r, "Alan", "alan@example.com")
+	if err := r.UpdateEmail(context.Background(), u.Id, "Lovelace@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.FindByEmail(context.Background(), "lovelace@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != u.Id || got.Email != "Lovelace@Example.com" {
+		t.Errorf("FindByEmail new address = %s %s", got.Id, got.Email)
+	}
+	_, err = r.FindByEmail(context.Background(), "ada@example.com")
+	wantErr(t, "FindByEmail old address", err, user.ErrUserNotFound)
+
+	err = r.UpdateEmail(context.Background(), u.Id, "ALAN@example.com")
+	wantErr(t, "UpdateEmail to the address of another user", err, user.ErrEmailInUse)
+}
+
+func testDeleteAndRestore(t *testing.T, r user.UserRepository) {
+	u := newUser(t, r, "Ada", "ada@example.com")
+	if err := r.Delete(context.Background(), u.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err := r.Find(context.Background(), u.Id)
+	wantErr(t, "Find deleted user", err, user.ErrUserNotFound)
+	_, err = r.FindByEmail(context.Background(), u.Email)
+	wantErr(t, "FindByEmail deleted user", err, user.ErrUserNotFound)
+	wantErr(t, "Delete twice", r.Delete(context.Background(), u.Id), user.ErrUserNotFound)
+	wantErr(t, "Delete unknown", r.Delete(context.Background(), core.NewID()), user.ErrUserNotFound)
+
+	if err := r.Restore(context.Background(), u.Id); err != nil {
+		t.Fatal(err)
+	}
+	got := find(t, r, u.Id)
+	if got.Status != user.UserStatusActive || got.DeletedAt != nil {
+		t.Errorf("restored user: status %s, DeletedAt %v", got.Status, got.DeletedAt)
+	}
+	wantErr(t, "Restore active user", r.Restore(context.Background(), u.Id), user.ErrUserNotFound)
+	wantErr(t, "Restore unknown", r.Restore(context.Background(), core.NewID()), user.ErrUserNotFound)
+}
+
+func testListDeleted(t *testing.T, r user.UserRepository) {
+	role := newRole(t, r, "admin")
+	first := newUser(t, r, "Ada", "ada@example.com", role)
+	second := newUser(t, r, "Alan", "alan@example.com")
+	newUser(t, r, "Grace", "grace@example.com")
+	for _, u := range []user.User{first, second} {
+		if err := r.Delete(context.Background(), u.Id); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	deleted, err := r.ListDeleted(context.Background(), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := userIds(deleted); !slices.Equal(ids, []string{first.Id, second.Id}) {
+		t.Fatalf("ListDeleted = %v, want %v in order of deletion", ids, []string{first.Id, second.Id})
+	}
+	if deleted[0].DeletedAt == nil || deleted[0].Status != user.UserStatusDeleted {
+		t.Errorf("deleted user: status %s, DeletedAt %v", deleted[0].Status, deleted[0].DeletedAt)
+	}
+	if len(deleted[0].Roles) != 0 {
+		t.Errorf("ListDeleted loaded roles %v", deleted[0].Roles)
+	}
+
+	deleted, err = r.ListDeleted(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil || len(deleted) != 0 {
+		t.Errorf("ListDeleted before the deletes = %v, %v", userIds(deleted), err)
+	}
+}
+
+func testAnonymize(t *testing.T, r user.UserRepository) {
+	role := newRole(t, r, "admin")
+	u := newUser(t, r, "Ada Lovelace", "ada@example.com", role)
+	other := newUser(t, r, "Alan", "alan@example.com")
+	change := user.EmailChange{TokenHash: "token", UserId: u.Id, NewEmail: "new@example.com", CreatedAt: base, ExpiresAt: base.Add(time.Hour)}
+	if err := r.StoreEmailChange(context.Background(), &change); err != nil {
+		t.Fatal(err)
+	}
+	at := base.Add(time.Hour)
+	if err := r.Anonymize(context.Background(), u.Id, at); err != nil {
+		t.Fatal(err)
+	}
+	// A second user can be anonymized, the replacement emails differ.
+	if err := r.Anonymize(context.Background(), other.Id, at); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := r.Find(context.Background(), u.Id)
+	wantErr(t, "Find anonymized user", err, user.ErrUserNotFound)
+	_, err = r.FindByEmail(context.Background(), u.Email)
+	wantErr(t, "FindByEmail anonymized user", err, user.ErrUserNotFound)
+	_, err = r.FindEmailChange(context.Background(), change.TokenHash)
+	wantErr(t, "FindEmailChange of anonymized user", err, user.ErrEmailChangeNotFound)
+	if roles, err := r.GetUserRoles(context.Background(), u.Id); err != nil || len(roles) != 0 {
+		t.Errorf("roles of anonymized user: %v, %v", roles, err)
+	}
+	wantErr(t, "Restore anonymized user", r.Restore(context.Background(), u.Id), user.ErrUserNotFound)
+
+	deleted, err := r.ListDeleted(context.Background(), at.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *user.User
+	for i := range deleted {
+		if deleted[i].Id == u.Id {
+			got = &deleted[i]
+		}
+	}
+	if got == nil {
+		t.Fatalf("ListDeleted = %v, want %s", userIds(deleted), u.Id)
+	}
+	if got.Name == u.Name || got.Email == u.Email || got.Password != "" || got.Avatar != "" {
+		t.Errorf("anonymized user kept personal data: %+v", got)
+	}
+	if got.Status != user.UserStatusDeleted || got.DeletedAt == nil || !got.DeletedAt.Equal(at) {
+		t.Errorf("anonymized user: status %s, DeletedAt %v, want deleted at %s", got.Status, got.DeletedAt, at)
+	}
+}
+
+func testErase(t *testing.T, r user.UserRepository) {
+	role := newRole(t, r, "admin")
+	u := newUser(t, r, "Ada", "ada@example.com", role)
+	if err := r.Erase(context.Background(), u.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err := r.Find(context.Background(), u.Id)
+	wantErr(t, "Find erased user", err, user.ErrUserNotFound)
+	if roles, err := r.GetUserRoles(context.Background(), u.Id); err != nil || len(roles) != 0 {
+		t.Errorf("roles of erased user: %v, %v", roles, err)
+	}
+	if _, err := r.FindRole(context.Background(), role.Id); err != nil {
+		t.Errorf("FindRole after erasing a user: %v", err)
+	}
+	wantErr(t, "Erase twice", r.Erase(context.Background(), u.Id), user.ErrUserNotFound)
+	// The address can be used again.
+	newUser(t, r, "Ada", "ada@example.com")
+}
+
+func testEmailChanges(t *testing.T, r user.UserRepository) {
+	u := newUser(t, r, "Ada", "ada@example.com")
+	other := newUser(t, r, "Alan", "alan@example.com")
+	want := user.EmailChange{
+		TokenHash: "first",
+		UserId:    u.Id,
+		NewEmail:  "lovelace@example.com",
+		CreatedAt: base,
+		ExpiresAt: base.Add(time.Hour),
+	}
+	for _, c := range []user.EmailChange{
+		want,
+		{TokenHash: "second", UserId: u.Id, NewEmail: "ada@lovelace.com", CreatedAt: base, ExpiresAt: base.Add(time.Hour)},
+		{TokenHash: "other", UserId: other.Id, NewEmail: "turing@example.com", CreatedAt: base, ExpiresAt: base.Add(time.Hour)},
+	} {
+		if err := r.StoreEmailChange(context.Background(), &c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := r.FindEmailChange(context.Background(), want.TokenHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TokenHash != want.TokenHash || got.UserId != want.UserId || got.NewEmail != want.NewEmail ||
+		!got.CreatedAt.Equal(want.CreatedAt) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("FindEmailChange = %+v, want %+v", got, want)
+	}
+
+	if err := r.DeleteEmailChanges(context.Background(), u.Id); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{"first", "second"} {
+		_, err := r.FindEmailChange(context.Background(), hash)
+		wantErr(t, "FindEmailChange "+hash, err, user.ErrEmailChangeNotFound)
+	}
+	if _, err := r.FindEmailChange(context.Background(), "other"); err != nil {
+		t.Errorf("change of another user deleted: %v", err)
+	}
+}
+
+func testEmailKeys(t *testing.T, r user.UserRepository) {
+	ada := newUser(t, r, "Ada", "Ada@Example.com")
+	alan := newUser(t, r, "Alan", "alan@example.com")
+	keys, err := r.ListEmailKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []user.EmailKey{
+		{UserId: ada.Id, Email: ada.Email, Normalized: user.NormalizeEmail(ada.Email)},
+		{UserId: alan.Id, Email: alan.Email, Normalized: user.NormalizeEmail(alan.Email)},
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].UserId < want[j].UserId })
+	if !slices.Equal(keys, want) {
+		t.Fatalf("ListEmailKeys = %+v, want %+v", keys, want)
+	}
+
+	// Keys can move between users in a single call.
+	err = r.SetNormalizedEmails(context.Background(), map[string]string{
+		ada.Id:  user.NormalizeEmail(alan.Email),
+		alan.Id: user.NormalizeEmail(ada.Email),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.FindByEmail(context.Background(), alan.Email)
+	if err != nil || got.Id != ada.Id {
+		t.Errorf("FindByEmail after swapping keys = %v, %v, want %s", got, err, ada.Id)
+	}
+
+	if err := r.SetNormalizedEmails(context.Background(), map[string]string{ada.Id: ""}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.FindByEmail(context.Background(), alan.Email)
+	wantErr(t, "FindByEmail of a cleared key", err, user.ErrUserNotFound)
+	keys, err = r.ListEmailKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if k.UserId == ada.Id && k.Normalized != "" {
+			t.Errorf("cleared key listed as %q", k.Normalized)
+		}
+	}
+}
+
+func testListUsersPages(t *testing.T, r user.UserRepository) {
+	role := newRole(t, r, "admin")
+	var ids []string
+	for i := 0; i < 7; i++ {
+		u := newUser(t, r, fmt.Sprintf("User %d", i), fmt.Sprintf("user%d@example.com", i), role)
+		ids = append(ids, u.Id)
+	}
+	ids = sortedIds(ids...)
+
+	var listed []string
+	for page := 1; page <= 3; page++ {
+		res, err := r.ListUsers(context.Background(), user.ListRequest{Page: page, PageSize: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Total != 7 || res.LastPage != 3 || res.Page != page || res.PageSize != 3 {
+			t.Errorf("page %d: total %d, last page %d, page %d, size %d", page, res.Total, res.LastPage, res.Page, res.PageSize)
+		}
+		for _, u := range res.Users {
+			if len(u.Roles) != 1 || u.Roles[0].Id != role.Id {
+				t.Errorf("user %s listed with roles %v", u.Id, roleIds(u.Roles))
+			}
+		}
+		listed = append(listed, userIds(res.Users)...)
+	}
+	if !slices.Equal(listed, ids) {
+		t.Errorf("pages list %v, want %v", listed, ids)
+	}
+
+	res, err := r.ListUsers(context.Background(), user.ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Page != 1 || res.PageSize != 10 || len(res.Users) != 7 || res.NextCursor != "" {
+		t.Errorf("default page: page %d, size %d, %d users, cursor %q", res.Page, res.PageSize, len(res.Users), res.NextCursor)
+	}
+
+	res, err = r.ListUsers(context.Background(), user.ListRequest{Page: 1, PageSize: 3, SkipRoles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range res.Users {
+		if len(u.Roles) != 0 {
+			t.Errorf("SkipRoles listed roles %v", roleIds(u.Roles))
+		}
+	}
+}
+
+func testListUsersCursor(t *testing.T, r user.UserRepository) {
+	var ids []string
+	for i := 0; i < 5; i++ {
+		u := newUser(t, r, fmt.Sprintf("User %d", i), fmt.Sprintf("user%d@example.com", i))
+		ids = append(ids, u.Id)
+	}
+	for _, s := range []string{"", "-id", "email", "-created_at"} {
+		var listed []string
+		req := user.ListRequest{PageSize: 2, Sort: s}
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatalf("sort %q: cursor does not end", s)
+			}
+			res, err := r.ListUsers(context.Background(), req)
+			if err != nil {
+				t.Fatalf("sort %q: %v", s, err)
+			}
+			listed = append(listed, userIds(res.Users)...)
+			if res.NextCursor == "" {
+				break
+			}
+			req.Cursor = res.NextCursor
+		}
+		if !slices.Equal(sortedIds(listed...), sortedIds(ids...)) {
+			t.Errorf("sort %q: cursor pages list %v, want each of %v once", s, listed, ids)
+		}
+	}
+
+	_, err := r.ListUsers(context.Background(), user.ListRequest{Cursor: "not a cursor"})
+	wantErr(t, "ListUsers with an invalid cursor", err, core.ErrInvalidCursor)
+	_, err = r.ListUsers(context.Background(), user.ListRequest{Sort: "password"})
+	wantErr(t, "ListUsers sorted by an unknown column", err, core.ErrInvalidSort)
+}
+
+func testListUsersFilters(t *testing.T, r user.UserRepository) {
+	admin := newRole(t, r, "admin")
+	active := newUser(t, r, "Ada", "ada@example.com", admin)
+	inactive := newUser(t, r, "Alan", "alan@example.com")
+	inactive.Status = user.UserStatusInactive
+	if err := r.Update(context.Background(), &inactive); err != nil {
+		t.Fatal(err)
+	}
+	deleted := newUser(t, r, "Grace", "grace@example.com", admin)
+	if err := r.Delete(context.Background(), deleted.Id); err != nil {
+		t.Fatal(err)
+	}
+	late := user.User{
+		Id:        core.NewID(),
+		Name:      "Barbara",
+		Email:     "barbara@example.com",
+		Status:    user.UserStatusActive,
+		CreatedAt: base.Add(48 * time.Hour),
+		UpdatedAt: base.Add(48 * time.Hour),
+	}
+	if err := r.Store(context.Background(), &late); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  user.ListRequest
+		want []string
+	}{
+		{"not deleted", user.ListRequest{}, []string{active.Id, inactive.Id, late.Id}},
+		{"include deleted", user.ListRequest{IncludeDeleted: true}, []string{active.Id, inactive.Id, deleted.Id, late.Id}},
+		{"active", user.ListRequest{Status: user.UserStatusActive}, []string{active.Id, late.Id}},
+		{"inactive", user.ListRequest{Status: user.UserStatusInactive}, []string{inactive.Id}},
+		{"deleted", user.ListRequest{Status: user.UserStatusDeleted}, []string{deleted.Id}},
+		{"role", user.ListRequest{RoleId: admin.Id}, []string{active.Id}},
+		{"role include deleted", user.ListRequest{RoleId: admin.Id, IncludeDeleted: true}, []string{active.Id, deleted.Id}},
+		{"created from", user.ListRequest{CreatedFrom: base.Add(time.Hour)}, []string{late.Id}},
+		{"created to", user.ListRequest{CreatedTo: base.Add(time.Hour)}, []string{active.Id, inactive.Id}},
+		{"created range", user.ListRequest{CreatedFrom: base, CreatedTo: base.Add(72 * time.Hour)}, []string{active.Id, inactive.Id, late.Id}},
+	}
+	for _, tt := range tests {
+		res, err := r.ListUsers(context.Background(), tt.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := userIds(res.Users); !slices.Equal(got, sortedIds(tt.want...)) || res.Total != len(tt.want) {
+			t.Errorf("%s: listed %v (total %d), want %v", tt.name, got, res.Total, sortedIds(tt.want...))
+		}
+	}
+}
+
+func testListUsersSearch(t *testing.T, r user.UserRepository) {
+	ada := newUser(t, r, "Ada Lovelace", "ada@analytical.org")
+	alan := newUser(t, r, "Alan Turing", "alan.turing@bletchley.uk")
+	grace := newUser(t, r, "Grace Hopper", "grace@navy.mil")
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{"ada", []string{ada.Id}},
+		{"LOVE", []string{ada.Id}},
+		{"al", []string{alan.Id}},
+		{"a", []string{ada.Id, alan.Id}},
+		{"turing", []string{alan.Id}},
+		{"bletchley", []string{alan.Id}},
+		{"navy mil", []string{grace.Id}},
+		{"grace hopper", []string{grace.Id}},
+		{"grace turing", nil},
+		// Query syntax of the backend is matched as text.
+		{`"ada" OR *`, []string{ada.Id}},
+		{"nobody", nil},
+	}
+	for _, tt := range tests {
+		res, err := r.ListUsers(context.Background(), user.ListRequest{Search: tt.search})
+		if err != nil {
+			t.Fatalf("search %q: %v", tt.search, err)
+		}
+		if got := userIds(res.Users); !slices.Equal(sortedIds(got...), sortedIds(tt.want...)) || res.Total != len(tt.want) {
+			t.Errorf("search %q: listed %v (total %d), want %v", tt.search, got, res.Total, tt.want)
+		}
+	}
+
+	res, err := r.ListUsers(context.Background(), user.ListRequest{Search: "ada", Sort: "-email"})
+	if err != nil || len(res.Users) != 1 {
+		t.Errorf("sorted search: %v, %v", res, err)
+	}
+}
+
+func testListUsersSort(t *testing.T, r user.UserRepository) {
+	var users []user.User
+	for i, email := range []string{"c@example.com", "a@example.com", "b@example.com"} {
+		u := user.User{
+			Id:        core.NewID(),
+			Name:      email,
+			Email:     email,
+			Status:    user.UserStatusActive,
+			CreatedAt: base.Add(time.Duration(i) * time.Hour),
+			UpdatedAt: base,
+		}
+		if err := r.Store(context.Background(), &u); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	c, a, b := users[0].Id, users[1].Id, users[2].Id
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"email", []string{a, b, c}},
+		{"-email", []string{c, b, a}},
+		{"created_at", []string{c, a, b}},
+		{"-created_at", []string{b, a, c}},
+		{"id", sortedIds(a, b, c)},
+	}
+	for _, tt := range tests {
+		res, err := r.ListUsers(context.Background(), user.ListRequest{Sort: tt.sort})
+		if err != nil {
+			t.Fatalf("sort %q: %v", tt.sort, err)
+		}
+		if got := userIds(res.Users); !slices.Equal(got, tt.want) {
+			t.Errorf("sort %q: listed %v, want %v", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func testStoreAndFindRole(t *testing.T, r user.UserRepository) {
+	want := newRole(t, r, "manager", user.PermissionUsersRead, user.PermissionUsersManage)
+	got, err := r.FindRole(context.Background(), want.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalRole(t, *got, want)
+	got, err = r.FindRoleByName(context.Background(), "manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalRole(t, *got, want)
+
+	empty := newRole(t, r, "empty")
+	got, err = r.FindRole(context.Background(), empty.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Permissions == nil || len(got.Permissions) != 0 {
+		t.Errorf("permissions of a role without any: %#v, want an empty slice", got.Permissions)
+	}
+}
+
+func testRoleNames(t *testing.T, r user.UserRepository) {
+	newRole(t, r, "admin")
+	other := newRole(t, r, "auditor")
+	dup := user.Role{Id: core.NewID(), Name: "admin", Permissions: []string{}, CreatedAt: base, UpdatedAt: base}
+	wantErr(t, "StoreRole with a taken name", r.StoreRole(context.Background(), &dup), user.ErrRoleAlreadyExists)
+	other.Name = "admin"
+	wantErr(t, "UpdateRole to a taken name", r.UpdateRole(context.Background(), &other), user.ErrRoleAlreadyExists)
+}
+
+func testUpdateRole(t *testing.T, r user.UserRepository) {
+	role := newRole(t, r, "manager", user.PermissionUsersRead)
+	role.Name = "supervisor"
+	role.Description = "Supervises users"
+	role.Permissions = []string{user.PermissionUsersRead, user.PermissionUsersManage}
+	role.UpdatedAt = base.Add(time.Hour)
+	if err := r.UpdateRole(context.Background(), &role); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.FindRole(context.Background(), role.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalRole(t, *got, role)
+	_, err = r.FindRoleByName(context.Background(), "manager")
+	wantErr(t, "FindRoleByName old name", err, user.ErrRoleNotFound)
+}
+
+func testDeleteRole(t *testing.T, r user.UserRepository) {
+	admin := newRole(t, r, "admin")
+	auditor := newRole(t, r, "auditor")
+	u := newUser(t, r, "Ada", "ada@example.com", admin, auditor)
+	if err := r.DeleteRole(context.Background(), admin.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err := r.FindRole(context.Background(), admin.Id)
+	wantErr(t, "FindRole deleted role", err, user.ErrRoleNotFound)
+	roles, err := r.GetUserRoles(context.Background(), u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := roleIds(roles); !slices.Equal(ids, []string{auditor.Id}) {
+		t.Errorf("roles after deleting one = %v, want %v", ids, []string{auditor.Id})
+	}
+	if err := r.DeleteRole(context.Background(), core.NewID()); err != nil {
+		t.Errorf("DeleteRole unknown: %v", err)
+	}
+}
+
+func testFindRoles(t *testing.T, r user.UserRepository) {
+	a := newRole(t, r, "a")
+	b := newRole(t, r, "b")
+	newRole(t, r, "c")
+
+	roles, err := r.FindRoles(context.Background(), nil)
+	if err != nil || roles == nil || len(roles) != 0 {
+		t.Errorf("FindRoles without ids = %#v, %v, want an empty slice", roles, err)
+	}
+	roles, err = r.FindRoles(context.Background(), []string{b.Id, core.NewID(), a.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := roleIds(roles); !slices.Equal(ids, sortedIds(a.Id, b.Id)) {
+		t.Errorf("FindRoles = %v, want %v", ids, sortedIds(a.Id, b.Id))
+	}
+	for _, role := range roles {
+		if role.Id == a.Id {
+			equalRole(t, role, a)
+		}
+	}
+}
+
+func testListRoles(t *testing.T, r user.UserRepository) {
+	admin := newRole(t, r, "admin")
+	manager := newRole(t, r, "manager")
+	auditor := user.Role{
+		Id:          core.NewID(),
+		Name:        "auditor",
+		Description: "Reads the AUDIT log",
+		Permissions: []string{},
+		CreatedAt:   base.Add(48 * time.Hour),
+		UpdatedAt:   base,
+	}
+	if err := r.StoreRole(context.Background(), &auditor); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  user.ListRequest
+		want []string
+	}{
+		{"all", user.ListRequest{}, sortedIds(admin.Id, manager.Id, auditor.Id)},
+		{"by name", user.ListRequest{Sort: "name"}, []string{admin.Id, auditor.Id, manager.Id}},
+		{"by name descending", user.ListRequest{Sort: "-name"}, []string{manager.Id, auditor.Id, admin.Id}},
+		{"search name", user.ListRequest{Search: "MAN"}, []string{manager.Id}},
+		{"search description", user.ListRequest{Search: "audit log"}, []string{auditor.Id}},
+		{"created from", user.ListRequest{CreatedFrom: base.Add(time.Hour)}, []string{auditor.Id}},
+		{"page", user.ListRequest{Sort: "name", Page: 2, PageSize: 2}, []string{manager.Id}},
+	}
+	for _, tt := range tests {
+		res, err := r.ListRoles(context.Background(), tt.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := roleIds(res.Roles); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: listed %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	res, err := r.ListRoles(context.Background(), user.ListRequest{Sort: "name", PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 3 || res.LastPage != 2 || res.NextCursor == "" {
+		t.Fatalf("first page: total %d, last page %d, cursor %q", res.Total, res.LastPage, res.NextCursor)
+	}
+	res, err = r.ListRoles(context.Background(), user.ListRequest{Sort: "name", PageSize: 2, Cursor: res.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := roleIds(res.Roles); !slices.Equal(got, []string{manager.Id}) || res.NextCursor != "" {
+		t.Errorf("page after cursor: %v, cursor %q", got, res.NextCursor)
+	}
+	_, err = r.ListRoles(context.Background(), user.ListRequest{Sort: "description"})
+	wantErr(t, "ListRoles sorted by an unknown column", err, core.ErrInvalidSort)
+}
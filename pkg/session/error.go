@@ -11,3 +11,9 @@ var ErrUserUnauthorized = errors.New("unauthorized")
 var ErrInvalidSession = errors.New("invalid session")
 
 var ErrUserForbidden = errors.New("you don't have permission to access this resource")
+
+var ErrRememberTokenNotFound = errors.New("remember token not found")
+
+var ErrRememberTokenTheft = errors.New("remember token reused, possible theft")
+
+var ErrRememberTokenUsed = errors.New("remember token already used")
@@ -0,0 +1,92 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"app/internal/core"
+)
+
+type SessionRepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewPostgresRepository(db *sql.DB) *SessionRepositoryPostgres {
+	return &SessionRepositoryPostgres{
+		db: db,
+	}
+}
+
+func (r *SessionRepositoryPostgres) Get(ctx context.Context, id string) (*Session, error) {
+	query := `SELECT id, user_id, data, created_at, expires_at FROM sessions WHERE id = $1 AND expires_at > $2`
+	return scanSessionRow(core.Conn(ctx, r.db).QueryRowContext(ctx, query, id, time.Now().UTC()))
+}
+
+func (r *SessionRepositoryPostgres) GetExpired(ctx context.Context) ([]Session, error) {
+	query := "SELECT id, user_id, data, created_at, expires_at FROM sessions WHERE expires_at < $1"
+	return r.list(ctx, query, time.Now().UTC())
+}
+
+func (r *SessionRepositoryPostgres) ListByUser(ctx context.Context, userId string) ([]Session, error) {
+	query := "SELECT id, user_id, data, created_at, expires_at FROM sessions WHERE user_id = $1 AND expires_at > $2 ORDER BY created_at"
+	return r.list(ctx, query, userId, time.Now().UTC())
+}
+
+func (r *SessionRepositoryPostgres) list(ctx context.Context, query string, args ...any) ([]Session, error) {
+	rows, err := core.Conn(ctx, r.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSessionRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *SessionRepositoryPostgres) Set(ctx context.Context, session *Session) error {
+	dataJson, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	_, err = core.Conn(ctx, r.db).ExecContext(ctx, `
+		INSERT INTO sessions (id, user_id, data, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET
+			user_id = excluded.user_id,
+			data = excluded.data,
+			created_at = excluded.created_at,
+			expires_at = excluded.expires_at`,
+		session.Id,
+		session.UserId,
+		string(dataJson),
+		session.CreatedAt,
+		session.ExpiresAt,
+	)
+	return err
+}
+
+func (r *SessionRepositoryPostgres) Delete(ctx context.Context, id string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, id)
+	return err
+}
+
+func (r *SessionRepositoryPostgres) DeleteByUser(ctx context.Context, userId, exceptId string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1 AND id != $2`, userId, exceptId)
+	return err
+}
+
+func (r *SessionRepositoryPostgres) GC(ctx context.Context) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= $1`, time.Now().UTC())
+	return err
+}
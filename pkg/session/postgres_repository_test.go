@@ -0,0 +1,21 @@
+package session_test
+
+import (
+	"testing"
+
+	"app/internal/db/dbtest"
+	"app/pkg/session"
+	"app/pkg/session/sessiontest"
+)
+
+func TestSessionRepositoryPostgres(t *testing.T) {
+	sessiontest.RunRepositoryTests(t, func(t *testing.T) session.SessionRepository {
+		return session.NewPostgresRepository(dbtest.Postgres(t))
+	})
+}
+
+func TestRememberRepositoryPostgres(t *testing.T) {
+	sessiontest.RunRememberRepositoryTests(t, func(t *testing.T) session.RememberRepository {
+		return session.NewRememberPostgresRepository(dbtest.Postgres(t))
+	})
+}
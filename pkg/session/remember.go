@@ -0,0 +1,231 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rememberGracePeriod is how long a rotated token is tolerated without being
+// treated as stolen, so concurrent requests sent with the previous cookie do
+// not log the user out.
+const rememberGracePeriod = 30 * time.Second
+
+// RememberToken is a long lived login token stored in its own cookie as
+// "selector:validator". Only a hash of the validator is stored. Every use
+// rotates the token within its series; presenting an already rotated token
+// means the cookie was copied, and the whole series is revoked.
+type RememberToken struct {
+	Selector      string
+	Series        string
+	UserId        string
+	ValidatorHash string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        time.Time
+}
+
+// RememberRepository stores remember-me tokens, in the transaction of ctx if
+// any.
+type RememberRepository interface {
+	Get(ctx context.Context, selector string) (*RememberToken, error)
+	Store(ctx context.Context, token *RememberToken) error
+	// MarkUsed marks an unused token as used. It returns
+	// ErrRememberTokenUsed when the token was used or deleted since it was
+	// read, so that only one request rotates it.
+	MarkUsed(ctx context.Context, selector string, at time.Time) error
+	DeleteSeries(ctx context.Context, series string) error
+	DeleteByUser(ctx context.Context, userId string) error
+	GC(ctx context.Context) error
+}
+
+type RememberOptions struct {
+	Lifetime   time.Duration
+	Cookie     *CookieConfig
+	Repository RememberRepository
+	// Allow reports whether the user may still log in, for example whether
+	// the account is active. Tokens of other users are revoked instead of
+	// restoring a session. Every user is allowed when nil.
+	Allow func(ctx context.Context, userId string) bool
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashValidator(validator string) string {
+	sum := sha256.Sum256([]byte(validator))
+	return hex.EncodeToString(sum[:])
+}
+
+// Remember issues a new remember-me token series for the user.
+func (m *Manager) Remember(ctx context.Context, w http.ResponseWriter, userId string) error {
+	if m.remember == nil {
+		return nil
+	}
+	series, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	return m.issueRememberToken(ctx, w, userId, series, now.Add(m.remember.Lifetime))
+}
+
+func (m *Manager) issueRememberToken(ctx context.Context, w http.ResponseWriter, userId, series string, expiresAt time.Time) error {
+	selector, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	validator, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	err = m.remember.Repository.Store(ctx, &RememberToken{
+		Selector:      selector,
+		Series:        series,
+		UserId:        userId,
+		ValidatorHash: hashValidator(validator),
+		CreatedAt:     time.Now().UTC(),
+		ExpiresAt:     expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	c := m.remember.Cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.Name,
+		Value:    selector + ":" + validator,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Expires:  expiresAt,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: c.SameSite,
+	})
+	return nil
+}
+
+// Forget revokes the remember-me series of the request and clears its cookie.
+func (m *Manager) Forget(w http.ResponseWriter, r *http.Request) error {
+	if m.remember == nil {
+		return nil
+	}
+	cookie, err := r.Cookie(m.remember.Cookie.Name)
+	if err != nil {
+		return nil
+	}
+	m.clearRememberCookie(w)
+	selector, _, _ := strings.Cut(cookie.Value, ":")
+	t, err := m.remember.Repository.Get(r.Context(), selector)
+	if errors.Is(err, ErrRememberTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return m.remember.Repository.DeleteSeries(r.Context(), t.Series)
+}
+
+// restore creates a new session from the remember-me cookie of the request,
+// rotating the token. It returns nil when there is nothing to restore.
+func (m *Manager) restore(w http.ResponseWriter, r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.remember.Cookie.Name)
+	if err != nil {
+		return nil, nil
+	}
+	selector, validator, ok := strings.Cut(cookie.Value, ":")
+	if !ok {
+		m.clearRememberCookie(w)
+		return nil, nil
+	}
+	ctx := r.Context()
+	repo := m.remember.Repository
+	t, err := repo.Get(ctx, selector)
+	if errors.Is(err, ErrRememberTokenNotFound) {
+		m.clearRememberCookie(w)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	valid := subtle.ConstantTimeCompare([]byte(hashValidator(validator)), []byte(t.ValidatorHash)) == 1
+	if valid && !t.UsedAt.IsZero() && now.Sub(t.UsedAt) < rememberGracePeriod {
+		return nil, nil
+	}
+	if !valid || !t.UsedAt.IsZero() {
+		m.clearRememberCookie(w)
+		if err := repo.DeleteSeries(ctx, t.Series); err != nil {
+			return nil, err
+		}
+		if m.hooks.RememberTheft != nil {
+			m.hooks.RememberTheft(ctx, t.UserId)
+		}
+		return nil, ErrRememberTokenTheft
+	}
+	if now.After(t.ExpiresAt) {
+		m.clearRememberCookie(w)
+		return nil, repo.DeleteSeries(ctx, t.Series)
+	}
+
+	if allow := m.remember.Allow; allow != nil && !allow(ctx, t.UserId) {
+		m.clearRememberCookie(w)
+		return nil, repo.DeleteSeries(ctx, t.Series)
+	}
+
+	// Concurrent requests with the same cookie all get here, only the one
+	// marking the token gets a session. The others are a reuse within the
+	// grace period.
+	err = repo.MarkUsed(ctx, t.Selector, now)
+	if errors.Is(err, ErrRememberTokenUsed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.issueRememberToken(ctx, w, t.UserId, t.Series, t.ExpiresAt); err != nil {
+		return nil, err
+	}
+	session, err := m.create(ctx, t.UserId, map[string]any{rememberedKey: true})
+	if err != nil {
+		return nil, err
+	}
+	m.SetCookie(w, session.Id)
+	return session, nil
+}
+
+func (m *Manager) clearRememberCookie(w http.ResponseWriter) {
+	c := m.remember.Cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.Name,
+		Value:    "",
+		Path:     c.Path,
+		Domain:   c.Domain,
+		MaxAge:   -1,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: c.SameSite,
+	})
+}
+
+func (m *Manager) gcRememberTokens() {
+	if m.remember == nil {
+		return
+	}
+	if err := m.remember.Repository.GC(context.Background()); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"app/internal/core"
+)
+
+type RememberRepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewRememberPostgresRepository(db *sql.DB) *RememberRepositoryPostgres {
+	return &RememberRepositoryPostgres{
+		db: db,
+	}
+}
+
+func (r *RememberRepositoryPostgres) Get(ctx context.Context, selector string) (*RememberToken, error) {
+	query := `SELECT selector, series, user_id, validator_hash, created_at, expires_at, used_at
+		FROM remember_tokens WHERE selector = $1`
+	return scanTokenRow(core.Conn(ctx, r.db).QueryRowContext(ctx, query, selector))
+}
+
+func (r *RememberRepositoryPostgres) Store(ctx context.Context, token *RememberToken) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `
+		INSERT INTO remember_tokens
+		(selector, series, user_id, validator_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		token.Selector,
+		token.Series,
+		token.UserId,
+		token.ValidatorHash,
+		token.CreatedAt,
+		token.ExpiresAt,
+	)
+	return err
+}
+
+func (r *RememberRepositoryPostgres) MarkUsed(ctx context.Context, selector string, at time.Time) error {
+	res, err := core.Conn(ctx, r.db).ExecContext(ctx, `UPDATE remember_tokens SET used_at = $1 WHERE selector = $2 AND used_at IS NULL`, at, selector)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRememberTokenUsed
+	}
+	return nil
+}
+
+func (r *RememberRepositoryPostgres) DeleteSeries(ctx context.Context, series string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM remember_tokens WHERE series = $1`, series)
+	return err
+}
+
+func (r *RememberRepositoryPostgres) DeleteByUser(ctx context.Context, userId string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM remember_tokens WHERE user_id = $1`, userId)
+	return err
+}
+
+func (r *RememberRepositoryPostgres) GC(ctx context.Context) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM remember_tokens WHERE expires_at <= $1`, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,92 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"app/internal/core"
+)
+
+type RememberRepositorySqlite struct {
+	db *sql.DB
+}
+
+func NewRememberSqliteRepository(db *sql.DB) *RememberRepositorySqlite {
+	return &RememberRepositorySqlite{
+		db: db,
+	}
+}
+
+func scanTokenRow(row rowscan) (*RememberToken, error) {
+	var t RememberToken
+	var usedAt sql.NullTime
+	err := row.Scan(
+		&t.Selector,
+		&t.Series,
+		&t.UserId,
+		&t.ValidatorHash,
+		&t.CreatedAt,
+		&t.ExpiresAt,
+		&usedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRememberTokenNotFound
+		}
+		return nil, err
+	}
+	t.UsedAt = usedAt.Time
+	return &t, nil
+}
+
+func (r *RememberRepositorySqlite) Get(ctx context.Context, selector string) (*RememberToken, error) {
+	query := `SELECT selector, series, user_id, validator_hash, created_at, expires_at, used_at
+		FROM remember_tokens WHERE selector = ?`
+	return scanTokenRow(core.Conn(ctx, r.db).QueryRowContext(ctx, query, selector))
+}
+
+func (r *RememberRepositorySqlite) Store(ctx context.Context, token *RememberToken) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `
+		INSERT INTO remember_tokens
+		(selector, series, user_id, validator_hash, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		token.Selector,
+		token.Series,
+		token.UserId,
+		token.ValidatorHash,
+		token.CreatedAt,
+		token.ExpiresAt,
+	)
+	return err
+}
+
+func (r *RememberRepositorySqlite) MarkUsed(ctx context.Context, selector string, at time.Time) error {
+	res, err := core.Conn(ctx, r.db).ExecContext(ctx, `UPDATE remember_tokens SET used_at = ? WHERE selector = ? AND used_at IS NULL`, at, selector)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRememberTokenUsed
+	}
+	return nil
+}
+
+func (r *RememberRepositorySqlite) DeleteSeries(ctx context.Context, series string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM remember_tokens WHERE series = ?`, series)
+	return err
+}
+
+func (r *RememberRepositorySqlite) DeleteByUser(ctx context.Context, userId string) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM remember_tokens WHERE user_id = ?`, userId)
+	return err
+}
+
+func (r *RememberRepositorySqlite) GC(ctx context.Context) error {
+	_, err := core.Conn(ctx, r.db).ExecContext(ctx, `DELETE FROM remember_tokens WHERE expires_at <= ?`, time.Now().UTC())
+	return err
+}
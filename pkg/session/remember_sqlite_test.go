@@ -0,0 +1,225 @@
+//go:build sqlite_fts5
+
+package session_test
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"app/internal/db/dbtest"
+	"app/pkg/session"
+)
+
+type rememberFixture struct {
+	db      *sql.DB
+	manager *session.Manager
+	// thefts lists the users RememberTheft was called for.
+	thefts []string
+}
+
+func newRememberFixture(t *testing.T, wrap func(session.RememberRepository) session.RememberRepository) *rememberFixture {
+	f := &rememberFixture{db: dbtest.Sqlite(t)}
+	var remember session.RememberRepository = session.NewRememberSqliteRepository(f.db)
+	if wrap != nil {
+		remember = wrap(remember)
+	}
+	f.manager = session.New(&session.Options{
+		Lifetime:   time.Hour,
+		Repository: session.NewSqliteRepository(f.db),
+		SecretKey:  []byte("test"),
+		Remember: &session.RememberOptions{
+			Lifetime:   24 * time.Hour,
+			Repository: remember,
+		},
+		Hooks: session.Hooks{
+			RememberTheft: func(ctx context.Context, userId string) {
+				f.thefts = append(f.thefts, userId)
+			},
+		},
+	})
+	return f
+}
+
+// remember issues a remember-me series for the user and returns its cookie.
+func (f *rememberFixture) remember(t *testing.T, userId string) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := f.manager.Remember(context.Background(), w, userId); err != nil {
+		t.Fatal(err)
+	}
+	return rememberCookie(t, w)
+}
+
+func rememberCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "remember_token" {
+			return c
+		}
+	}
+	return nil
+}
+
+// restore sends a request carrying only the remember-me cookie, and returns
+// the session it was given, if any, and the remember-me cookie of the
+// response.
+func (f *rememberFixture) restore(t *testing.T, cookie *http.Cookie) (*session.Session, *http.Cookie) {
+	t.Helper()
+	var got *session.Session
+	h := f.manager.SetSessionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = f.manager.GetSession(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return got, rememberCookie(t, w)
+}
+
+// age moves the use of every used token back by d.
+func (f *rememberFixture) age(t *testing.T, d time.Duration) {
+	t.Helper()
+	if _, err := f.db.Exec("UPDATE remember_tokens SET used_at = ? WHERE used_at IS NOT NULL", time.Now().UTC().Add(-d)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRememberRotatesToken(t *testing.T) {
+	f := newRememberFixture(t, nil)
+	first := f.remember(t, "user")
+	s, second := f.restore(t, first)
+	if s == nil || s.UserId != "user" {
+		t.Fatalf("restored session %+v, want one of user", s)
+	}
+	if s.AuthenticatedWithin(time.Hour) {
+		t.Error("a restored session counts as a recent login")
+	}
+	if second == nil || second.Value == first.Value || second.MaxAge < 0 {
+		t.Fatalf("cookie after restoring %+v, want a new token", second)
+	}
+	if s, _ := f.restore(t, second); s == nil {
+		t.Error("the rotated token did not restore a session")
+	}
+}
+
+func TestRememberGracePeriod(t *testing.T) {
+	f := newRememberFixture(t, nil)
+	first := f.remember(t, "user")
+	_, second := f.restore(t, first)
+	// A request sent with the previous cookie right after the rotation gets
+	// no session, but the series is left alone.
+	if s, _ := f.restore(t, first); s != nil {
+		t.Errorf("the rotated token restored a session within the grace period")
+	}
+	if len(f.thefts) != 0 {
+		t.Errorf("theft reported within the grace period for %v", f.thefts)
+	}
+	if s, _ := f.restore(t, second); s == nil {
+		t.Error("the series was revoked within the grace period")
+	}
+}
+
+func TestRememberTheftRevokesSeries(t *testing.T) {
+	f := newRememberFixture(t, nil)
+	first := f.remember(t, "user")
+	_, second := f.restore(t, first)
+	other := f.remember(t, "other")
+	f.age(t, time.Minute)
+
+	s, cleared := f.restore(t, first)
+	if s != nil {
+		t.Errorf("the rotated token restored a session after the grace period")
+	}
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Errorf("cookie after a theft %+v, want it cleared", cleared)
+	}
+	if len(f.thefts) != 1 || f.thefts[0] != "user" {
+		t.Errorf("theft reported for %v, want [user]", f.thefts)
+	}
+	if s, _ := f.restore(t, second); s != nil {
+		t.Error("the latest token of a stolen series still restores a session")
+	}
+	if s, _ := f.restore(t, other); s == nil {
+		t.Error("a theft revoked the series of another user")
+	}
+}
+
+func TestRememberWrongValidatorRevokesSeries(t *testing.T) {
+	f := newRememberFixture(t, nil)
+	cookie := f.remember(t, "user")
+	forged := *cookie
+	forged.Value = cookie.Value[:len(cookie.Value)-1] + "x"
+	if cookie.Value == forged.Value {
+		forged.Value = cookie.Value[:len(cookie.Value)-1] + "y"
+	}
+	if s, _ := f.restore(t, &forged); s != nil {
+		t.Error("a forged validator restored a session")
+	}
+	if s, _ := f.restore(t, cookie); s != nil {
+		t.Error("the series survived a forged validator")
+	}
+}
+
+func TestRememberExpiredToken(t *testing.T) {
+	f := newRememberFixture(t, nil)
+	cookie := f.remember(t, "user")
+	if _, err := f.db.Exec("UPDATE remember_tokens SET expires_at = ?", time.Now().UTC().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	s, cleared := f.restore(t, cookie)
+	if s != nil {
+		t.Error("an expired token restored a session")
+	}
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Errorf("cookie of an expired token %+v, want it cleared", cleared)
+	}
+	if len(f.thefts) != 0 {
+		t.Errorf("theft reported for an expired token: %v", f.thefts)
+	}
+}
+
+// readTogether holds every Get until all the requests of wg have read the
+// token, so that they all see it unused.
+type readTogether struct {
+	session.RememberRepository
+	wg *sync.WaitGroup
+}
+
+func (r readTogether) Get(ctx context.Context, selector string) (*session.RememberToken, error) {
+	t, err := r.RememberRepository.Get(ctx, selector)
+	r.wg.Done()
+	r.wg.Wait()
+	return t, err
+}
+
+func TestRememberConcurrentUseRestoresOnce(t *testing.T) {
+	const requests = 2
+	var wg sync.WaitGroup
+	wg.Add(requests)
+	f := newRememberFixture(t, func(r session.RememberRepository) session.RememberRepository {
+		return readTogether{r, &wg}
+	})
+	cookie := f.remember(t, "user")
+
+	sessions := make(chan *session.Session, requests)
+	for range requests {
+		go func() {
+			s, _ := f.restore(t, cookie)
+			sessions <- s
+		}()
+	}
+	var restored int
+	for range requests {
+		if s := <-sessions; s != nil {
+			restored++
+		}
+	}
+	if restored != 1 {
+		t.Errorf("%d concurrent requests with the same token got a session, want 1", restored)
+	}
+}
@@ -26,15 +26,29 @@ type Session struct {
 	ExpiresAt time.Time
 }
 
+// rememberedKey marks the data of sessions restored from a remember-me
+// cookie rather than created by a login.
+const rememberedKey = "remembered"
+
+// AuthenticatedWithin reports whether the session was created by a login,
+// not restored from a remember-me cookie, less than d ago.
+func (s *Session) AuthenticatedWithin(d time.Duration) bool {
+	if remembered, _ := s.Data[rememberedKey].(bool); remembered {
+		return false
+	}
+	return time.Since(s.CreatedAt) < d
+}
+
 type Manager struct {
 	sessions   map[string]*Session
 	mu         sync.RWMutex
 	lifetime   time.Duration
-	lifetimeExtended time.Duration
 	cookie     *CookieConfig
+	remember   *RememberOptions
 	repository SessionRepository
 	gcInterval time.Duration
 	secretKey  []byte
+	hooks      Hooks
 }
 
 type CookieConfig struct {
@@ -47,12 +61,19 @@ type CookieConfig struct {
 	SameSite http.SameSite
 }
 
+// SessionRepository stores sessions. Its methods take part in the transaction
+// of ctx, if any, so that sessions can be created or revoked in the same unit
+// of work as the change that calls for it.
 type SessionRepository interface {
-	Get(id string) (*Session, error)
-	Set(session *Session) error
-	Delete(id string) error
-	GC() error
-	GetExpired() ([]Session, error)
+	// Get returns ErrSessionNotFound for unknown and expired sessions.
+	Get(ctx context.Context, id string) (*Session, error)
+	Set(ctx context.Context, session *Session) error
+	Delete(ctx context.Context, id string) error
+	// DeleteByUser deletes every session of the user except exceptId.
+	DeleteByUser(ctx context.Context, userId, exceptId string) error
+	ListByUser(ctx context.Context, userId string) ([]Session, error)
+	GC(ctx context.Context) error
+	GetExpired(ctx context.Context) ([]Session, error)
 }
 
 type Options struct {
@@ -61,6 +82,24 @@ type Options struct {
 	Repository SessionRepository
 	GCInterval time.Duration
 	SecretKey  []byte
+	Remember   *RememberOptions
+	Hooks      Hooks
+}
+
+// Hooks are called after session events, for example to audit them. Any of
+// them may be nil.
+type Hooks struct {
+	// Created is called for every new session, including the ones restored
+	// from a remember-me token.
+	Created func(ctx context.Context, s *Session)
+	// Destroyed is called when a session is ended on purpose, not when it
+	// expires.
+	Destroyed func(ctx context.Context, s *Session)
+	// RevokedOthers is called when every other session of a user is ended.
+	RevokedOthers func(ctx context.Context, userId string)
+	// RememberTheft is called when a rotated remember-me token is presented
+	// again, which means the cookie was copied.
+	RememberTheft func(ctx context.Context, userId string)
 }
 
 func New(opts *Options) *Manager {
@@ -74,6 +113,15 @@ func New(opts *Options) *Manager {
 			SameSite: http.SameSiteLaxMode,
 		}
 	}
+	if opts.Remember != nil && opts.Remember.Cookie == nil {
+		opts.Remember.Cookie = &CookieConfig{
+			Name:     "remember_token",
+			Path:     "/",
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		}
+	}
 	m := &Manager{
 		sessions:   make(map[string]*Session),
 		lifetime:   opts.Lifetime,
@@ -81,6 +129,8 @@ func New(opts *Options) *Manager {
 		repository: opts.Repository,
 		gcInterval: opts.GCInterval,
 		secretKey:  opts.SecretKey,
+		hooks:      opts.Hooks,
+		remember:   opts.Remember,
 	}
 	m.RunGC()
 	return m
@@ -111,39 +161,44 @@ func (m *Manager) verifySessionId(signedId string) (string, bool) {
 	return sessionId, hmac.Equal([]byte(expectedSignature), []byte(signedId))
 }
 
-func (m *Manager) Create(ctx context.Context, userId string, extended bool) (*Session, error) {
+func (m *Manager) Create(ctx context.Context, userId string) (*Session, error) {
+	return m.create(ctx, userId, make(map[string]any))
+}
+
+func (m *Manager) create(ctx context.Context, userId string, data map[string]any) (*Session, error) {
 	id, err := m.generateSessionId()
 	if err != nil {
 		return nil, err
 	}
 
 	now := time.Now().UTC()
-	expiresAt := now.Add(m.lifetime)
-	if extended {
-		expiresAt = now.Add(m.lifetimeExtended)
-	}
 	session := &Session{
 		Id:        id,
 		UserId:    userId,
-		Data:      make(map[string]any),
+		Data:      data,
 		CreatedAt: now,
-		ExpiresAt: expiresAt,
+		ExpiresAt: now.Add(m.lifetime),
 	}
 
-	m.mu.Lock()
-	m.sessions[id] = session
-	m.mu.Unlock()
-
+	// With a repository the session is cached once read back, the
+	// transaction of ctx may still roll it back.
 	if m.repository != nil {
-		if err := m.repository.Set(session); err != nil {
+		if err := m.repository.Set(ctx, session); err != nil {
 			return nil, err
 		}
+	} else {
+		m.mu.Lock()
+		m.sessions[id] = session
+		m.mu.Unlock()
 	}
 
+	if m.hooks.Created != nil {
+		m.hooks.Created(ctx, session)
+	}
 	return session, nil
 }
 
-func (m *Manager) Get(id string) (*Session, error) {
+func (m *Manager) Get(ctx context.Context, id string) (*Session, error) {
 	id, valid := m.verifySessionId(id)
 	if !valid {
 		return nil, ErrInvalidSession
@@ -154,7 +209,7 @@ func (m *Manager) Get(id string) (*Session, error) {
 
 	if !exists && m.repository != nil {
 		var err error
-		session, err = m.repository.Get(id)
+		session, err = m.repository.Get(ctx, id)
 		if err != nil {
 			return nil, err
 		}
@@ -168,36 +223,101 @@ func (m *Manager) Get(id string) (*Session, error) {
 	}
 
 	if time.Now().UTC().After(session.ExpiresAt) {
-		m.Destroy(id)
+		m.destroy(ctx, id)
 		return nil, ErrSessionExpired
 	}
 
 	return session, nil
 }
 
-func (m *Manager) Destroy(id string) error {
+// Destroy ends the session, for example when the user logs out.
+func (m *Manager) Destroy(ctx context.Context, session *Session) error {
+	if err := m.destroy(ctx, session.Id); err != nil {
+		return err
+	}
+	if m.hooks.Destroyed != nil {
+		m.hooks.Destroyed(ctx, session)
+	}
+	return nil
+}
+
+func (m *Manager) destroy(ctx context.Context, id string) error {
 	m.mu.Lock()
 	delete(m.sessions, id)
 	m.mu.Unlock()
 
 	if m.repository != nil {
-		return m.repository.Delete(id)
+		return m.repository.Delete(ctx, id)
 	}
 
 	return nil
 }
 
+// DestroyOtherSessions logs the user out everywhere but in the session
+// exceptId, which may be empty, and revokes all of the user's remember-me
+// tokens.
+func (m *Manager) DestroyOtherSessions(ctx context.Context, userId, exceptId string) error {
+	m.mu.Lock()
+	for id, session := range m.sessions {
+		if session != nil && session.UserId == userId && id != exceptId {
+			delete(m.sessions, id)
+		}
+	}
+	m.mu.Unlock()
+
+	if m.repository != nil {
+		if err := m.repository.DeleteByUser(ctx, userId, exceptId); err != nil {
+			return err
+		}
+	}
+	if m.remember != nil {
+		if err := m.remember.Repository.DeleteByUser(ctx, userId); err != nil {
+			return err
+		}
+	}
+	if m.hooks.RevokedOthers != nil {
+		m.hooks.RevokedOthers(ctx, userId)
+	}
+	return nil
+}
+
+// UserSessions returns the unexpired sessions of the user.
+func (m *Manager) UserSessions(ctx context.Context, userId string) ([]Session, error) {
+	if m.repository != nil {
+		return m.repository.ListByUser(ctx, userId)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var sessions []Session
+	now := time.Now().UTC()
+	for _, session := range m.sessions {
+		if session != nil && session.UserId == userId && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
 func (m *Manager) SetSessionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var session *Session
 		cookie, err := r.Cookie(m.cookie.Name)
-		if err != nil {
-			next.ServeHTTP(w, r)
-			return
+		if err == nil {
+			session, err = m.Get(r.Context(), cookie.Value)
+			if err != nil {
+				m.clearCookie(w)
+				session = nil
+			}
 		}
 
-		session, err := m.Get(cookie.Value)
-		if err != nil {
-			m.clearCookie(w)
+		if session == nil && m.remember != nil {
+			session, err = m.restore(w, r)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+
+		if session == nil {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -227,18 +347,14 @@ func (m *Manager) RequireAuthenticationMiddleware(w http.ResponseWriter, r *http
 	return nil
 }
 
-func (m *Manager) SetCookie(w http.ResponseWriter, sessionId string, extended bool) {
+func (m *Manager) SetCookie(w http.ResponseWriter, sessionId string) {
 	signedId := m.signSessionId(sessionId)
-	maxAge := m.cookie.MaxAge
-	if extended {
-		maxAge = int(m.lifetimeExtended.Seconds())
-	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     m.cookie.Name,
 		Value:    signedId,
 		Path:     m.cookie.Path,
 		Domain:   m.cookie.Domain,
-		MaxAge:   maxAge,
+		MaxAge:   m.cookie.MaxAge,
 		Secure:   m.cookie.Secure,
 		HttpOnly: m.cookie.HttpOnly,
 		SameSite: m.cookie.SameSite,
@@ -262,7 +378,8 @@ func (m *Manager) GC() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	sessions, err := m.GetExpiredSessions()
+	ctx := context.Background()
+	sessions, err := m.GetExpiredSessions(ctx)
 	if err != nil {
 		log.Println(err)
 		return
@@ -270,7 +387,7 @@ func (m *Manager) GC() {
 	for _, session := range sessions {
 		delete(m.sessions, session.Id)
 		if (m.repository != nil) {
-			err = m.repository.Delete(session.Id)
+			err = m.repository.Delete(ctx, session.Id)
 			if err != nil {
 				log.Println(err)
 			}
@@ -288,6 +405,7 @@ func (m *Manager) RunGC() {
 		for range ticker.C {
 			log.Println("running session GC")
 			m.GC()
+			m.gcRememberTokens()
 		}
 	}()
 }
@@ -300,9 +418,21 @@ func (m *Manager) GetSession(ctx context.Context) (*Session, error) {
 	return session, nil
 }
 
-func (m *Manager) GetExpiredSessions() ([]Session, error) {
+// Invalidate destroys the session of the request, for example because its
+// user may no longer log in, and returns a context without it.
+func (m *Manager) Invalidate(ctx context.Context, w http.ResponseWriter) (context.Context, error) {
+	session, err := m.GetSession(ctx)
+	if err != nil {
+		return ctx, nil
+	}
+	m.clearCookie(w)
+	ctx = context.WithValue(ctx, SESSION_NAME, (*Session)(nil))
+	return ctx, m.Destroy(ctx, session)
+}
+
+func (m *Manager) GetExpiredSessions(ctx context.Context) ([]Session, error) {
 	if m.repository != nil {
-		return m.repository.GetExpired()
+		return m.repository.GetExpired(ctx)
 	}
 	var expired []Session
 	now := time.Now().UTC()
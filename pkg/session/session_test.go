@@ -0,0 +1,24 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthenticatedWithin(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		session Session
+		want    bool
+	}{
+		{"recent login", Session{CreatedAt: now.Add(-time.Minute)}, true},
+		{"old login", Session{CreatedAt: now.Add(-time.Hour)}, false},
+		{"restored", Session{CreatedAt: now, Data: map[string]any{rememberedKey: true}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.session.AuthenticatedWithin(10 * time.Minute); got != tt.want {
+			t.Errorf("%s: AuthenticatedWithin = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
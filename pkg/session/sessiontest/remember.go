@@ -0,0 +1,144 @@
+package sessiontest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"app/pkg/session"
+)
+
+// RememberFactory returns a remember-me repository over an empty, migrated
+// database.
+type RememberFactory func(t *testing.T) session.RememberRepository
+
+// RunRememberRepositoryTests runs every test of the remember-me suite against
+// repositories made by newRepo, one for each test.
+func RunRememberRepositoryTests(t *testing.T, newRepo RememberFactory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r session.RememberRepository)
+	}{
+		{"StoreAndGet", testRememberStoreAndGet},
+		{"GetUnknown", testRememberGetUnknown},
+		{"MarkUsed", testRememberMarkUsed},
+		{"DeleteSeries", testRememberDeleteSeries},
+		{"DeleteByUser", testRememberDeleteByUser},
+		{"GC", testRememberGC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func newToken(t *testing.T, r session.RememberRepository, selector, series, userId string, created time.Time, lifetime time.Duration) session.RememberToken {
+	t.Helper()
+	token := session.RememberToken{
+		Selector:      selector,
+		Series:        series,
+		UserId:        userId,
+		ValidatorHash: "hash-" + selector,
+		CreatedAt:     created,
+		ExpiresAt:     created.Add(lifetime),
+	}
+	if err := r.Store(ctx, &token); err != nil {
+		t.Fatalf("Store(%s): %v", selector, err)
+	}
+	return token
+}
+
+func getToken(t *testing.T, r session.RememberRepository, selector string) *session.RememberToken {
+	t.Helper()
+	token, err := r.Get(ctx, selector)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", selector, err)
+	}
+	return token
+}
+
+func wantTokenNotFound(t *testing.T, r session.RememberRepository, selector string) {
+	t.Helper()
+	token, err := r.Get(ctx, selector)
+	if !errors.Is(err, session.ErrRememberTokenNotFound) {
+		t.Fatalf("Get(%s) = %v, %v, want ErrRememberTokenNotFound", selector, token, err)
+	}
+}
+
+func testRememberStoreAndGet(t *testing.T, r session.RememberRepository) {
+	want := newToken(t, r, "selector", "series", "user", now(), time.Hour)
+	got := getToken(t, r, want.Selector)
+	if got.Selector != want.Selector || got.Series != want.Series || got.UserId != want.UserId || got.ValidatorHash != want.ValidatorHash {
+		t.Errorf("Get = %+v, want %+v", got, want)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("Get times %s %s, want %s %s", got.CreatedAt, got.ExpiresAt, want.CreatedAt, want.ExpiresAt)
+	}
+	if !got.UsedAt.IsZero() {
+		t.Errorf("Get of an unused token: used at %s", got.UsedAt)
+	}
+}
+
+func testRememberGetUnknown(t *testing.T, r session.RememberRepository) {
+	wantTokenNotFound(t, r, "unknown")
+}
+
+// testRememberMarkUsed checks that a token is marked once: a second request
+// presenting it is refused, so that only one of them rotates it.
+func testRememberMarkUsed(t *testing.T, r session.RememberRepository) {
+	newToken(t, r, "selector", "series", "user", now(), time.Hour)
+	used := now()
+	if err := r.MarkUsed(ctx, "selector", used); err != nil {
+		t.Fatal(err)
+	}
+	if got := getToken(t, r, "selector"); !got.UsedAt.Equal(used) {
+		t.Errorf("used at %s, want %s", got.UsedAt, used)
+	}
+	if err := r.MarkUsed(ctx, "selector", used.Add(time.Second)); !errors.Is(err, session.ErrRememberTokenUsed) {
+		t.Errorf("MarkUsed of a used token = %v, want ErrRememberTokenUsed", err)
+	}
+	if got := getToken(t, r, "selector"); !got.UsedAt.Equal(used) {
+		t.Errorf("used at %s after marking it again, want %s", got.UsedAt, used)
+	}
+	if err := r.MarkUsed(ctx, "unknown", used); !errors.Is(err, session.ErrRememberTokenUsed) {
+		t.Errorf("MarkUsed of an unknown token = %v, want ErrRememberTokenUsed", err)
+	}
+}
+
+func testRememberDeleteSeries(t *testing.T, r session.RememberRepository) {
+	created := now()
+	newToken(t, r, "first", "series", "user", created, time.Hour)
+	newToken(t, r, "rotated", "series", "user", created, time.Hour)
+	newToken(t, r, "other", "other", "user", created, time.Hour)
+	if err := r.DeleteSeries(ctx, "series"); err != nil {
+		t.Fatal(err)
+	}
+	wantTokenNotFound(t, r, "first")
+	wantTokenNotFound(t, r, "rotated")
+	getToken(t, r, "other")
+}
+
+func testRememberDeleteByUser(t *testing.T, r session.RememberRepository) {
+	created := now()
+	newToken(t, r, "a", "a", "user", created, time.Hour)
+	newToken(t, r, "b", "b", "user", created, time.Hour)
+	newToken(t, r, "c", "c", "other", created, time.Hour)
+	if err := r.DeleteByUser(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	wantTokenNotFound(t, r, "a")
+	wantTokenNotFound(t, r, "b")
+	getToken(t, r, "c")
+}
+
+func testRememberGC(t *testing.T, r session.RememberRepository) {
+	created := now()
+	newToken(t, r, "live", "live", "user", created, time.Hour)
+	newToken(t, r, "expired", "expired", "user", created.Add(-3*time.Hour), time.Hour)
+	if err := r.GC(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wantTokenNotFound(t, r, "expired")
+	getToken(t, r, "live")
+}
@@ -0,0 +1,252 @@
+// Package sessiontest is the conformance suite of session.SessionRepository
+// and session.RememberRepository. Every backend runs it from its tests with a
+// factory returning an empty repository:
+//
+//	func TestSessionRepositorySqlite(t *testing.T) {
+//		sessiontest.RunRepositoryTests(t, func(t *testing.T) session.SessionRepository {
+//			return session.NewSqliteRepository(dbtest.Sqlite(t))
+//		})
+//	}
+package sessiontest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"app/pkg/session"
+)
+
+// Factory returns a repository over an empty, migrated database.
+type Factory func(t *testing.T) session.SessionRepository
+
+// RunRepositoryTests runs every test of the suite against repositories made
+// by newRepo, one for each test.
+func RunRepositoryTests(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r session.SessionRepository)
+	}{
+		{"SetAndGet", testSetAndGet},
+		{"GetUnknown", testGetUnknown},
+		{"GetExpired", testGetExpired},
+		{"SetReplaces", testSetReplaces},
+		{"Delete", testDelete},
+		{"DeleteByUser", testDeleteByUser},
+		{"ListByUser", testListByUser},
+		{"GC", testGC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+var ctx = context.Background()
+
+// now is truncated to the second so that every backend stores it exactly.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func newSession(t *testing.T, r session.SessionRepository, id, userId string, created time.Time, lifetime time.Duration) session.Session {
+	t.Helper()
+	s := session.Session{
+		Id:        id,
+		UserId:    userId,
+		Data:      map[string]any{},
+		CreatedAt: created,
+		ExpiresAt: created.Add(lifetime),
+	}
+	if err := r.Set(ctx, &s); err != nil {
+		t.Fatalf("Set(%s): %v", id, err)
+	}
+	return s
+}
+
+func get(t *testing.T, r session.SessionRepository, id string) *session.Session {
+	t.Helper()
+	s, err := r.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", id, err)
+	}
+	if s == nil {
+		t.Fatalf("Get(%s) returned no session and no error", id)
+	}
+	return s
+}
+
+func wantNotFound(t *testing.T, r session.SessionRepository, id string) {
+	t.Helper()
+	s, err := r.Get(ctx, id)
+	if !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("Get(%s) = %v, %v, want ErrSessionNotFound", id, s, err)
+	}
+}
+
+func ids(sessions []session.Session) []string {
+	ids := make([]string, len(sessions))
+	for i, s := range sessions {
+		ids[i] = s.Id
+	}
+	return ids
+}
+
+func testSetAndGet(t *testing.T, r session.SessionRepository) {
+	created := now()
+	want := session.Session{
+		Id:     "session",
+		UserId: "user",
+		Data: map[string]any{
+			"user_id": "user",
+			"count":   float64(3),
+			"flags":   []any{"a", "b"},
+			"nested":  map[string]any{"remember": true},
+		},
+		CreatedAt: created,
+		ExpiresAt: created.Add(time.Hour),
+	}
+	if err := r.Set(ctx, &want); err != nil {
+		t.Fatal(err)
+	}
+	got := get(t, r, want.Id)
+	if got.Id != want.Id || got.UserId != want.UserId {
+		t.Errorf("Get = %+v, want %+v", got, want)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("Get times %s %s, want %s %s", got.CreatedAt, got.ExpiresAt, want.CreatedAt, want.ExpiresAt)
+	}
+	if got.Data["user_id"] != "user" || got.Data["count"] != float64(3) {
+		t.Errorf("Get data %v, want %v", got.Data, want.Data)
+	}
+	if flags, _ := got.Data["flags"].([]any); !slices.Equal(flags, []any{"a", "b"}) {
+		t.Errorf("Get data flags %v, want [a b]", got.Data["flags"])
+	}
+	if nested, _ := got.Data["nested"].(map[string]any); nested["remember"] != true {
+		t.Errorf("Get data nested %v, want remember true", got.Data["nested"])
+	}
+
+	empty := newSession(t, r, "empty", "user", created, time.Hour)
+	if got := get(t, r, empty.Id); got.Data == nil || len(got.Data) != 0 {
+		t.Errorf("Get data of a session without data = %#v, want an empty map", got.Data)
+	}
+}
+
+func testGetUnknown(t *testing.T, r session.SessionRepository) {
+	wantNotFound(t, r, "unknown")
+	newSession(t, r, "session", "user", now(), time.Hour)
+	wantNotFound(t, r, "Session")
+}
+
+func testGetExpired(t *testing.T, r session.SessionRepository) {
+	newSession(t, r, "expired", "user", now().Add(-2*time.Hour), time.Hour)
+	wantNotFound(t, r, "expired")
+}
+
+func testSetReplaces(t *testing.T, r session.SessionRepository) {
+	created := now()
+	s := newSession(t, r, "session", "user", created, time.Hour)
+	s.UserId = "other"
+	s.Data = map[string]any{"step": "second"}
+	s.ExpiresAt = created.Add(2 * time.Hour)
+	if err := r.Set(ctx, &s); err != nil {
+		t.Fatal(err)
+	}
+	got := get(t, r, s.Id)
+	if got.UserId != "other" || got.Data["step"] != "second" || !got.ExpiresAt.Equal(s.ExpiresAt) {
+		t.Errorf("Get after replacing = %+v, want %+v", got, s)
+	}
+	if sessions, err := r.ListByUser(ctx, "user"); err != nil || len(sessions) != 0 {
+		t.Errorf("sessions of the former user: %v, %v", ids(sessions), err)
+	}
+}
+
+func testDelete(t *testing.T, r session.SessionRepository) {
+	newSession(t, r, "session", "user", now(), time.Hour)
+	newSession(t, r, "other", "user", now(), time.Hour)
+	if err := r.Delete(ctx, "session"); err != nil {
+		t.Fatal(err)
+	}
+	wantNotFound(t, r, "session")
+	get(t, r, "other")
+	if err := r.Delete(ctx, "unknown"); err != nil {
+		t.Errorf("Delete unknown: %v", err)
+	}
+}
+
+func testDeleteByUser(t *testing.T, r session.SessionRepository) {
+	created := now()
+	newSession(t, r, "a", "user", created, time.Hour)
+	newSession(t, r, "b", "user", created, time.Hour)
+	newSession(t, r, "c", "user", created, time.Hour)
+	newSession(t, r, "d", "other", created, time.Hour)
+	if err := r.DeleteByUser(ctx, "user", "b"); err != nil {
+		t.Fatal(err)
+	}
+	wantNotFound(t, r, "a")
+	wantNotFound(t, r, "c")
+	get(t, r, "b")
+	get(t, r, "d")
+
+	// Without a session to keep, every session of the user goes.
+	if err := r.DeleteByUser(ctx, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+	wantNotFound(t, r, "b")
+	get(t, r, "d")
+}
+
+func testListByUser(t *testing.T, r session.SessionRepository) {
+	created := now()
+	newSession(t, r, "late", "user", created, time.Hour)
+	newSession(t, r, "early", "user", created.Add(-time.Hour), 2*time.Hour)
+	newSession(t, r, "expired", "user", created.Add(-3*time.Hour), time.Hour)
+	newSession(t, r, "other", "other", created, time.Hour)
+
+	sessions, err := r.ListByUser(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(sessions); !slices.Equal(got, []string{"early", "late"}) {
+		t.Errorf("ListByUser = %v, want [early late] oldest first", got)
+	}
+	for _, s := range sessions {
+		if s.UserId != "user" || s.Data == nil {
+			t.Errorf("listed session %+v", s)
+		}
+	}
+	sessions, err = r.ListByUser(ctx, "nobody")
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("ListByUser without sessions = %v, %v", ids(sessions), err)
+	}
+}
+
+func testGC(t *testing.T, r session.SessionRepository) {
+	created := now()
+	newSession(t, r, "live", "user", created, time.Hour)
+	newSession(t, r, "expired", "user", created.Add(-3*time.Hour), time.Hour)
+	newSession(t, r, "older", "other", created.Add(-5*time.Hour), time.Hour)
+
+	expired, err := r.GetExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ids(expired)
+	sort.Strings(got)
+	if !slices.Equal(got, []string{"expired", "older"}) {
+		t.Errorf("GetExpired = %v, want [expired older]", got)
+	}
+
+	if err := r.GC(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expired, err = r.GetExpired(ctx)
+	if err != nil || len(expired) != 0 {
+		t.Errorf("GetExpired after GC = %v, %v", ids(expired), err)
+	}
+	get(t, r, "live")
+}
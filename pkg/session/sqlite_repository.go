@@ -1,27 +1,44 @@
 package session
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"time"
+
+	"app/internal/core"
 )
 
 type SessionRepositorySqlite struct {
-	db *sql.DB
+	pools *core.Pools
 }
 
 func NewSqliteRepository(db *sql.DB) *SessionRepositorySqlite {
+	return NewSqlitePoolsRepository(db, db)
+}
+
+// NewSqlitePoolsRepository reads the sessions from reader, a pool of
+// query-only connections to the database of writer.
+func NewSqlitePoolsRepository(writer, reader *sql.DB) *SessionRepositorySqlite {
 	return &SessionRepositorySqlite{
-		db: db,
+		pools: core.NewPools(writer, reader),
 	}
 }
 
+func (r *SessionRepositorySqlite) write(ctx context.Context) core.DBTX {
+	return r.pools.Write(ctx)
+}
+
+func (r *SessionRepositorySqlite) read(ctx context.Context) core.DBTX {
+	return r.pools.Read(ctx)
+}
+
 type rowscan interface {
 	// Scan *sql.Row|Rows.Scan
 	Scan(dest ...any) error
 }
 
-func (r *SessionRepositorySqlite) scanSessionRow(row rowscan) (*Session, error) {
+func scanSessionRow(row rowscan) (*Session, error) {
 	var session Session
 	var data []byte
 	err := row.Scan(
@@ -33,7 +50,7 @@ func (r *SessionRepositorySqlite) scanSessionRow(row rowscan) (*Session, error)
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil
+			return nil, ErrSessionNotFound
 		}
 		return nil, err
 	}
@@ -44,21 +61,21 @@ func (r *SessionRepositorySqlite) scanSessionRow(row rowscan) (*Session, error)
 	return &session, nil
 }
 
-func (r *SessionRepositorySqlite) Get(id string) (*Session, error) {
+func (r *SessionRepositorySqlite) Get(ctx context.Context, id string) (*Session, error) {
 	query := `SELECT id, user_id, data, created_at, expires_at FROM sessions WHERE id = ? AND expires_at > ?`
-	return r.scanSessionRow(r.db.QueryRow(query, id, time.Now().UTC()))
+	return scanSessionRow(r.read(ctx).QueryRowContext(ctx, query, id, time.Now().UTC()))
 }
 
-func (r *SessionRepositorySqlite) GetExpired() ([]Session, error) {
+func (r *SessionRepositorySqlite) GetExpired(ctx context.Context) ([]Session, error) {
 	query := "SELECT id, user_id, data, created_at, expires_at FROM sessions WHERE expires_at < ?"
-	rows, err := r.db.Query(query, time.Now().UTC())
+	rows, err := r.read(ctx).QueryContext(ctx, query, time.Now().UTC())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var sessions []Session
 	for rows.Next() {
-		s, err := r.scanSessionRow(rows)
+		s, err := scanSessionRow(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -70,65 +87,62 @@ func (r *SessionRepositorySqlite) GetExpired() ([]Session, error) {
 	return sessions, nil
 }
 
-func (r *SessionRepositorySqlite) Set(session *Session) error {
-	dataJson, err := json.Marshal(session.Data)
+func (r *SessionRepositorySqlite) ListByUser(ctx context.Context, userId string) ([]Session, error) {
+	query := "SELECT id, user_id, data, created_at, expires_at FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY created_at"
+	rows, err := r.read(ctx).QueryContext(ctx, query, userId, time.Now().UTC())
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	tx, err := r.db.Begin()
-	if err != nil {
-		return err
+	defer rows.Close()
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSessionRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
 	}
-	defer tx.Rollback()
-
-	res, err := tx.Exec(`
-        UPDATE sessions
-        SET user_id = ?,
-            data = ?,
-            created_at = ?,
-            expires_at = ?
-        WHERE id = ?`,
-        session.UserId,
-        dataJson,
-        session.CreatedAt,
-        session.ExpiresAt,
-        session.Id,
-    )
-	if err != nil {
-		return err
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
+	return sessions, nil
+}
 
-	rows, err := res.RowsAffected()
+// Set upserts the session in a single statement, which holds the write lock
+// of SQLite no longer than it needs.
+func (r *SessionRepositorySqlite) Set(ctx context.Context, session *Session) error {
+	dataJson, err := json.Marshal(session.Data)
 	if err != nil {
 		return err
 	}
+	_, err = r.write(ctx).ExecContext(ctx, `
+		INSERT INTO sessions (id, user_id, data, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			user_id = excluded.user_id,
+			data = excluded.data,
+			created_at = excluded.created_at,
+			expires_at = excluded.expires_at`,
+		session.Id,
+		session.UserId,
+		dataJson,
+		session.CreatedAt,
+		session.ExpiresAt,
+	)
+	return err
+}
 
-	if rows == 0 {
-		_, err := tx.Exec(`
-            INSERT INTO sessions
-            (id, user_id, data, created_at, expires_at)
-            VALUES (?, ?, ?, ?, ?)`,
-            session.Id,
-            session.UserId,
-            dataJson,
-            session.CreatedAt,
-            session.ExpiresAt,
-        )
-        if err != nil {
-            return err
-        }
-	}
-
-	return tx.Commit()
+func (r *SessionRepositorySqlite) Delete(ctx context.Context, id string) error {
+	_, err := r.write(ctx).ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, id)
+	return err
 }
 
-func (r *SessionRepositorySqlite) Delete(id string) error {
-	_, err := r.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+func (r *SessionRepositorySqlite) DeleteByUser(ctx context.Context, userId, exceptId string) error {
+	_, err := r.write(ctx).ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ? AND id != ?`, userId, exceptId)
 	return err
 }
 
-func (r *SessionRepositorySqlite) GC() error {
-	_, err := r.db.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, time.Now().UTC())
+func (r *SessionRepositorySqlite) GC(ctx context.Context) error {
+	_, err := r.write(ctx).ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?`, time.Now().UTC())
 	return err
 }
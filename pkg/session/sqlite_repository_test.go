@@ -0,0 +1,51 @@
+//go:build sqlite_fts5
+
+package session_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"app/internal/core"
+	"app/internal/db/dbtest"
+	"app/pkg/session"
+	"app/pkg/session/sessiontest"
+)
+
+func TestSessionRepositorySqlite(t *testing.T) {
+	sessiontest.RunRepositoryTests(t, func(t *testing.T) session.SessionRepository {
+		return session.NewSqliteRepository(dbtest.Sqlite(t))
+	})
+}
+
+func TestRememberRepositorySqlite(t *testing.T) {
+	sessiontest.RunRememberRepositoryTests(t, func(t *testing.T) session.RememberRepository {
+		return session.NewRememberSqliteRepository(dbtest.Sqlite(t))
+	})
+}
+
+func TestSessionRepositorySqliteJoinsTransaction(t *testing.T) {
+	database := dbtest.Sqlite(t)
+	r := session.NewSqliteRepository(database)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	rollback := errors.New("rollback")
+	err := core.NewTxManager(database).WithinTx(ctx, func(ctx context.Context) error {
+		s := &session.Session{Id: "session", UserId: "user", Data: map[string]any{}, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := r.Set(ctx, s); err != nil {
+			return err
+		}
+		if _, err := r.Get(ctx, s.Id); err != nil {
+			t.Errorf("Get in the transaction: %v", err)
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ctx, "session"); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Errorf("Get after rollback = %v, want ErrSessionNotFound", err)
+	}
+}